@@ -104,11 +104,13 @@ func (a *Application) Run(ctx context.Context) error {
 
 	var wg sync.WaitGroup
 
+	systemdPlugin := &systemd.Plugin{}
+
 	err = a.pc.initPlugins(ctx, &wg, a.log,
-		&services.Plugin{},
+		&services.Plugin{Units: systemdPlugin},
 		&interfaces.Plugin{},
 		&dns.Plugin{},
-		&systemd.Plugin{},
+		systemdPlugin,
 		&system.Plugin{},
 	)
 
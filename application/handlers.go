@@ -208,6 +208,17 @@ func newMainPageHandler(a *Application) httpserver.Handler {
 	}
 }
 
+// actionData is the data of the action with the session which called it.
+type actionData struct {
+	*bytes.Buffer
+
+	callerID string
+}
+
+func (d *actionData) CallerID() string {
+	return d.callerID
+}
+
 type actionRequest struct {
 	CMD  string          `json:"cmd"`
 	Args []string        `json:"args"`
@@ -231,7 +242,10 @@ func newPluginActionHandler(a *Application) httpserver.Handler {
 			}
 
 			if command, ok := pluginInstance.Actions()[requestData.CMD]; ok {
-				return command(requestData.Args, bytes.NewBuffer(requestData.Data))
+				return command(requestData.Args, &actionData{
+					Buffer:   bytes.NewBuffer(requestData.Data),
+					callerID: getSession(ctx).id.String(),
+				})
 			}
 
 			return httpserver.NewError(http.StatusNotFound, "action not found")
@@ -268,6 +282,10 @@ func newPluginRenderHandler(a *Application) httpserver.Handler {
 				}
 			}
 
+			if cr, ok := pluginInstance.(callerRenderPlugin); ok {
+				return cr.RenderForCaller(getSession(ctx).id.String(), data.Args)
+			}
+
 			return pluginInstance.Render(data.Args)
 		},
 	}
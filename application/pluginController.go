@@ -82,6 +82,25 @@ func (i *pluginAPI) SendUpdate(updateData pluginTools.Update, args ...string) bo
 	}, i.moduleID, args)
 }
 
+type eventAlertOptions struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+func (i *pluginAPI) SendAlert(title string, text string, callerID string) bool {
+	return i.sessions.sendToSession(eventData{
+		EventType: "alert",
+		Options: eventAlertOptions{
+			Title: title,
+			Text:  text,
+		},
+	}, callerID)
+}
+
+func (i *pluginAPI) CallerUserName(callerID string) string {
+	return i.sessions.userName(callerID)
+}
+
 func (i *pluginAPI) Reload(args ...string) {
 	i.sessions.send(eventData{
 		EventType: "reload",
@@ -117,6 +136,11 @@ type subRendersPlugin interface {
 	SubRenders() []pluginTools.SubPageRender
 }
 
+// callerRenderPlugin renders the page depending on the session which requests it.
+type callerRenderPlugin interface {
+	RenderForCaller(callerID string, args []string) pluginTools.Page
+}
+
 type pluginSettings struct {
 	mx sync.Mutex
 
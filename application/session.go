@@ -18,7 +18,8 @@ type wsClient struct {
 	srcAddr string
 	conn    *websocket.Conn
 
-	mx *sync.Mutex
+	mx      *sync.Mutex
+	writeMx sync.Mutex
 
 	module string
 	args   []string
@@ -32,6 +33,35 @@ func (c *wsClient) setLocation(module string, args []string) {
 	c.args = args
 }
 
+// isViewing reports whether the client shows the module page with args starting with the given ones.
+func (c *wsClient) isViewing(module string, args []string) bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if module == "" {
+		return true
+	}
+
+	if module != c.module || len(args) > len(c.args) {
+		return false
+	}
+
+	for i, a := range args {
+		if c.args[i] != a {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *wsClient) writeJSON(data interface{}) error {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	return c.conn.WriteJSON(data)
+}
+
 type Session struct {
 	id        uuid.UUID
 	token     string
@@ -153,12 +183,12 @@ func (sm *sessionManager) send(data interface{}, module string, args []string) b
 
 	for _, s := range sm.sessions {
 		for _, c := range s.wsClients {
-			if module == "" || module == c.module {
+			if c.isViewing(module, args) {
 				wg.Add(1)
-				go func(conn *websocket.Conn, wg *sync.WaitGroup) {
+				go func(c *wsClient, wg *sync.WaitGroup) {
 					defer wg.Done()
-					_ = c.conn.WriteJSON(data)
-				}(c.conn, &wg)
+					_ = c.writeJSON(data)
+				}(c, &wg)
 
 				wasSent = true
 			}
@@ -169,3 +199,43 @@ func (sm *sessionManager) send(data interface{}, module string, args []string) b
 
 	return wasSent
 }
+
+// userName returns the user name of the session with the id.
+func (sm *sessionManager) userName(id string) string {
+	sm.mx.Lock()
+	defer sm.mx.Unlock()
+
+	for _, s := range sm.sessions {
+		if s.id.String() == id {
+			return s.userName
+		}
+	}
+
+	return ""
+}
+
+// sendToSession sends the data to all clients of the session with the id.
+func (sm *sessionManager) sendToSession(data interface{}, id string) bool {
+	sm.mx.Lock()
+	defer sm.mx.Unlock()
+
+	var wasSent bool
+
+	for _, s := range sm.sessions {
+		if s.id.String() != id {
+			continue
+		}
+
+		s.wsClientsMx.Lock()
+		clients := append([]*wsClient{}, s.wsClients...)
+		s.wsClientsMx.Unlock()
+
+		for _, c := range clients {
+			if c.writeJSON(data) == nil {
+				wasSent = true
+			}
+		}
+	}
+
+	return wasSent
+}
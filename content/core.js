@@ -21,6 +21,8 @@ let core = {
             this.selectedModuleArgs = data.args;
 
             await this.renderModule(true)
+
+            ws.setLocation(this.selectedModule, this.selectedSubModule, this.selectedModuleArgs)
         }
     },
 
@@ -117,9 +119,63 @@ let core = {
         switch (elementType) {
             case "progress":
                 core.updateProgress(id, data)
+                break
+            case "label":
+                core.updateLabel(id, data)
+                break
+            case "chart":
+                core.updateChart(id, data)
+                break
+            case "log-view":
+                core.updateLogView(id, data)
+        }
+    },
+
+    updateLogView: function (id, data) {
+        let el = document.getElementById(id)
+        if (!el || !el.logOptions) {
+            return
+        }
+
+        let lines = el.logOptions.lines
+
+        lines.push(...data.lines)
+
+        if (lines.length > el.logOptions.size) {
+            lines.splice(0, lines.length - el.logOptions.size)
+        }
+
+        uiTool.appendLogLines(el, data.lines)
+    },
+
+    updateLabel: function (id, data) {
+        let el = document.getElementById(id)
+        if (el) {
+            el.textContent = data.text
         }
     },
 
+    updateChart: function (id, data) {
+        let el = document.getElementById(id)
+        if (!el || !el.chartOptions) {
+            return
+        }
+
+        let options = el.chartOptions
+
+        for (let i = 0; i < options.series.length && i < data.values.length; i++) {
+            let values = options.series[i].values
+
+            values.push(data.values[i])
+
+            if (values.length > options.size) {
+                values.splice(0, values.length - options.size)
+            }
+        }
+
+        uiTool.drawChart(el)
+    },
+
     updateProgress: function (id, data) {
         let el = document.querySelector(`div#${id}.progress`)
         if (el) {
@@ -321,6 +377,8 @@ let core = {
                 core.pushState();
                 await core.renderModule(data.options.fade);
 
+                ws.setLocation(this.selectedModule, this.selectedSubModule, this.selectedModuleArgs)
+
                 return;
             case "alert":
                 core.postToast(data.options.title, data.options.text)
@@ -352,6 +410,17 @@ let core = {
                     ]},
                 ], parent: document.body, cb: function (e) { modal = e }})
 
+                return
+            case "download":
+                let bytes = Uint8Array.from(atob(data.options.data || ""), c => c.charCodeAt(0))
+                let link = document.createElement("a")
+
+                link.href = URL.createObjectURL(new Blob([bytes], {type: data.options["content-type"]}))
+                link.download = data.options["file-name"]
+                link.click()
+
+                URL.revokeObjectURL(link.href)
+
                 return
             case "part-update":
                 let updatedElement = document.getElementById(`updated-element-${data.options.id}`)
@@ -14,7 +14,7 @@ let uiTool = {
             classes.push("font-monospace")
         }
 
-        return {tag: "span", classes: classes, el: uiTool.createLabelElements(options)}
+        return {tag: "span", id: options.id, classes: classes, el: uiTool.createLabelElements(options)}
     },
 
     formLabel: function(options) {
@@ -630,6 +630,136 @@ let uiTool = {
         }}
     },
 
+    chart: function(options) {
+        return {tag: "div", id: options.id, classes: ["chart"], cb: function(e) {
+            e.chartOptions = options
+            uiTool.drawChart(e)
+        }}
+    },
+
+    drawChart: function(el) {
+        let options = el.chartOptions
+        let max = 0
+
+        for (const s of options.series) {
+            for (const v of s.values) {
+                max = Math.max(max, v)
+            }
+        }
+
+        let svg = ui.svgElement("svg")
+        svg.setAttributeNS(null, "viewBox", `0 0 ${options.size} 100`)
+        svg.setAttributeNS(null, "preserveAspectRatio", "none")
+
+        let legend = []
+
+        for (const s of options.series) {
+            let offset = options.size - s.values.length
+            let points = []
+
+            for (let i = 0; i < s.values.length; i++) {
+                let y = max > 0 ? 100 - (s.values[i] / max) * 95 : 100
+                points.push(`${offset + i},${y}`)
+            }
+
+            let line = ui.svgElement("polyline")
+            line.setAttributeNS(null, "points", points.join(" "))
+            line.setAttributeNS(null, "vector-effect", "non-scaling-stroke")
+            line.style.stroke = `var(--bs-${s.style})`
+            svg.appendChild(line)
+
+            let last = s.values.length > 0 ? s.values[s.values.length - 1] : 0
+
+            legend.push({tag: "span", classes: ["badge", `bg-${s.style}`, "me-2"], text: `${s.title}: ${uiTool.formatValue(last, options.unit)}`})
+        }
+
+        legend.push({tag: "small", classes: ["text-secondary"], text: `max: ${uiTool.formatValue(max, options.unit)}`})
+
+        ui.clear(el)
+        el.append(svg, ui.build({tag: "div", el: legend}))
+    },
+
+    formatValue: function(value, unit) {
+        let prefixes = ["", "K", "M", "G", "T"]
+        let i = 0
+
+        while (value >= 1000 && i < prefixes.length - 1) {
+            value /= 1000
+            i++
+        }
+
+        return `${value.toFixed(1)} ${prefixes[i]}${unit ? unit : ""}`
+    },
+
+    logView: function(options) {
+        return {tag: "div", classes: ["log-view"], el: [
+            {tag: "div", classes: ["d-flex", "mb-2"], el: [
+                {tag: "input", type: "text", placeholder: "Filter", classes: ["form-control", "form-control-sm", "me-2"], oninput: function(e, el) {
+                    el.closest(".log-view").querySelector(".log-lines").logFilter.text = el.value
+                    uiTool.drawLogView(el.closest(".log-view").querySelector(".log-lines"))
+                }},
+                {tag: "select", classes: ["form-select", "form-select-sm", "w-auto"], el: [
+                    {tag: "option", value: "", text: "All streams"},
+                    {tag: "option", value: "stdout", text: "stdout"},
+                    {tag: "option", value: "stderr", text: "stderr"},
+                ], onchange: function(e, el) {
+                    el.closest(".log-view").querySelector(".log-lines").logFilter.stream = el.value
+                    uiTool.drawLogView(el.closest(".log-view").querySelector(".log-lines"))
+                }},
+            ]},
+            {tag: "pre", id: options.id, classes: ["log-lines", "border", "rounded", "p-2"], cb: function(e) {
+                e.logOptions = options
+                e.logFilter = {text: "", stream: ""}
+                uiTool.drawLogView(e)
+            }},
+        ]}
+    },
+
+    logLineVisible: function(filter, line) {
+        if (filter.stream && line.stream !== filter.stream) {
+            return false
+        }
+
+        return !filter.text || line.text.toLowerCase().includes(filter.text.toLowerCase())
+    },
+
+    logLineElement: function(line) {
+        let classes = []
+
+        if (line.style) {
+            classes.push(`text-${line.style}`)
+        }
+
+        return ui.build({tag: "div", classes: classes, el: [
+            {tag: "span", classes: ["text-secondary", "me-2"], text: line.time || ""},
+            {tag: "span", text: line.text},
+        ]})
+    },
+
+    appendLogLines: function(el, lines) {
+        let scrolled = el.scrollTop + el.clientHeight >= el.scrollHeight - 5
+
+        for (const line of lines) {
+            if (uiTool.logLineVisible(el.logFilter, line)) {
+                el.append(uiTool.logLineElement(line))
+            }
+        }
+
+        while (el.childElementCount > el.logOptions.size) {
+            el.removeChild(el.firstChild)
+        }
+
+        if (scrolled) {
+            el.scrollTop = el.scrollHeight
+        }
+    },
+
+    drawLogView: function(el) {
+        ui.clear(el)
+        uiTool.appendLogLines(el, el.logOptions.lines)
+        el.scrollTop = el.scrollHeight
+    },
+
     updatedElement: function(options) {
         return {tag: "div", id: `updated-element-${options.id}`, el: [
             uiTool.createElement(options.element)
@@ -692,6 +822,10 @@ let uiTool = {
                 return uiTool.terminal(element.options);
             case "updated-element":
                 return uiTool.updatedElement(element.options);
+            case "chart":
+                return uiTool.chart(element.options);
+            case "log-view":
+                return uiTool.logView(element.options);
             default:
                 console.warn(`un know element type: ${element.type}`);
         }
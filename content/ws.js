@@ -26,6 +26,9 @@ let ws = {
             case "update":
                 core.update(data.options.id, data.options.element, data.options.data).then()
                 break;
+            case "alert":
+                core.postToast(data.options.title, data.options.text)
+                break;
         }
     },
 
@@ -6,16 +6,17 @@ require (
 	github.com/GehirnInc/crypt v0.0.0-20200316065508-bb7000b8a962
 	github.com/coreos/go-systemd/v22 v22.3.2
 	github.com/dedalqq/omg.httpserver v1.5.1
+	github.com/godbus/dbus/v5 v5.0.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/insomniacslk/dhcp v0.0.0-20220119180841-3c283ff8b7dd
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/vishvananda/netlink v1.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
 	github.com/u-root/uio v0.0.0-20210528114334-82958018845c // indirect
 	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df // indirect
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
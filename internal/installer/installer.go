@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"runtime"
 	"strings"
 
@@ -24,6 +25,19 @@ const (
 	defaultConfigPath = "/etc/qubert/config.json"
 )
 
+// unitSettings are added to the unit installed by older versions when they are missing:
+// restarting qubert stops only qubert, so managed services keep running, and the cgroup
+// of the unit is delegated to qubert for limits of services, qubert itself runs in the
+// supervisor group because a cgroup with controllers enabled for children can't have processes.
+var unitSettings = []struct {
+	re   *regexp.Regexp
+	line string
+}{
+	{regexp.MustCompile(`(?m)^KillMode=`), "KillMode=process"},
+	{regexp.MustCompile(`(?m)^Delegate=`), "Delegate=yes"},
+	{regexp.MustCompile(`(?m)^DelegateSubgroup=`), "DelegateSubgroup=supervisor"},
+}
+
 type githubApiResult struct {
 	Name   string `json:""`
 	Assets []struct {
@@ -187,6 +201,9 @@ func installSystemdConfig(binFilePath string, configFilePath string) error {
 		"[Service]",
 		"Restart=always",
 		"Type=simple",
+		"KillMode=process",
+		"Delegate=yes",
+		"DelegateSubgroup=supervisor",
 		"ExecStart=%s -c %s",
 		"",
 		"[Install]",
@@ -203,3 +220,38 @@ func installSystemdConfig(binFilePath string, configFilePath string) error {
 
 	return exec.Command("/bin/systemctl", "daemon-reload").Run()
 }
+
+// MigrateSystemdUnit adds the unit settings which are missing in the unit installed by older versions.
+func MigrateSystemdUnit(log *logger.Logger) error {
+	content, err := ioutil.ReadFile(systemdUnitFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var missing []string
+
+	for _, us := range unitSettings {
+		if !us.re.Match(content) {
+			missing = append(missing, us.line)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	log.Info("Add %s to the systemd unit", strings.Join(missing, ", "))
+
+	content = []byte(strings.Replace(string(content), "[Service]\n", "[Service]\n"+strings.Join(missing, "\n")+"\n", 1))
+
+	err = ioutil.WriteFile(systemdUnitFile, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	return exec.Command("/bin/systemctl", "daemon-reload").Run()
+}
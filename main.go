@@ -12,6 +12,7 @@ import (
 	"qubert/internal/logger"
 
 	"qubert/application"
+	"qubert/plugins/services"
 )
 
 var (
@@ -27,6 +28,9 @@ type options struct {
 }
 
 func main() {
+	// services with limits are started by a copy of qubert, which execs them
+	services.RunWrapper()
+
 	err := mainFunc(os.Args)
 
 	if err != nil {
@@ -133,6 +137,12 @@ Git commit: %s
 		return nil
 	}
 
+	// the unit of older versions kills managed services on restart
+	err = installer.MigrateSystemdUnit(logger.CreateLogger(false))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to migrate systemd unit: %v\n", err)
+	}
+
 	ctx := context.Background()
 
 	app := application.NewApplication(cfg, Version, Commit)
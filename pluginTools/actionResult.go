@@ -8,6 +8,7 @@ const (
 	ActionTypeArgs                  = "set-args"
 	ActionTypeAlert                 = "alert"
 	ActionTypePartUpdate            = "part-update"
+	ActionTypeDownload              = "download"
 )
 
 type ActionResult struct {
@@ -123,3 +124,20 @@ func NewPartUpdateActionResult(elementID string, el Element) ActionResult {
 		},
 	}
 }
+
+type ActionResultDownloadOptions struct {
+	FileName    string `json:"file-name"`
+	ContentType string `json:"content-type"`
+	Data        []byte `json:"data"`
+}
+
+func NewDownloadActionResult(fileName string, contentType string, data []byte) ActionResult {
+	return ActionResult{
+		ActionType: ActionTypeDownload,
+		Options: ActionResultDownloadOptions{
+			FileName:    fileName,
+			ContentType: contentType,
+			Data:        data,
+		},
+	}
+}
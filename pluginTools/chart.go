@@ -0,0 +1,67 @@
+package pluginTools
+
+type ChartSeries struct {
+	Title  string       `json:"title"`
+	Style  ElementStyle `json:"style"`
+	Values []float64    `json:"values"`
+}
+
+type ChartOptions struct {
+	ID     string         `json:"id"`
+	Size   int            `json:"size"`
+	Unit   string         `json:"unit,omitempty"`
+	Series []*ChartSeries `json:"series"`
+}
+
+type Chart struct {
+	options ChartOptions
+}
+
+func (c *Chart) Type() ElementType            { return ElementChart }
+func (c *Chart) MarshalJSON() ([]byte, error) { return MarshalJSON(c.Type(), c.options) }
+
+func (c *Chart) SetUnit(unit string) *Chart {
+	c.options.Unit = unit
+
+	return c
+}
+
+func (c *Chart) AddSeries(title string, style ElementStyle, values ...float64) *Chart {
+	if values == nil {
+		values = []float64{}
+	}
+
+	c.options.Series = append(c.options.Series, &ChartSeries{
+		Title:  title,
+		Style:  style,
+		Values: values,
+	})
+
+	return c
+}
+
+func NewChart(id string, size int) *Chart {
+	return &Chart{
+		options: ChartOptions{
+			ID:     id,
+			Size:   size,
+			Series: []*ChartSeries{},
+		},
+	}
+}
+
+type UpdateChart struct {
+	id string
+
+	Values []float64 `json:"values"`
+}
+
+func (c *UpdateChart) ElementID() string       { return c.id }
+func (c *UpdateChart) UpdateType() ElementType { return ElementChart }
+
+func NewUpdateChart(id string, values ...float64) *UpdateChart {
+	return &UpdateChart{
+		id:     id,
+		Values: values,
+	}
+}
@@ -10,6 +10,8 @@ type PluginAPI interface {
 	LoadModuleConfig(cfg interface{}) error
 	Send(data interface{}, args ...string)
 	SendUpdate(updateData Update, args ...string) bool
+	SendAlert(title string, text string, callerID string) bool
+	CallerUserName(callerID string) string
 	Reload(args ...string)
 	SafeRun(f func())
 	Exit()
@@ -25,6 +27,20 @@ type SubPageRender struct {
 
 type ActionsMap map[string]func(args []string, data io.Reader) ActionResult
 
+// ActionCaller is implemented by the action data, CallerID identifies the session which called the action.
+type ActionCaller interface {
+	CallerID() string
+}
+
+// CallerID returns the caller of the action or an empty string.
+func CallerID(data io.Reader) string {
+	if c, ok := data.(ActionCaller); ok {
+		return c.CallerID()
+	}
+
+	return ""
+}
+
 type ElementStyle string
 
 const (
@@ -66,6 +82,8 @@ const (
 	ElementLine                         = "line"
 	ElementProgress                     = "progress"
 	ElementTerminal                     = "terminal"
+	ElementChart                        = "chart"
+	ElementLogView                      = "log-view"
 	ElementUpdated                      = "updated-element"
 )
 
@@ -1 +1,117 @@
 package pluginTools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sizeSuffixes = map[string]uint64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses the size like 512M with an optional K, M, G or T suffix of base 1024.
+func ParseSize(v string) (uint64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+
+	i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(v)
+	}
+
+	multiplier, ok := sizeSuffixes[v[i:]]
+	if !ok || i == 0 {
+		return 0, errors.New("size must be like 512M or 2G")
+	}
+
+	n, err := strconv.ParseUint(v[:i], 10, 64)
+	if err != nil || n > math.MaxUint64/multiplier {
+		return 0, errors.New("size is too big")
+	}
+
+	return n * multiplier, nil
+}
+
+// FormatBytes formats the size in bytes with binary units like 1.5 MiB.
+func FormatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func splitLines(data string) []string {
+	if data == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+}
+
+// DiffLines returns the line diff of two texts based on the longest common subsequence.
+// Lines are marked by the stream: " " for unchanged, "-" for removed and "+" for added ones.
+func DiffLines(before string, after string) []*LogLine {
+	a, b := splitLines(before), splitLines(after)
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var (
+		lines []*LogLine
+		i, j  int
+	)
+
+	removed := func(text string) { lines = append(lines, &LogLine{Stream: "-", Text: text, Style: StyleDanger}) }
+	added := func(text string) { lines = append(lines, &LogLine{Stream: "+", Text: text, Style: StyleSuccess}) }
+
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, &LogLine{Stream: " ", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed(a[i])
+			i++
+		default:
+			added(b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		removed(a[i])
+	}
+
+	for ; j < len(b); j++ {
+		added(b[j])
+	}
+
+	return lines
+}
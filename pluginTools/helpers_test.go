@@ -0,0 +1,81 @@
+package pluginTools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		diff   string
+	}{
+		{"empty", "", "", ""},
+		{"new file", "", "a\nb\n", "+a +b"},
+		{"removed file", "a\nb\n", "", "-a -b"},
+		{"unchanged", "a\nb\n", "a\nb\n", " a  b"},
+		{"no trailing newline", "a\nb", "a\nb\n", " a  b"},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", " a -b +B  c"},
+		{"inserted lines", "a\nd\n", "a\nb\nc\nd\n", " a +b +c  d"},
+		{"removed lines", "a\nb\nc\nd\n", "a\nd\n", " a -b -c  d"},
+		{"moved line", "a\nb\nc\n", "b\nc\na\n", "-a  b  c +a"},
+		{"repeated lines", "x\nx\ny\n", "x\ny\nx\n", " x -x  y +x"},
+		{
+			"swapped keys",
+			"[Service]\nExecStart=/bin/a\nUser=root\n",
+			"[Service]\nUser=nobody\nExecStart=/bin/a\n",
+			" [Service] +User=nobody  ExecStart=/bin/a -User=root",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var parts []string
+			for _, l := range DiffLines(tt.before, tt.after) {
+				parts = append(parts, l.Stream+l.Text)
+
+				if style := map[string]ElementStyle{"+": StyleSuccess, "-": StyleDanger}[l.Stream]; l.Style != style {
+					t.Errorf("line [%s%s] has style [%s]", l.Stream, l.Text, l.Style)
+				}
+			}
+
+			if diff := strings.Join(parts, " "); diff != tt.diff {
+				t.Errorf("got [%s], expected [%s]", diff, tt.diff)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		size  uint64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"512", 512, true},
+		{"512K", 512 << 10, true},
+		{"512m", 512 << 20, true},
+		{" 2G ", 2 << 30, true},
+		{"3T", 3 << 40, true},
+		{"16777215T", 16777215 << 40, true},
+		{"16777216T", 0, false},
+		{"99999999999999T", 0, false},
+		{"18446744073709551616", 0, false},
+		{"", 0, false},
+		{"M", 0, false},
+		{"1.5G", 0, false},
+		{"-1", 0, false},
+		{"1KB", 0, false},
+	}
+
+	for _, tt := range tests {
+		size, err := ParseSize(tt.value)
+
+		if ok := err == nil; ok != tt.ok || size != tt.size {
+			t.Errorf("[%s]: got %d, %v, expected %d", tt.value, size, err, tt.size)
+		}
+	}
+}
@@ -5,7 +5,8 @@ import "fmt"
 type LabelOption struct {
 	LabelData
 
-	Monospace bool `json:"monospace"`
+	ID        string `json:"id,omitempty"`
+	Monospace bool   `json:"monospace"`
 }
 
 type Label struct {
@@ -39,6 +40,12 @@ func (l *Label) SetMonospace(value bool) *Label {
 	return l
 }
 
+func (l *Label) SetID(id string) *Label {
+	l.options.ID = id
+
+	return l
+}
+
 func NewLabel(text string, a ...interface{}) *Label {
 	return &Label{
 		options: LabelOption{
@@ -48,3 +55,19 @@ func NewLabel(text string, a ...interface{}) *Label {
 		},
 	}
 }
+
+type UpdateLabel struct {
+	id string
+
+	Text string `json:"text"`
+}
+
+func (l *UpdateLabel) ElementID() string       { return l.id }
+func (l *UpdateLabel) UpdateType() ElementType { return ElementTypeLabel }
+
+func NewUpdateLabel(id string, text string, a ...interface{}) *UpdateLabel {
+	return &UpdateLabel{
+		id:   id,
+		Text: fmt.Sprintf(text, a...),
+	}
+}
@@ -0,0 +1,53 @@
+package pluginTools
+
+type LogLine struct {
+	Time   string       `json:"time,omitempty"`
+	Stream string       `json:"stream,omitempty"`
+	Style  ElementStyle `json:"style,omitempty"`
+	Text   string       `json:"text"`
+}
+
+type LogViewOptions struct {
+	ID    string     `json:"id"`
+	Size  int        `json:"size"`
+	Lines []*LogLine `json:"lines"`
+}
+
+type LogView struct {
+	options LogViewOptions
+}
+
+func (l *LogView) Type() ElementType            { return ElementLogView }
+func (l *LogView) MarshalJSON() ([]byte, error) { return MarshalJSON(l.Type(), l.options) }
+
+func (l *LogView) AddLines(lines ...*LogLine) *LogView {
+	l.options.Lines = append(l.options.Lines, lines...)
+
+	return l
+}
+
+func NewLogView(id string, size int) *LogView {
+	return &LogView{
+		options: LogViewOptions{
+			ID:    id,
+			Size:  size,
+			Lines: []*LogLine{},
+		},
+	}
+}
+
+type UpdateLogView struct {
+	id string
+
+	Lines []*LogLine `json:"lines"`
+}
+
+func (l *UpdateLogView) ElementID() string       { return l.id }
+func (l *UpdateLogView) UpdateType() ElementType { return ElementLogView }
+
+func NewUpdateLogView(id string, lines ...*LogLine) *UpdateLogView {
+	return &UpdateLogView{
+		id:    id,
+		Lines: lines,
+	}
+}
@@ -14,6 +14,10 @@ func (t *TagsEdit) Type() ElementType            { return ElementTagsEdit }
 func (t *TagsEdit) MarshalJSON() ([]byte, error) { return MarshalJSON(t.Type(), t.options) }
 
 func NewTagsEdit(name string, value []string, cmd string, args ...string) *TagsEdit {
+	if value == nil {
+		value = []string{}
+	}
+
 	return &TagsEdit{
 		options: TagsEditOptions{
 			Action: Action{
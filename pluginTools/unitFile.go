@@ -0,0 +1,95 @@
+package pluginTools
+
+import (
+	"bufio"
+	"strings"
+)
+
+// UnitFile is a file in the systemd unit format, which is used by units and networkd files.
+// Sections with the same name are merged.
+type UnitFile map[string]UnitSection
+
+// UnitSection holds values of the keys in the order of the file, keys may be repeated.
+type UnitSection map[string][]string
+
+// Last returns the last value of the key, it overrides the previous ones for single value keys.
+func (us UnitSection) Last(key string) string {
+	if v := us[key]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+
+	return ""
+}
+
+// EscapeUnitValue escapes specifiers, so the value is written to the unit file as is.
+func EscapeUnitValue(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// UnescapeUnitValue reverts EscapeUnitValue.
+func UnescapeUnitValue(value string) string {
+	return strings.ReplaceAll(value, "%%", "%")
+}
+
+// ParseUnitFile parses the file like systemd: lines ending with a backslash are continued,
+// comments start with # or ; and an empty value resets the list of the key.
+func ParseUnitFile(content string) UnitFile {
+	var (
+		file    = UnitFile{}
+		current UnitSection
+		prev    string
+	)
+
+	parse := func(text string) {
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			name := text[1 : len(text)-1]
+
+			current = file[name]
+			if current == nil {
+				current = UnitSection{}
+				file[name] = current
+			}
+		case current != nil:
+			kv := strings.SplitN(text, "=", 2)
+			if len(kv) != 2 {
+				return
+			}
+
+			key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+			if value == "" {
+				delete(current, key)
+				return
+			}
+
+			current[key] = append(current[key], value)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if prev != "" {
+			text = prev + " " + text
+			prev = ""
+		}
+
+		if strings.HasSuffix(text, "\\") {
+			prev = strings.TrimSpace(strings.TrimSuffix(text, "\\"))
+			continue
+		}
+
+		parse(text)
+	}
+
+	parse(prev)
+
+	return file
+}
@@ -0,0 +1,61 @@
+package pluginTools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUnitFile(t *testing.T) {
+	content := `# comment
+Key=outside of a section
+[Unit]
+Description = Test unit
+After=a.service
+After=b.service
+
+[Service]
+ExecStart=/bin/echo \
+  # comments are skipped in continued lines
+  first \
+  second
+Environment=A=1
+Environment=
+Environment=B=2
+; comment
+broken line
+
+[Network]
+Address=10.0.0.1/24
+[Network]
+Address=10.0.0.2/24
+DNS=last \`
+
+	expected := UnitFile{
+		"Unit": {
+			"Description": {"Test unit"},
+			"After":       {"a.service", "b.service"},
+		},
+		"Service": {
+			"ExecStart":   {"/bin/echo first second"},
+			"Environment": {"B=2"},
+		},
+		"Network": {
+			"Address": {"10.0.0.1/24", "10.0.0.2/24"},
+			"DNS":     {"last"},
+		},
+	}
+
+	f := ParseUnitFile(content)
+
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("got %v, expected %v", f, expected)
+	}
+
+	if v := f["Unit"].Last("After"); v != "b.service" {
+		t.Errorf("last value is [%s]", v)
+	}
+
+	if v := f["Missing"].Last("After"); v != "" {
+		t.Errorf("value of the missing section is [%s]", v)
+	}
+}
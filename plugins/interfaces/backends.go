@@ -0,0 +1,611 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+
+	. "qubert/pluginTools"
+)
+
+const generatedHeader = "generated by qubert, manual changes will be lost"
+
+// configFile is a file rendered by a config backend.
+type configFile struct {
+	path string
+	perm os.FileMode
+	data string
+}
+
+// configBackend renders the managed settings to config files of a network manager
+// and reads the existing files of this manager back.
+type configBackend interface {
+	name() string
+	title() string
+
+	// render returns config files and warnings about options which can't be exported.
+	render(ps *PluginSettings) ([]*configFile, []string)
+	// generatedFiles returns paths of the files which were written by the backend before.
+	generatedFiles() ([]string, error)
+	// load reads the existing configuration of the network manager.
+	load() (*PluginSettings, error)
+}
+
+var configBackends = []configBackend{
+	&networkdBackend{},
+	&netplanBackend{},
+	&ifupdownBackend{},
+}
+
+var errIncorrectArgs = errors.New("incorrect arguments")
+
+// configBackendByName returns the backend with the name, the first one is used when no backend is chosen.
+func configBackendByName(name string) (configBackend, error) {
+	if name == "" {
+		return configBackends[0], nil
+	}
+
+	for _, b := range configBackends {
+		if b.name() == name {
+			return b, nil
+		}
+	}
+
+	return nil, errors.Errorf("unknown config backend [%s]", name)
+}
+
+// fileChange is a difference between the rendered file and the file on the disk.
+// Empty newData means that the file should be removed.
+type fileChange struct {
+	path string
+	perm os.FileMode
+
+	oldData string
+	newData string
+	exist   bool
+}
+
+func (c *fileChange) status() (string, ElementStyle) {
+	switch {
+	case !c.exist:
+		return "new", StyleSuccess
+	case c.newData == "":
+		return "removed", StyleDanger
+	case c.oldData != c.newData:
+		return "changed", StyleWarning
+	default:
+		return "unchanged", StyleSecondary
+	}
+}
+
+func readFileIfExist(path string) (string, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	return string(data), true, nil
+}
+
+func configChanges(b configBackend, ps *PluginSettings) ([]*fileChange, []string, error) {
+	files, warnings := b.render(ps)
+
+	var changes []*fileChange
+
+	rendered := make(map[string]bool, len(files))
+
+	for _, f := range files {
+		oldData, exist, err := readFileIfExist(f.path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rendered[f.path] = true
+
+		changes = append(changes, &fileChange{
+			path:    f.path,
+			perm:    f.perm,
+			oldData: oldData,
+			newData: f.data,
+			exist:   exist,
+		})
+	}
+
+	generated, err := b.generatedFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, path := range generated {
+		if rendered[path] {
+			continue
+		}
+
+		oldData, exist, err := readFileIfExist(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		changes = append(changes, &fileChange{
+			path:    path,
+			oldData: oldData,
+			exist:   exist,
+		})
+	}
+
+	return changes, warnings, nil
+}
+
+func writeChanges(changes []*fileChange) error {
+	for _, c := range changes {
+		if c.newData == "" {
+			err := os.Remove(c.path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			continue
+		}
+
+		if c.oldData == c.newData {
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(c.path), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(c.path, []byte(c.newData), c.perm)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write [%s]", c.path)
+		}
+	}
+
+	return nil
+}
+
+// generatedFilesByPattern returns files matched by the pattern which contain the generated header.
+func generatedFilesByPattern(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for _, path := range paths {
+		data, _, err := readFileIfExist(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(data, generatedHeader) {
+			result = append(result, path)
+		}
+	}
+
+	return result, nil
+}
+
+// iniFile is a networkd file, lists in its values are separated by spaces.
+type iniFile UnitFile
+
+func parseIni(data string) iniFile {
+	return iniFile(ParseUnitFile(data))
+}
+
+// get returns all values of the key in all sections with the name.
+func (f iniFile) get(section, key string) []string {
+	var result []string
+
+	for _, v := range f[section][key] {
+		result = append(result, strings.Fields(v)...)
+	}
+
+	return result
+}
+
+func (f iniFile) first(section, key string) string {
+	values := f.get(section, key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+type iniBuilder struct {
+	strings.Builder
+}
+
+// section writes the section with the key-value pairs, pairs with empty values are skipped.
+func (b *iniBuilder) section(name string, kv ...string) {
+	var lines []string
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] == "" {
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s=%s", kv[i], kv[i+1]))
+	}
+
+	if len(lines) == 0 {
+		return
+	}
+
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString(fmt.Sprintf("[%s]\n", name))
+
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+}
+
+func itoa(v int) string {
+	if v == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d", v)
+}
+
+// managedLinkNames returns sorted names of the interfaces and devices from the settings.
+func (ps *PluginSettings) managedLinkNames() []string {
+	exist := make(map[string]bool)
+
+	for _, d := range ps.Devices {
+		exist[d.Name] = true
+	}
+
+	for _, i := range ps.Interfaces {
+		exist[i.Name] = true
+	}
+
+	names := make([]string, 0, len(exist))
+	for name := range exist {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (ps *PluginSettings) deviceByName(name string) *deviceConfig {
+	for _, d := range ps.Devices {
+		if d.Name == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func (ps *PluginSettings) interfaceConfig(name string) *interfaceConfig {
+	for _, i := range ps.Interfaces {
+		if i.Name == name {
+			return i
+		}
+	}
+
+	return &interfaceConfig{Name: name}
+}
+
+// bondOf returns the bond device which has the interface as a slave.
+func (ps *PluginSettings) bondOf(name string) string {
+	for _, d := range ps.Devices {
+		if d.Type != devTypeBond {
+			continue
+		}
+
+		for _, s := range d.Slaves {
+			if s == name {
+				return d.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+// linkWarnings returns warnings about interface options which aren't supported by the backend.
+func linkWarnings(i *interfaceConfig, options ...string) []string {
+	supported := make(map[string]bool, len(options))
+	for _, o := range options {
+		supported[o] = true
+	}
+
+	var warnings []string
+
+	add := func(option string, set bool) {
+		if set && !supported[option] {
+			warnings = append(warnings, fmt.Sprintf("%s: %s is not exported", i.Name, option))
+		}
+	}
+
+	add("mtu", i.MTU > 0)
+	add("mac", i.MAC != "")
+	add("txqlen", i.TxQLen > 0)
+	add("alias", i.Alias != nil && *i.Alias != "")
+	add("up", i.Up != nil && !*i.Up)
+	add("promisc", i.Promisc != nil && *i.Promisc)
+
+	return warnings
+}
+
+// merge adds interfaces and devices from the imported settings.
+func (ps *PluginSettings) merge(imported *PluginSettings) {
+	for _, d := range imported.Devices {
+		if !ps.deviceExist(d.Name) {
+			ps.setDevice(d)
+		}
+	}
+
+	for _, i := range imported.Interfaces {
+		cfg := ps.interfaceByName(i.Name)
+
+		for _, a := range i.IpAddrs {
+			_, ipNet, err := net.ParseCIDR(a)
+			if err != nil {
+				continue
+			}
+
+			ip := net.ParseIP(strings.Split(a, "/")[0])
+
+			if !ps.addrExist(i.Name, net.IPNet{IP: ip, Mask: ipNet.Mask}) {
+				cfg.IpAddrs = append(cfg.IpAddrs, a)
+			}
+		}
+
+		cfg.DHCP = cfg.DHCP || i.DHCP
+
+		if i.MTU > 0 {
+			cfg.MTU = i.MTU
+		}
+
+		if i.MAC != "" {
+			cfg.MAC = i.MAC
+		}
+	}
+}
+
+// clone returns a deep copy of the settings.
+func (ps *PluginSettings) clone() (*PluginSettings, error) {
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PluginSettings{}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// applyImported creates missing devices and applies the imported interfaces config
+// with the merged settings, addresses which already exist are skipped.
+// Changes are reverted if the import fails, DHCP clients are started only after success.
+func (p *Plugin) applyImported(imported *PluginSettings, merged *PluginSettings) (err error) {
+	var rollback []func()
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for i := len(rollback) - 1; i >= 0; i-- {
+			rollback[i]()
+		}
+	}()
+
+	devices := append([]*deviceConfig{}, imported.Devices...)
+
+	// devices without parent are created first, they can be parents of the others
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].Parent == "" && devices[j].Parent != ""
+	})
+
+	for _, d := range devices {
+		if _, err := netlink.LinkByName(d.Name); err == nil {
+			continue
+		}
+
+		err = createDevice(d)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create device [%s]", d.Name)
+		}
+
+		name := d.Name
+		rollback = append(rollback, func() { _ = deleteLinkByName(name) })
+	}
+
+	var dhcpLinks []netlink.Link
+
+	for _, i := range imported.Interfaces {
+		link, err := netlink.LinkByName(i.Name)
+		if err != nil {
+			return err
+		}
+
+		index, previous := link.Attrs().Index, linkConfig(link.Attrs())
+
+		err = applyLinkConfig(link, merged.interfaceConfig(i.Name))
+		if err != nil {
+			return err
+		}
+
+		rollback = append(rollback, func() { _ = restoreLinkConfig(index, previous) })
+
+		for _, a := range i.IpAddrs {
+			addr, err := netlink.ParseAddr(a)
+			if err != nil {
+				return err
+			}
+
+			err = netlink.AddrAdd(link, addr)
+			if err != nil {
+				if errors.Is(err, syscall.EEXIST) {
+					continue
+				}
+
+				return err
+			}
+
+			rollback = append(rollback, func() { _ = netlink.AddrDel(link, addr) })
+		}
+
+		if i.DHCP {
+			dhcpLinks = append(dhcpLinks, link)
+		}
+	}
+
+	for _, link := range dhcpLinks {
+		p.dhcp.runDHCPClient(link)
+	}
+
+	return nil
+}
+
+func renderDiff(c *fileChange) *Table {
+	table := NewTable("", "")
+
+	for _, l := range DiffLines(c.oldData, c.newData) {
+		var op Element = NewLabel("")
+
+		switch l.Stream {
+		case "+":
+			op = NewBadge("+").SetStyle(StyleSuccess)
+		case "-":
+			op = NewBadge("-").SetStyle(StyleDanger)
+		}
+
+		table.AddLine(op, NewLabel("%s", l.Text).SetMonospace(true))
+	}
+
+	return table
+}
+
+func (p *Plugin) renderConfigFiles(args []string) Page {
+	name := p.settings.Backend
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	page := NewPage("Config files")
+
+	backend, err := configBackendByName(name)
+
+	backends := NewLine()
+
+	for _, b := range configBackends {
+		btn := NewButton(b.title(), "select-backend", b.name())
+
+		if backend == nil || b.name() != backend.name() {
+			btn.SetStyle(StyleSecondary)
+		}
+
+		backends.Add(btn)
+	}
+
+	page.AddElements(
+		NewText("Managed addresses, DHCP flags, link options and devices can be written to the config files of the network manager, so the host is configured at boot even without qubert."),
+		backends,
+	)
+
+	// nothing is written for an unknown backend until another one is chosen
+	if err != nil {
+		page.AddElements(NewLabel("%s", err.Error()))
+
+		return page
+	}
+
+	changes, warnings, err := configChanges(backend, &p.settings)
+	if err != nil {
+		page.AddElements(NewLabel(err.Error()))
+
+		return page
+	}
+
+	page.AddElements(
+		NewLine(
+			NewButton("Write files", "write-config-files", backend.name(), "confirm"),
+			NewButton("Import", "import-config-files", backend.name(), "confirm").SetStyle(StyleSecondary),
+		),
+	)
+
+	if len(warnings) > 0 {
+		list := NewElementsList()
+
+		for _, w := range warnings {
+			list.AddElements(NewLine(NewBadge("warning").SetStyle(StyleWarning), NewLabel(w)))
+		}
+
+		page.AddElements(NewHeader("Warnings"), list)
+	}
+
+	for _, c := range changes {
+		status, style := c.status()
+
+		page.AddElements(
+			NewCardWithTitle(c.path, renderDiff(c)).
+				SetHeaderIcon("file-earmark-text").
+				SetAdditionalHeader(NewBadge(status).SetStyle(style)),
+		)
+	}
+
+	return page
+}
+
+func renderImportPreview(imported *PluginSettings) *ElementsList {
+	list := NewElementsList()
+
+	for _, d := range imported.Devices {
+		list.AddElements(NewLine(NewBadge(d.Type).SetStyle(StylePrimary), NewLabel(d.Name)))
+	}
+
+	for _, i := range imported.Interfaces {
+		line := NewLine(NewLabel(i.Name).SetStrong(true))
+
+		if i.DHCP {
+			line.Add(NewBadge("dhcp").SetStyle(StylePrimary))
+		}
+
+		for _, a := range i.IpAddrs {
+			line.Add(NewLabel(a))
+		}
+
+		if i.MTU > 0 {
+			line.Add(NewLabel("mtu %d", i.MTU))
+		}
+
+		if i.MAC != "" {
+			line.Add(NewLabel(i.MAC).SetMonospace(true))
+		}
+
+		list.AddElements(line)
+	}
+
+	return list
+}
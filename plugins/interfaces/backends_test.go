@@ -0,0 +1,140 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// commonSettings contains interfaces and devices supported by all the backends.
+func commonSettings() *PluginSettings {
+	return &PluginSettings{
+		Devices: []*deviceConfig{
+			{Name: "eth0.10", Type: devTypeVlan, Parent: "eth0", VlanID: 10},
+			{Name: "br0", Type: devTypeBridge},
+			{Name: "bond0", Type: devTypeBond, Mode: "active-backup", Miimon: 100, Slaves: []string{"eth2", "eth3"}},
+		},
+		Interfaces: []*interfaceConfig{
+			{Name: "eth0", IpAddrs: []string{"192.168.1.10/24", "192.168.1.11/24"}, MTU: 9000, MAC: "02:00:00:00:00:01"},
+			{Name: "eth1", DHCP: true},
+			{Name: "eth0.10", IpAddrs: []string{"10.0.10.1/24"}},
+			{Name: "br0", IpAddrs: []string{"10.0.20.1/24"}},
+			{Name: "bond0", DHCP: true},
+		},
+	}
+}
+
+// normalizeSettings sorts the settings and replaces empty slices by nil, so the settings
+// can be compared regardless of the order of the files.
+func normalizeSettings(ps *PluginSettings) string {
+	sort.Slice(ps.Devices, func(i, j int) bool { return ps.Devices[i].Name < ps.Devices[j].Name })
+	sort.Slice(ps.Interfaces, func(i, j int) bool { return ps.Interfaces[i].Name < ps.Interfaces[j].Name })
+
+	for _, d := range ps.Devices {
+		if len(d.Slaves) == 0 {
+			d.Slaves = nil
+		}
+	}
+
+	for _, i := range ps.Interfaces {
+		if len(i.IpAddrs) == 0 {
+			i.IpAddrs = nil
+		}
+	}
+
+	data, _ := json.MarshalIndent(ps, "", "  ")
+
+	return string(data)
+}
+
+func testRoundTrip(t *testing.T, b configBackend, ps *PluginSettings) {
+	// the files depend on the order of the devices, so the settings are sorted like the loaded ones
+	expected := normalizeSettings(ps)
+
+	changes, warnings, err := configChanges(b, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	err = writeChanges(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := b.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := normalizeSettings(loaded); got != expected {
+		t.Errorf("loaded settings differ from the rendered ones\ngot:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	// the second render of the loaded settings doesn't change the files
+	changes, _, err = configChanges(b, loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range changes {
+		if status, _ := c.status(); status != "unchanged" {
+			t.Errorf("%s is %s by the second render", c.path, status)
+		}
+	}
+}
+
+func TestNetworkdRoundTrip(t *testing.T) {
+	networkdDir = t.TempDir()
+
+	ps := commonSettings()
+	ps.Devices = append(ps.Devices,
+		&deviceConfig{Name: "tap0", Type: devTypeTuntap, Mode: "tap", Owner: "nobody", Group: "nogroup", MultiQueue: true},
+		&deviceConfig{Name: "mv0", Type: devTypeMacvlan, Parent: "eth1", Mode: "bridge"},
+		&deviceConfig{Name: "ipv0", Type: devTypeIPVlan, Parent: "eth1", Mode: "l2"},
+		&deviceConfig{Name: "vx0", Type: devTypeVxlan, Parent: "eth1", VNI: 42, Remote: "10.0.0.2", Port: 8472},
+		&deviceConfig{Name: "ve0", Type: devTypeVeth, Peer: "ve1"},
+	)
+
+	testRoundTrip(t, &networkdBackend{}, ps)
+}
+
+func TestNetplanRoundTrip(t *testing.T) {
+	netplanDir = t.TempDir()
+
+	ps := commonSettings()
+	ps.Devices = append(ps.Devices,
+		&deviceConfig{Name: "vx0", Type: devTypeVxlan, Parent: "eth1", VNI: 42, Remote: "10.0.0.2", Port: defaultVxlanPort},
+	)
+
+	testRoundTrip(t, &netplanBackend{}, ps)
+}
+
+func TestIfupdownRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	ifupdownInterfaces = filepath.Join(dir, "interfaces")
+	ifupdownDir = filepath.Join(dir, "interfaces.d")
+
+	testRoundTrip(t, &ifupdownBackend{}, commonSettings())
+}
+
+func TestConfigBackendByName(t *testing.T) {
+	if b, err := configBackendByName(""); err != nil || b != configBackends[0] {
+		t.Errorf("default backend: %v, %v", b, err)
+	}
+
+	for _, backend := range configBackends {
+		if b, err := configBackendByName(backend.name()); err != nil || b != backend {
+			t.Errorf("%s: %v, %v", backend.name(), b, err)
+		}
+	}
+
+	if _, err := configBackendByName("netpaln"); err == nil {
+		t.Error("unknown backend is found")
+	}
+}
@@ -0,0 +1,756 @@
+package interfaces
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	devTypeVlan    = "vlan"
+	devTypeBridge  = "bridge"
+	devTypeBond    = "bond"
+	devTypeTuntap  = "tuntap"
+	devTypeMacvlan = "macvlan"
+	devTypeIPVlan  = "ipvlan"
+	devTypeVxlan   = "vxlan"
+	devTypeVeth    = "veth"
+
+	defaultVxlanPort = 4789
+)
+
+var (
+	macvlanModes = map[string]netlink.MacvlanMode{
+		"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+		"vepa":     netlink.MACVLAN_MODE_VEPA,
+		"private":  netlink.MACVLAN_MODE_PRIVATE,
+		"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	}
+
+	ipvlanModes = map[string]netlink.IPVlanMode{
+		"l2":  netlink.IPVLAN_MODE_L2,
+		"l3":  netlink.IPVLAN_MODE_L3,
+		"l3s": netlink.IPVLAN_MODE_L3S,
+	}
+
+	tuntapModes = map[string]netlink.TuntapMode{
+		"tun": netlink.TUNTAP_MODE_TUN,
+		"tap": netlink.TUNTAP_MODE_TAP,
+	}
+)
+
+// deviceConfig describes a virtual device which is created by the plugin and recreated on start.
+type deviceConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	Parent     string   `json:"parent,omitempty"`
+	Mode       string   `json:"mode,omitempty"`
+	VlanID     int      `json:"vlan-id,omitempty"`
+	Slaves     []string `json:"slaves,omitempty"`
+	Miimon     int      `json:"miimon,omitempty"`
+	Owner      string   `json:"owner,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	MultiQueue bool     `json:"multi-queue,omitempty"`
+	VNI        int      `json:"vni,omitempty"`
+	Remote     string   `json:"remote,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	Peer       string   `json:"peer,omitempty"`
+}
+
+func (ps *PluginSettings) setDevice(dev *deviceConfig) {
+	for i, d := range ps.Devices {
+		if d.Name == dev.Name {
+			ps.Devices[i] = dev
+			return
+		}
+	}
+
+	ps.Devices = append(ps.Devices, dev)
+}
+
+func (ps *PluginSettings) delDevice(name string) bool {
+	for i, d := range ps.Devices {
+		if d.Name == name || (d.Type == devTypeVeth && d.Peer == name) {
+			ps.Devices = append(ps.Devices[:i], ps.Devices[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ps *PluginSettings) deviceExist(name string) bool {
+	for _, d := range ps.Devices {
+		if d.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parentIndex(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get parent device [%s]", name)
+	}
+
+	return link.Attrs().Index, nil
+}
+
+func createDevice(dev *deviceConfig) error {
+	la := netlink.NewLinkAttrs()
+	la.Name = dev.Name
+
+	parent, err := parentIndex(dev.Parent)
+	if err != nil {
+		return err
+	}
+
+	switch dev.Type {
+	case devTypeVlan, devTypeMacvlan, devTypeIPVlan:
+		la.ParentIndex = parent
+	}
+
+	var link netlink.Link
+
+	switch dev.Type {
+	case devTypeVlan:
+		link = &netlink.Vlan{
+			LinkAttrs:    la,
+			VlanId:       dev.VlanID,
+			VlanProtocol: netlink.VLAN_PROTOCOL_8021Q,
+		}
+	case devTypeBridge:
+		link = &netlink.Bridge{LinkAttrs: la}
+	case devTypeBond:
+		bond := netlink.NewLinkBond(la)
+		bond.Mode = netlink.StringToBondMode(dev.Mode)
+		bond.Miimon = dev.Miimon
+
+		link = bond
+	case devTypeTuntap:
+		tuntap := &netlink.Tuntap{
+			LinkAttrs: la,
+			Mode:      tuntapModes[dev.Mode],
+			Flags:     netlink.TUNTAP_DEFAULTS,
+			Queues:    1,
+		}
+
+		if dev.MultiQueue {
+			tuntap.Flags = netlink.TUNTAP_MULTI_QUEUE_DEFAULTS
+		}
+
+		link = tuntap
+	case devTypeMacvlan:
+		link = &netlink.Macvlan{
+			LinkAttrs: la,
+			Mode:      macvlanModes[dev.Mode],
+		}
+	case devTypeIPVlan:
+		link = &netlink.IPVlan{
+			LinkAttrs: la,
+			Mode:      ipvlanModes[dev.Mode],
+		}
+	case devTypeVxlan:
+		vxlan := &netlink.Vxlan{
+			LinkAttrs:    la,
+			VxlanId:      dev.VNI,
+			VtepDevIndex: parent,
+			Group:        net.ParseIP(dev.Remote),
+			Port:         dev.Port,
+			Learning:     true,
+		}
+
+		link = vxlan
+	case devTypeVeth:
+		link = &netlink.Veth{
+			LinkAttrs: la,
+			PeerName:  dev.Peer,
+		}
+	default:
+		return fmt.Errorf("unsupported device type [%s]", dev.Type)
+	}
+
+	err = netlink.LinkAdd(link)
+	if err != nil {
+		return err
+	}
+
+	// the half configured device isn't left, so it is created again on the next try
+	err = setupDevice(link, dev)
+	if err != nil {
+		_ = netlink.LinkDel(link)
+		return err
+	}
+
+	return nil
+}
+
+func setupDevice(link netlink.Link, dev *deviceConfig) error {
+	switch l := link.(type) {
+	case *netlink.Tuntap:
+		err := setTuntapOwner(l, dev.Owner, dev.Group)
+		if err != nil {
+			return err
+		}
+	case *netlink.Bond:
+		for _, s := range dev.Slaves {
+			slave, err := netlink.LinkByName(s)
+			if err != nil {
+				return err
+			}
+
+			err = netlink.LinkSetDown(slave)
+			if err != nil {
+				return err
+			}
+
+			err = netlink.LinkSetMaster(slave, l)
+			if err != nil {
+				return err
+			}
+
+			err = netlink.LinkSetUp(slave)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return netlink.LinkSetUp(link)
+}
+
+// setTuntapOwner applies owner and group to the created device via
+// the descriptors which were opened by netlink and closes them after.
+func setTuntapOwner(tuntap *netlink.Tuntap, owner string, group string) error {
+	defer func() {
+		for _, f := range tuntap.Fds {
+			_ = f.Close()
+		}
+
+		tuntap.Fds = nil
+	}()
+
+	if len(tuntap.Fds) == 0 {
+		return nil
+	}
+
+	fd := tuntap.Fds[0].Fd()
+
+	if owner != "" {
+		uid, err := lookupOwner(owner)
+		if err != nil {
+			return err
+		}
+
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TUNSETOWNER, uintptr(uid)); errno != 0 {
+			return errors.Wrap(errno, "failed to set tuntap owner")
+		}
+	}
+
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TUNSETGROUP, uintptr(gid)); errno != 0 {
+			return errors.Wrap(errno, "failed to set tuntap group")
+		}
+	}
+
+	return nil
+}
+
+// lookupOwner returns the uid of the user given by the name or the id.
+func lookupOwner(owner string) (int, error) {
+	u, err := user.Lookup(owner)
+	if err != nil {
+		u, err = user.LookupId(owner)
+		if err != nil {
+			return 0, fmt.Errorf("user [%s] not found", owner)
+		}
+	}
+
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroup returns the gid of the group given by the name or the id.
+func lookupGroup(group string) (int, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		g, err = user.LookupGroupId(group)
+		if err != nil {
+			return 0, fmt.Errorf("group [%s] not found", group)
+		}
+	}
+
+	return strconv.Atoi(g.Gid)
+}
+
+// splitOwner moves the group of the owner written as "user:group" by older versions to the group field.
+func (ps *PluginSettings) splitOwner() bool {
+	changed := false
+
+	for _, d := range ps.Devices {
+		if parts := strings.SplitN(d.Owner, ":", 2); len(parts) == 2 {
+			d.Owner, d.Group = parts[0], parts[1]
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func deleteLinkByName(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	return netlink.LinkDel(link)
+}
+
+// loadDevices creates missing devices, a device which can't be created doesn't stop the others.
+func loadDevices(devices []*deviceConfig) {
+	for _, d := range devices {
+		if _, err := netlink.LinkByName(d.Name); err == nil {
+			continue
+		}
+
+		err := createDevice(d)
+		if err != nil {
+			fmt.Println(errors.Wrapf(err, "failed to create device [%s]", d.Name))
+		}
+	}
+}
+
+func isVirtualDevice(link netlink.Link) bool {
+	switch link.Type() {
+	case devTypeVlan, devTypeBridge, devTypeBond, devTypeTuntap, devTypeMacvlan, devTypeIPVlan, devTypeVxlan, devTypeVeth:
+		return true
+	}
+
+	return false
+}
+
+type deviceReqData struct {
+	Name    string `json:"name"`
+	DevType string `json:"dev-type"`
+	Manage  bool   `json:"manage"`
+
+	Parent     string `json:"parent"`
+	Mode       string `json:"mode"`
+	VlanID     int    `json:"vlan-id"`
+	Slaves     string `json:"slaves"`
+	Miimon     int    `json:"miimon"`
+	Owner      string `json:"owner"`
+	Group      string `json:"group"`
+	MultiQueue bool   `json:"multi-queue"`
+	VNI        int    `json:"vni"`
+	Remote     string `json:"remote"`
+	Port       int    `json:"port"`
+	Peer       string `json:"peer"`
+}
+
+type deviceForm struct {
+	nameInput *Input
+
+	parentSelect *Select
+	modeSelect   *Select
+	vlanIDInput  *NumberInput
+	slavesInput  *Input
+	miimonInput  *NumberInput
+	ownerInput   *Input
+	groupInput   *Input
+	vniInput     *NumberInput
+	remoteInput  *Input
+	portInput    *NumberInput
+	peerInput    *Input
+}
+
+func newParentSelect(value string, allowEmpty bool) (*Select, error) {
+	parentSelect := NewSelect("parent").SetValue(value)
+
+	if allowEmpty {
+		parentSelect.AddNamedOption("None", "")
+	} else {
+		parentSelect.AddOption("")
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range links {
+		if l.Attrs().Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		parentSelect.AddOption(l.Attrs().Name)
+	}
+
+	return parentSelect, nil
+}
+
+func newModeSelect(value string, modes ...string) *Select {
+	modeSelect := NewSelect("mode").SetValue(value)
+
+	for _, m := range modes {
+		modeSelect.AddOption(m)
+	}
+
+	if value == "" && len(modes) > 0 {
+		modeSelect.SetValue(modes[0])
+	}
+
+	return modeSelect
+}
+
+func newDeviceForm(form *Form, nameInput *Input, reqData *deviceReqData) (*deviceForm, error) {
+	f := &deviceForm{
+		nameInput: nameInput,
+	}
+
+	var err error
+
+	switch reqData.DevType {
+	case devTypeVlan:
+		f.parentSelect, err = newParentSelect(reqData.Parent, false)
+		if err != nil {
+			return nil, err
+		}
+
+		f.vlanIDInput = NewNumberInput("vlan-id").SetValue(reqData.VlanID)
+
+		form.AddWithTitle("Parent", f.parentSelect)
+		form.AddWithTitle("vlan ID", f.vlanIDInput)
+	case devTypeBond:
+		f.modeSelect = newModeSelect(reqData.Mode,
+			"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb",
+		)
+		f.miimonInput = NewNumberInput("miimon").SetValue(reqData.Miimon)
+		f.slavesInput = NewInput("slaves").SetValue(reqData.Slaves)
+
+		form.AddWithTitle("Mode", f.modeSelect)
+		form.AddWithTitle("MII monitoring interval (ms)", f.miimonInput)
+		form.AddWithTitle("Slaves (space separated)", f.slavesInput)
+	case devTypeTuntap:
+		f.modeSelect = newModeSelect(reqData.Mode, "tun", "tap")
+		f.ownerInput = NewInput("owner").SetValue(reqData.Owner)
+		f.groupInput = NewInput("group").SetValue(reqData.Group)
+
+		form.AddWithTitle("Mode", f.modeSelect)
+		form.AddWithTitle("Owner", f.ownerInput)
+		form.AddWithTitle("Group", f.groupInput)
+		form.AddWithTitle("Multi queue", NewSwitch("multi-queue").SetValue(reqData.MultiQueue))
+	case devTypeMacvlan, devTypeIPVlan:
+		f.parentSelect, err = newParentSelect(reqData.Parent, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if reqData.DevType == devTypeMacvlan {
+			f.modeSelect = newModeSelect(reqData.Mode, "bridge", "vepa", "private", "passthru")
+		} else {
+			f.modeSelect = newModeSelect(reqData.Mode, "l2", "l3", "l3s")
+		}
+
+		form.AddWithTitle("Parent", f.parentSelect)
+		form.AddWithTitle("Mode", f.modeSelect)
+	case devTypeVxlan:
+		f.parentSelect, err = newParentSelect(reqData.Parent, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if reqData.Port == 0 {
+			reqData.Port = defaultVxlanPort
+		}
+
+		f.vniInput = NewNumberInput("vni").SetValue(reqData.VNI)
+		f.remoteInput = NewInput("remote").SetValue(reqData.Remote)
+		f.portInput = NewNumberInput("port").SetValue(reqData.Port)
+
+		form.AddWithTitle("VNI", f.vniInput)
+		form.AddWithTitle("Remote or multicast group", f.remoteInput)
+		form.AddWithTitle("Port", f.portInput)
+		form.AddWithTitle("Device", f.parentSelect)
+	case devTypeVeth:
+		f.peerInput = NewInput("peer").SetValue(reqData.Peer)
+
+		form.AddWithTitle("Peer name", f.peerInput)
+	}
+
+	return f, nil
+}
+
+func validDeviceName(name string) error {
+	if name == "" {
+		return errors.New("name can't be empty")
+	}
+
+	if len(name) > 15 {
+		return errors.New("name is too long")
+	}
+
+	if strings.ContainsAny(name, " /:") {
+		return errors.New("name contains incorrect symbols")
+	}
+
+	if _, err := netlink.LinkByName(name); err == nil {
+		return errors.New("device already exist")
+	}
+
+	return nil
+}
+
+// validate checks request data and returns the device config, or nil if the form contains errors.
+func (f *deviceForm) validate(reqData *deviceReqData) *deviceConfig {
+	if err := validDeviceName(reqData.Name); err != nil {
+		f.nameInput.SetErrorText(err.Error())
+		return nil
+	}
+
+	dev := &deviceConfig{
+		Name:       reqData.Name,
+		Type:       reqData.DevType,
+		Parent:     reqData.Parent,
+		Mode:       reqData.Mode,
+		MultiQueue: reqData.MultiQueue,
+	}
+
+	switch reqData.DevType {
+	case devTypeVlan, devTypeMacvlan, devTypeIPVlan:
+		if reqData.Parent == "" {
+			f.parentSelect.SetErrorText("Parent device not set")
+			return nil
+		}
+	}
+
+	switch reqData.DevType {
+	case devTypeVlan:
+		if reqData.VlanID < 0 || reqData.VlanID > 4095 {
+			f.vlanIDInput.SetErrorText("Incorrect VlanID")
+			return nil
+		}
+
+		dev.VlanID = reqData.VlanID
+	case devTypeBond:
+		if reqData.Miimon < 0 {
+			f.miimonInput.SetErrorText("Incorrect interval")
+			return nil
+		}
+
+		for _, s := range strings.Fields(reqData.Slaves) {
+			if _, err := netlink.LinkByName(s); err != nil {
+				f.slavesInput.SetErrorText(fmt.Sprintf("Device %s not found", s))
+				return nil
+			}
+
+			dev.Slaves = append(dev.Slaves, s)
+		}
+
+		dev.Miimon = reqData.Miimon
+	case devTypeTuntap:
+		if reqData.Owner != "" {
+			if _, err := lookupOwner(reqData.Owner); err != nil {
+				f.ownerInput.SetErrorText(err.Error())
+				return nil
+			}
+
+			dev.Owner = reqData.Owner
+		}
+
+		if reqData.Group != "" {
+			if _, err := lookupGroup(reqData.Group); err != nil {
+				f.groupInput.SetErrorText(err.Error())
+				return nil
+			}
+
+			dev.Group = reqData.Group
+		}
+	case devTypeVxlan:
+		if reqData.VNI <= 0 || reqData.VNI > 1<<24-1 {
+			f.vniInput.SetErrorText("Incorrect VNI")
+			return nil
+		}
+
+		if reqData.Remote != "" && net.ParseIP(reqData.Remote) == nil {
+			f.remoteInput.SetErrorText("Incorrect IP address")
+			return nil
+		}
+
+		if reqData.Port <= 0 || reqData.Port > 65535 {
+			f.portInput.SetErrorText("Incorrect port")
+			return nil
+		}
+
+		dev.VNI = reqData.VNI
+		dev.Remote = reqData.Remote
+		dev.Port = reqData.Port
+	case devTypeVeth:
+		if err := validDeviceName(reqData.Peer); err != nil || reqData.Peer == reqData.Name {
+			if err == nil {
+				err = errors.New("peer name must differ from device name")
+			}
+
+			f.peerInput.SetErrorText(err.Error())
+			return nil
+		}
+
+		dev.Peer = reqData.Peer
+	case devTypeBridge:
+	default:
+		return nil
+	}
+
+	return dev
+}
+
+func linkNameByIndex(index int) string {
+	if index <= 0 {
+		return ""
+	}
+
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return ""
+	}
+
+	return link.Attrs().Name
+}
+
+func tuntapFlags(name string) uint64 {
+	data, err := ioutil.ReadFile(path.Join("/sys/class/net", name, "tun_flags"))
+	if err != nil {
+		return 0
+	}
+
+	flags, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 64)
+	if err != nil {
+		return 0
+	}
+
+	return flags
+}
+
+func modeName[T comparable](modes map[string]T, mode T) string {
+	for name, m := range modes {
+		if m == mode {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("%v", mode)
+}
+
+// renderDeviceDetails returns type specific information about the device or nil if there is nothing to show.
+func renderDeviceDetails(link netlink.Link) *ElementsList {
+	details := NewElementsList().SetModeLine()
+
+	title := func(text string) *Label {
+		return NewLabel(text).SetStrong(true)
+	}
+
+	switch l := link.(type) {
+	case *netlink.Vlan:
+		details.
+			AddElementWithTitle(title("VLAN ID"), NewLabel("%d", l.VlanId)).
+			AddElementWithTitle(title("Parent"), NewLabel(linkNameByIndex(l.ParentIndex)))
+	case *netlink.Bond:
+		slaves := NewLine()
+
+		links, err := netlink.LinkList()
+		if err == nil {
+			for _, s := range links {
+				if s.Attrs().MasterIndex == l.Index {
+					slaves.Add(NewButton(s.Attrs().Name, "select-dev", s.Attrs().Name).SetLinkStyle())
+				}
+			}
+		}
+
+		details.
+			AddElementWithTitle(title("Mode"), NewBadge(l.Mode.String())).
+			AddElementWithTitle(title("MII monitoring"), NewLabel("%d ms", l.Miimon)).
+			AddElementWithTitle(title("Slaves"), slaves)
+	case *netlink.Tuntap:
+		owner := "root"
+		if u, err := user.LookupId(strconv.Itoa(int(l.Owner))); err == nil {
+			owner = u.Username
+		}
+
+		// the kernel reports -1 if the group isn't set
+		group := "none"
+		if g, err := user.LookupGroupId(strconv.Itoa(int(int32(l.Group)))); err == nil {
+			group = g.Name
+		}
+
+		multiQueue := "no"
+		if tuntapFlags(l.Name)&uint64(netlink.TUNTAP_MULTI_QUEUE) != 0 {
+			multiQueue = "yes"
+		}
+
+		details.
+			AddElementWithTitle(title("Mode"), NewBadge(modeName(tuntapModes, l.Mode))).
+			AddElementWithTitle(title("Owner"), NewLabel(owner)).
+			AddElementWithTitle(title("Group"), NewLabel(group)).
+			AddElementWithTitle(title("Multi queue"), NewLabel(multiQueue))
+	case *netlink.Macvlan:
+		details.
+			AddElementWithTitle(title("Mode"), NewBadge(modeName(macvlanModes, l.Mode))).
+			AddElementWithTitle(title("Parent"), NewLabel(linkNameByIndex(l.ParentIndex)))
+	case *netlink.IPVlan:
+		details.
+			AddElementWithTitle(title("Mode"), NewBadge(modeName(ipvlanModes, l.Mode))).
+			AddElementWithTitle(title("Parent"), NewLabel(linkNameByIndex(l.ParentIndex)))
+	case *netlink.Vxlan:
+		remote := ""
+		if l.Group != nil {
+			remote = l.Group.String()
+		}
+
+		details.
+			AddElementWithTitle(title("VNI"), NewLabel("%d", l.VxlanId)).
+			AddElementWithTitle(title("Remote"), NewLabel(remote)).
+			AddElementWithTitle(title("Port"), NewLabel("%d", l.Port)).
+			AddElementWithTitle(title("Device"), NewLabel(linkNameByIndex(l.VtepDevIndex)))
+	case *netlink.Veth:
+		peer := ""
+		if index, err := vethPeerIndex(l.Name); err == nil {
+			peer = linkNameByIndex(index)
+		}
+
+		details.AddElementWithTitle(title("Peer"), NewButton(peer, "select-dev", peer).SetLinkStyle())
+	default:
+		return nil
+	}
+
+	return details
+}
+
+func vethPeerIndex(name string) (int, error) {
+	data, err := ioutil.ReadFile(path.Join("/sys/class/net", name, "iflink"))
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,305 @@
+package interfaces
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	. "qubert/pluginTools"
+)
+
+const reloadDelay = 300 * time.Millisecond
+
+var neighStates = map[int]string{
+	netlink.NUD_INCOMPLETE: "incomplete",
+	netlink.NUD_REACHABLE:  "reachable",
+	netlink.NUD_STALE:      "stale",
+	netlink.NUD_DELAY:      "delay",
+	netlink.NUD_PROBE:      "probe",
+	netlink.NUD_FAILED:     "failed",
+	netlink.NUD_NOARP:      "noarp",
+	netlink.NUD_PERMANENT:  "permanent",
+}
+
+// reloader collects reload requests and sends them in one batch, because
+// netlink can produce a lot of events for one change.
+type reloader struct {
+	mx      sync.Mutex
+	api     PluginAPI
+	pending map[string]bool
+	timer   *time.Timer
+}
+
+func newReloader(api PluginAPI) *reloader {
+	return &reloader{
+		api:     api,
+		pending: make(map[string]bool),
+	}
+}
+
+// reload schedules page reload for clients viewing the device, or for all clients if the device is empty.
+func (r *reloader) reload(devName string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.pending[devName] = true
+
+	if r.timer == nil {
+		r.timer = time.AfterFunc(reloadDelay, r.flush)
+	}
+}
+
+func (r *reloader) flush() {
+	r.mx.Lock()
+	pending := r.pending
+	r.pending = make(map[string]bool)
+	r.timer = nil
+	r.mx.Unlock()
+
+	if pending[""] {
+		r.api.Reload()
+		return
+	}
+
+	for devName := range pending {
+		r.api.Reload(devName)
+	}
+}
+
+func macLabelID(name string) string   { return "mac-" + name }
+func addrsLabelID(name string) string { return "addrs-" + name }
+
+// formatAddrs returns IPv4 addresses of the link as they are shown in the device list.
+func formatAddrs(link netlink.Link) string {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return ""
+	}
+
+	result := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		result = append(result, a.IPNet.String())
+	}
+
+	return strings.Join(result, " ")
+}
+
+// onLinkUpdate reloads the device page and updates the device list row,
+// the whole list is reloaded only if a link is added, removed or renamed.
+func (p *Plugin) onLinkUpdate(u netlink.LinkUpdate, names map[int]string) {
+	attrs := u.Attrs()
+
+	if u.Header.Type == syscall.RTM_DELLINK {
+		delete(names, attrs.Index)
+		p.reloader.reload("")
+
+		return
+	}
+
+	name, known := names[attrs.Index]
+	names[attrs.Index] = attrs.Name
+
+	if !known || name != attrs.Name {
+		p.reloader.reload("")
+		return
+	}
+
+	p.api.SendUpdate(NewUpdateLabel(macLabelID(attrs.Name), attrs.HardwareAddr.String()))
+	p.reloader.reload(attrs.Name)
+}
+
+func (p *Plugin) onAddrUpdate(u netlink.AddrUpdate) {
+	link, err := netlink.LinkByIndex(u.LinkIndex)
+	if err != nil {
+		// addresses of the removed link are shown by the link event
+		return
+	}
+
+	p.api.SendUpdate(NewUpdateLabel(addrsLabelID(link.Attrs().Name), formatAddrs(link)))
+	p.reloader.reload(link.Attrs().Name)
+}
+
+// isShownRoute returns true for the routes which are shown on the routing page.
+func isShownRoute(r *netlink.Route) bool {
+	if r.Table != syscall.RT_TABLE_MAIN {
+		return false
+	}
+
+	if r.Dst != nil {
+		return r.Dst.IP.To4() != nil
+	}
+
+	return r.Gw != nil && r.Gw.To4() != nil
+}
+
+type neighKey struct {
+	index int
+	ip    string
+}
+
+// onNeighUpdate reloads the device page only if a neighbor is added, removed or changes the MAC,
+// the state of neighbors changes all the time and isn't followed.
+func (p *Plugin) onNeighUpdate(u netlink.NeighUpdate, neighbors map[neighKey]string) {
+	if u.IP == nil {
+		return
+	}
+
+	key := neighKey{index: u.LinkIndex, ip: u.IP.String()}
+	mac, known := neighbors[key]
+
+	if u.Type == syscall.RTM_DELNEIGH {
+		if !known {
+			return
+		}
+
+		delete(neighbors, key)
+	} else {
+		if known && mac == u.HardwareAddr.String() {
+			return
+		}
+
+		neighbors[key] = u.HardwareAddr.String()
+	}
+
+	if devName := linkNameByIndex(u.LinkIndex); devName != "" {
+		p.reloader.reload(devName)
+	}
+}
+
+func (p *Plugin) runEventsMonitor() error {
+	done := make(chan struct{})
+
+	linkCh := make(chan netlink.LinkUpdate)
+	addrCh := make(chan netlink.AddrUpdate)
+	routeCh := make(chan netlink.RouteUpdate)
+	neighCh := make(chan netlink.NeighUpdate)
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[int]string, len(links))
+	for _, l := range links {
+		names[l.Attrs().Index] = l.Attrs().Name
+	}
+
+	neighList, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+
+	neighbors := make(map[neighKey]string, len(neighList))
+	for _, n := range neighList {
+		if n.IP != nil {
+			neighbors[neighKey{index: n.LinkIndex, ip: n.IP.String()}] = n.HardwareAddr.String()
+		}
+	}
+
+	subscribes := []func() error{
+		func() error { return netlink.LinkSubscribe(linkCh, done) },
+		func() error { return netlink.AddrSubscribe(addrCh, done) },
+		func() error { return netlink.RouteSubscribe(routeCh, done) },
+		func() error { return netlink.NeighSubscribe(neighCh, done) },
+	}
+
+	for _, s := range subscribes {
+		err := s()
+		if err != nil {
+			close(done)
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-p.ctx.Done():
+				close(done)
+				return
+			case u, ok := <-linkCh:
+				if !ok {
+					linkCh = nil
+					continue
+				}
+
+				p.onLinkUpdate(u, names)
+			case u, ok := <-addrCh:
+				if !ok {
+					addrCh = nil
+					continue
+				}
+
+				p.onAddrUpdate(u)
+			case u, ok := <-routeCh:
+				if !ok {
+					routeCh = nil
+					continue
+				}
+
+				// the routing page has no args, so it is reloaded with the others
+				if isShownRoute(&u.Route) {
+					p.reloader.reload("")
+				}
+			case u, ok := <-neighCh:
+				if !ok {
+					neighCh = nil
+					continue
+				}
+
+				p.onNeighUpdate(u, neighbors)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func operStateBadge(link netlink.Link) *Badge {
+	state := link.Attrs().OperState
+
+	badge := NewBadge(state.String())
+
+	switch state {
+	case netlink.OperUp:
+		badge.SetStyle(StyleSuccess)
+	case netlink.OperDown, netlink.OperLowerLayerDown:
+		badge.SetStyle(StyleDanger)
+	default:
+		badge.SetStyle(StyleSecondary)
+	}
+
+	return badge
+}
+
+func renderNeighbors(link netlink.Link) *Table {
+	table := NewTable("IP address", "MAC", "State")
+
+	neighbors, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_ALL)
+	if err != nil {
+		return table
+	}
+
+	for _, n := range neighbors {
+		if n.IP == nil {
+			continue
+		}
+
+		state, ok := neighStates[n.State]
+		if !ok {
+			state = fmt.Sprintf("%d", n.State)
+		}
+
+		table.AddLine(
+			NewLabel(n.IP.String()),
+			NewLabel(n.HardwareAddr.String()).SetMonospace(true),
+			NewBadge(state).SetStyle(StyleSecondary),
+		)
+	}
+
+	return table
+}
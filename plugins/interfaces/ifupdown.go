@@ -0,0 +1,344 @@
+package interfaces
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const ifupdownFile = "qubert"
+
+// the paths are variables to be replaced by tests
+var (
+	ifupdownInterfaces = "/etc/network/interfaces"
+	ifupdownDir        = "/etc/network/interfaces.d"
+)
+
+type ifupdownBackend struct{}
+
+func (b *ifupdownBackend) name() string  { return "ifupdown" }
+func (b *ifupdownBackend) title() string { return "ifupdown" }
+
+// deviceCommands returns commands which create and remove the device for the devices
+// which aren't supported by ifupdown itself.
+func deviceCommands(d *deviceConfig) (string, string) {
+	var create string
+
+	switch d.Type {
+	case devTypeTuntap:
+		create = fmt.Sprintf("ip tuntap add dev %s mode %s", d.Name, d.Mode)
+
+		if d.Owner != "" {
+			create += " user " + d.Owner
+		}
+
+		if d.Group != "" {
+			create += " group " + d.Group
+		}
+
+		if d.MultiQueue {
+			create += " multi_queue"
+		}
+	case devTypeMacvlan:
+		create = fmt.Sprintf("ip link add link %s name %s type macvlan mode %s", d.Parent, d.Name, d.Mode)
+	case devTypeIPVlan:
+		create = fmt.Sprintf("ip link add link %s name %s type ipvlan mode %s", d.Parent, d.Name, d.Mode)
+	case devTypeVxlan:
+		create = fmt.Sprintf("ip link add %s type vxlan id %d dstport %d", d.Name, d.VNI, d.Port)
+
+		if d.Remote != "" {
+			create += " remote " + d.Remote
+		}
+
+		if d.Parent != "" {
+			create += " dev " + d.Parent
+		}
+	case devTypeVeth:
+		create = fmt.Sprintf("ip link add %s type veth peer name %s", d.Name, d.Peer)
+	default:
+		return "", ""
+	}
+
+	return create, fmt.Sprintf("ip link del %s", d.Name)
+}
+
+func (b *ifupdownBackend) renderInterface(w *strings.Builder, ps *PluginSettings, name string) {
+	i := ps.interfaceConfig(name)
+
+	var options []string
+
+	if d := ps.deviceByName(name); d != nil {
+		switch d.Type {
+		case devTypeVlan:
+			options = append(options, "vlan-raw-device "+d.Parent)
+		case devTypeBridge:
+			options = append(options, "bridge_ports none")
+		case devTypeBond:
+			options = append(options, "bond-slaves "+strings.Join(d.Slaves, " "))
+
+			if d.Mode != "" {
+				options = append(options, "bond-mode "+d.Mode)
+			}
+
+			if d.Miimon > 0 {
+				options = append(options, fmt.Sprintf("bond-miimon %d", d.Miimon))
+			}
+		default:
+			create, remove := deviceCommands(d)
+			options = append(options, "pre-up "+create, "post-down "+remove)
+		}
+	}
+
+	if i.MTU > 0 {
+		options = append(options, fmt.Sprintf("mtu %d", i.MTU))
+	}
+
+	if i.MAC != "" {
+		options = append(options, "hwaddress ether "+i.MAC)
+	}
+
+	if i.TxQLen > 0 {
+		options = append(options, fmt.Sprintf("up ip link set dev %s txqueuelen %d", name, i.TxQLen))
+	}
+
+	if i.Alias != nil && *i.Alias != "" {
+		options = append(options, fmt.Sprintf("up ip link set dev %s alias %s", name, strconv.Quote(*i.Alias)))
+	}
+
+	if i.Promisc != nil && *i.Promisc {
+		options = append(options, fmt.Sprintf("up ip link set dev %s promisc on", name))
+	}
+
+	if i.Up == nil || *i.Up {
+		w.WriteString(fmt.Sprintf("\nauto %s\n", name))
+	} else {
+		w.WriteString("\n")
+	}
+
+	// the first stanza holds the link options, the others only the addresses
+	stanza := func(method string, addr string) {
+		w.WriteString(fmt.Sprintf("iface %s inet %s\n", name, method))
+
+		if addr != "" {
+			w.WriteString(fmt.Sprintf("\taddress %s\n", addr))
+		}
+
+		for _, o := range options {
+			w.WriteString(fmt.Sprintf("\t%s\n", o))
+		}
+
+		options = nil
+	}
+
+	if i.DHCP {
+		stanza("dhcp", "")
+	}
+
+	for _, a := range i.IpAddrs {
+		stanza("static", a)
+	}
+
+	if !i.DHCP && len(i.IpAddrs) == 0 {
+		stanza("manual", "")
+	}
+}
+
+func (b *ifupdownBackend) render(ps *PluginSettings) ([]*configFile, []string) {
+	w := &strings.Builder{}
+
+	w.WriteString(fmt.Sprintf("# %s\n", generatedHeader))
+	w.WriteString(fmt.Sprintf("# %s should contain \"source %s/*\"\n", ifupdownInterfaces, ifupdownDir))
+
+	for _, name := range ps.managedLinkNames() {
+		b.renderInterface(w, ps, name)
+	}
+
+	return []*configFile{
+		{
+			path: filepath.Join(ifupdownDir, ifupdownFile),
+			perm: 0644,
+			data: w.String(),
+		},
+	}, nil
+}
+
+func (b *ifupdownBackend) generatedFiles() ([]string, error) {
+	return generatedFilesByPattern(filepath.Join(ifupdownDir, "*"))
+}
+
+type ifupdownStanza struct {
+	name    string
+	method  string
+	options map[string][]string
+}
+
+func parseIfupdown(data string) []*ifupdownStanza {
+	var (
+		result  []*ifupdownStanza
+		current *ifupdownStanza
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "iface":
+			current = nil
+
+			// only IPv4 stanzas are supported
+			if len(fields) < 4 || fields[2] != "inet" {
+				continue
+			}
+
+			current = &ifupdownStanza{
+				name:    fields[1],
+				method:  fields[3],
+				options: make(map[string][]string),
+			}
+
+			result = append(result, current)
+		case "auto", "source", "source-directory", "mapping", "rename":
+			current = nil
+		default:
+			if strings.HasPrefix(fields[0], "allow-") {
+				current = nil
+				continue
+			}
+
+			if current != nil {
+				key := strings.ReplaceAll(fields[0], "_", "-")
+				current.options[key] = append(current.options[key], strings.Join(fields[1:], " "))
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *ifupdownStanza) first(key string) string {
+	if values := s.options[key]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// address returns the address of the static stanza in CIDR notation.
+func (s *ifupdownStanza) address() string {
+	addr := s.first("address")
+	if addr == "" || strings.Contains(addr, "/") {
+		return addr
+	}
+
+	ip := net.ParseIP(addr)
+	mask := net.ParseIP(s.first("netmask"))
+
+	if ip == nil {
+		return ""
+	}
+
+	if mask == nil || mask.To4() == nil {
+		return addr + "/32"
+	}
+
+	ones, _ := net.IPMask(mask.To4()).Size()
+
+	return fmt.Sprintf("%s/%d", addr, ones)
+}
+
+func (b *ifupdownBackend) load() (*PluginSettings, error) {
+	paths, err := filepath.Glob(filepath.Join(ifupdownDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PluginSettings{}
+
+	for _, path := range append([]string{ifupdownInterfaces}, paths...) {
+		data, _, err := readFileIfExist(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range parseIfupdown(data) {
+			if s.name == "lo" {
+				continue
+			}
+
+			if parent := s.first("vlan-raw-device"); parent != "" {
+				vlanID := s.name[strings.LastIndexAny(s.name, ".n")+1:]
+
+				id, _ := strconv.Atoi(vlanID)
+
+				result.setDevice(&deviceConfig{
+					Name:   s.name,
+					Type:   devTypeVlan,
+					Parent: parent,
+					VlanID: id,
+				})
+			}
+
+			if ports := s.first("bridge-ports"); ports != "" {
+				result.setDevice(&deviceConfig{
+					Name: s.name,
+					Type: devTypeBridge,
+				})
+			}
+
+			if slaves := s.first("bond-slaves"); slaves != "" {
+				d := &deviceConfig{
+					Name: s.name,
+					Type: devTypeBond,
+					Mode: s.first("bond-mode"),
+				}
+
+				if slaves != "none" {
+					d.Slaves = strings.Fields(slaves)
+				}
+
+				d.Miimon, _ = strconv.Atoi(s.first("bond-miimon"))
+
+				result.setDevice(d)
+			}
+
+			i := result.interfaceByName(s.name)
+
+			switch s.method {
+			case "dhcp":
+				i.DHCP = true
+			case "static":
+				if addr := s.address(); addr != "" {
+					i.IpAddrs = append(i.IpAddrs, addr)
+				}
+			}
+
+			if mtu, err := strconv.Atoi(s.first("mtu")); err == nil {
+				i.MTU = mtu
+			}
+
+			if hw := strings.Fields(s.first("hwaddress")); len(hw) > 0 {
+				i.MAC = hw[len(hw)-1]
+			}
+		}
+	}
+
+	interfaces := result.Interfaces[:0]
+
+	for _, i := range result.Interfaces {
+		if i.DHCP || len(i.IpAddrs) > 0 || i.MTU > 0 || i.MAC != "" {
+			interfaces = append(interfaces, i)
+		}
+	}
+
+	result.Interfaces = interfaces
+
+	return result, nil
+}
@@ -16,9 +16,18 @@ type interfaceConfig struct {
 	Name    string   `json:"name"`
 	IpAddrs []string `json:"addrs"`
 	DHCP    bool     `json:"dhcp,omitempty"`
+
+	MTU     int     `json:"mtu,omitempty"`
+	MAC     string  `json:"mac,omitempty"`
+	TxQLen  int     `json:"txqlen,omitempty"`
+	Alias   *string `json:"alias,omitempty"`
+	Up      *bool   `json:"up,omitempty"`
+	Promisc *bool   `json:"promisc,omitempty"`
 }
 
 type PluginSettings struct {
+	Backend    string             `json:"backend,omitempty"`
+	Devices    []*deviceConfig    `json:"devices,omitempty"`
 	Interfaces []*interfaceConfig `json:"interfaces"`
 }
 
@@ -80,7 +89,14 @@ func (ps *PluginSettings) addrExist(name string, ip net.IPNet) bool {
 
 func loadAddresses(Interfaces []*interfaceConfig, dhcp *dhcpClientManager) error {
 	for _, i := range Interfaces {
+		// the device of the interface could fail to be created by loadDevices
 		link, err := netlink.LinkByName(i.Name)
+		if err != nil {
+			fmt.Printf("interface [%s] not found: %v\n", i.Name, err)
+			continue
+		}
+
+		err = applyLinkConfig(link, i)
 		if err != nil {
 			return err
 		}
@@ -116,7 +132,9 @@ type Plugin struct {
 	ctx      context.Context
 	settings PluginSettings
 
-	dhcp *dhcpClientManager
+	dhcp     *dhcpClientManager
+	stats    *statsCollector
+	reloader *reloader
 }
 
 func (p *Plugin) ID() string {
@@ -140,32 +158,33 @@ func (p *Plugin) Run(ctx context.Context, api PluginAPI) error {
 		return err
 	}
 
+	if p.settings.splitOwner() {
+		err = p.api.SaveModuleConfig(&p.settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.reloader = newReloader(api)
+
 	p.dhcp = NewDHCPClientManager(ctx, nil, func() {
-		p.api.Reload()
+		p.reloader.reload("")
 	})
 
+	p.stats = newStatsCollector()
+	p.runStatsMonitor()
+
+	loadDevices(p.settings.Devices)
+
 	err = loadAddresses(p.settings.Interfaces, p.dhcp)
 	if err != nil {
 		return err
 	}
 
-	//ch := make(chan netlink.LinkUpdate)
-	//done := make(chan struct{})
-	//
-	//err = netlink.LinkSubscribe(ch, done)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//go func() {
-	//	select {
-	//	case <-ctx.Done():
-	//		close(done)
-	//		return
-	//	case <-ch:
-	//		p.api.Reload()
-	//	}
-	//}()
+	err = p.runEventsMonitor()
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -176,6 +195,112 @@ func (p *Plugin) Actions() ActionsMap {
 			return NewSetArgsActionResult(true, args...)
 		},
 
+		"select-backend": func(args []string, data io.Reader) ActionResult {
+			if len(args) < 1 {
+				return NewErrorAlertActionResult(errIncorrectArgs)
+			}
+
+			if _, err := configBackendByName(args[0]); err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			p.settings.Backend = args[0]
+
+			err := p.api.SaveModuleConfig(&p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewSetArgsActionResult(false, args...)
+		},
+
+		"write-config-files": func(args []string, data io.Reader) ActionResult {
+			if len(args) < 2 {
+				return NewErrorAlertActionResult(errIncorrectArgs)
+			}
+
+			backend, err := configBackendByName(args[0])
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			confirm := "confirm" == args[1]
+
+			if confirm {
+				return NewModalActionResult(
+					fmt.Sprintf("Write %s config files", backend.title()),
+					NewLabel("Do you sure about this?"),
+					NewButton("Write", "write-config-files", backend.name(), "").SetStyle(StyleDanger),
+					NewButton("Cancel", "none").SetStyle(StyleSecondary),
+				)
+			}
+
+			changes, _, err := configChanges(backend, &p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			err = writeChanges(changes)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
+		"import-config-files": func(args []string, data io.Reader) ActionResult {
+			if len(args) < 2 {
+				return NewErrorAlertActionResult(errIncorrectArgs)
+			}
+
+			backend, err := configBackendByName(args[0])
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			confirm := "confirm" == args[1]
+
+			imported, err := backend.load()
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			if confirm {
+				if len(imported.Devices) == 0 && len(imported.Interfaces) == 0 {
+					return NewAlertActionResult("Import", "Nothing to import")
+				}
+
+				return NewModalActionResult(
+					fmt.Sprintf("Import %s config", backend.title()),
+					renderImportPreview(imported),
+					NewButton("Import", "import-config-files", backend.name(), "").SetStyle(StyleDanger),
+					NewButton("Cancel", "none").SetStyle(StyleSecondary),
+				)
+			}
+
+			// the merged settings are saved only after the import is applied
+			merged, err := p.settings.clone()
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			merged.merge(imported)
+
+			err = p.applyImported(imported, merged)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			p.settings = *merged
+
+			err = p.api.SaveModuleConfig(&p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
 		"dhcp-client": func(args []string, data io.Reader) ActionResult {
 			linkName := args[0]
 
@@ -213,17 +338,48 @@ func (p *Plugin) Actions() ActionsMap {
 			return NewReloadActionResult()
 		},
 
+		"update-link": func(args []string, data io.Reader) ActionResult {
+			linkName := args[0]
+
+			link, err := netlink.LinkByName(linkName)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			var reqData linkUpdateDef
+
+			err = json.NewDecoder(data).Decode(&reqData)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			// the config is added to the settings only after the link is changed
+			updated := *p.settings.interfaceConfig(linkName)
+
+			err = reqData.parse(&updated)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			err = applyLinkConfig(link, &updated)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			*p.settings.interfaceByName(linkName) = updated
+
+			err = p.api.SaveModuleConfig(&p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
 		"add-device": func(args []string, data io.Reader) ActionResult {
 			confirm := "confirm" == args[0]
 
-			reqData := struct {
-				Name    string `json:"name"`
-				DevType string `json:"dev-type"`
-				Manage  bool   `json:"manage"`
-
-				VlanID     int    `json:"vlan-id"`
-				VlanParent string `json:"vlan-parent"`
-			}{}
+			reqData := deviceReqData{}
 
 			err := json.NewDecoder(data).Decode(&reqData)
 			if err != nil && err != io.EOF {
@@ -243,93 +399,50 @@ func (p *Plugin) Actions() ActionsMap {
 
 			form.AddWithTitle("Device type",
 				NewSelect("dev-type").SetChangeAction("add-device", "").SetValue(reqData.DevType).
-					AddNamedOption("Vlan", "vlan").
-					AddNamedOption("Bridge", "bridge"),
-				//AddNamedOption("Tun/Tap", "tun"),
+					AddOption("").
+					AddNamedOption("Vlan", devTypeVlan).
+					AddNamedOption("Bridge", devTypeBridge).
+					AddNamedOption("Bond", devTypeBond).
+					AddNamedOption("Tun/Tap", devTypeTuntap).
+					AddNamedOption("Macvlan", devTypeMacvlan).
+					AddNamedOption("IPvlan", devTypeIPVlan).
+					AddNamedOption("VXLAN", devTypeVxlan).
+					AddNamedOption("Veth pair", devTypeVeth),
 			)
 
-			form.AddWithTitle("Manage", NewSwitch("manage"))
+			form.AddWithTitle("Manage", NewSwitch("manage").SetValue(reqData.Manage))
 
 			modal := NewFormModalActionResult("Create device", form)
 
-			if confirm && reqData.Name == "" {
-				nameInput.SetErrorText("Name can't be empty")
-				return modal
+			devForm, err := newDeviceForm(form, nameInput, &reqData)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
 			}
 
-			switch reqData.DevType {
-			case "vlan":
-				vlanParentSelect := NewSelect("vlan-parent").AddOption("").SetValue(reqData.VlanParent)
-
-				links, err := netlink.LinkList()
-				if err != nil {
-					return NewErrorAlertActionResult(err)
-				}
-
-				for _, l := range links {
-					if l.Attrs().Flags&net.FlagLoopback != 0 {
-						continue
-					}
-
-					vlanParentSelect.AddOption(l.Attrs().Name)
-				}
-
-				form.AddWithTitle("Parent", vlanParentSelect)
-
-				vlanIDInput := NewNumberInput("vlan-id").SetValue(reqData.VlanID)
-				form.AddWithTitle("vlan ID", vlanIDInput)
-
-				if confirm {
-					if reqData.VlanID < 0 || reqData.VlanID > 4095 {
-						vlanIDInput.SetErrorText("Incorrect VlanID")
-						return modal
-					}
-
-					if reqData.VlanParent == "" {
-						vlanParentSelect.SetErrorText("Parent for vlan device not set")
-						return modal
-					}
-
-					la := netlink.NewLinkAttrs()
-					la.Name = reqData.Name
-
-					link, err := netlink.LinkByName(reqData.VlanParent)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
-
-					la.ParentIndex = link.Attrs().Index
-
-					vlanOpt := &netlink.Vlan{
-						LinkAttrs:    la,
-						VlanId:       reqData.VlanID,
-						VlanProtocol: netlink.VLAN_PROTOCOL_8021Q,
-					}
-
-					err = netlink.LinkAdd(vlanOpt)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
+			if !confirm {
+				return modal
+			}
 
-					return NewReloadActionResult()
-				}
+			dev := devForm.validate(&reqData)
+			if dev == nil {
+				return modal
+			}
 
-			case "bridge":
-				if confirm {
-					la := netlink.NewLinkAttrs()
-					la.Name = reqData.Name
+			err = createDevice(dev)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
 
-					bridgeOpt := &netlink.Bridge{LinkAttrs: la}
-					err = netlink.LinkAdd(bridgeOpt)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
+			if reqData.Manage {
+				p.settings.setDevice(dev)
 
-					return NewReloadActionResult()
+				err = p.api.SaveModuleConfig(&p.settings)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
 				}
 			}
 
-			return modal
+			return NewReloadActionResult()
 		},
 
 		"delete-device": func(args []string, data io.Reader) ActionResult {
@@ -355,6 +468,13 @@ func (p *Plugin) Actions() ActionsMap {
 				return NewErrorAlertActionResult(err)
 			}
 
+			if p.settings.delDevice(linkName) {
+				err = p.api.SaveModuleConfig(&p.settings)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+			}
+
 			return NewReloadActionResult()
 		},
 
@@ -529,7 +649,11 @@ func (p *Plugin) SubRenders() []SubPageRender {
 			Render: func(args []string) Page {
 				if len(args) > 0 {
 					devName := args[0]
-					return p.renderDevice(devName)
+
+					// device could be removed while the page is open
+					if _, err := netlink.LinkByName(devName); err == nil {
+						return p.renderDevice(devName)
+					}
 				}
 
 				return p.renderDevList()
@@ -592,6 +716,12 @@ func (p *Plugin) SubRenders() []SubPageRender {
 				return NewPage("Routing", table)
 			},
 		},
+		{
+			Title: "Config files",
+			Render: func(args []string) Page {
+				return p.renderConfigFiles(args)
+			},
+		},
 		{
 			Title: "DHCP server",
 			Render: func(args []string) Page {
@@ -649,7 +779,7 @@ func (p *Plugin) renderDevice(devName string) Page {
 	}
 
 	for _, l := range links {
-		if l.Type() != "bridge" || link.Attrs().Name == l.Attrs().Name {
+		if (l.Type() != devTypeBridge && l.Type() != devTypeBond) || link.Attrs().Name == l.Attrs().Name {
 			continue
 		}
 
@@ -669,22 +799,54 @@ func (p *Plugin) renderDevice(devName string) Page {
 		masterSelect.SetBadgeStyle(StyleSecondary)
 	}
 
-	return NewPage(
+	typeLine := NewLine(NewLabel(link.Type()))
+
+	if p.settings.deviceExist(devName) {
+		typeLine.Add(NewBadge("manage").SetStyle(StyleSuccess))
+	}
+
+	page := NewPage(
 		fmt.Sprintf("Network interface %s", link.Attrs().Name),
 		NewButton("Back", "select-dev"),
 		NewHeader("Interface info"),
 		NewElementsList().SetModeLine().
 			AddElementWithTitle(NewLabel("Name").SetStrong(true), NewLabel(link.Attrs().Name)).
-			AddElementWithTitle(NewLabel("Type").SetStrong(true), NewLabel(link.Type())).
-			AddElementWithTitle(NewLabel("Mac").SetStrong(true), NewLabel(link.Attrs().HardwareAddr.String()).SetMonospace(true)).
+			AddElementWithTitle(NewLabel("Type").SetStrong(true), typeLine).
+			AddElementWithTitle(NewLabel("State").SetStrong(true), operStateBadge(link)).
+			AddElementWithTitle(NewLabel("Alias").SetStrong(true), NewInputEdit("alias", link.Attrs().Alias, "update-link", devName)).
+			AddElementWithTitle(NewLabel("Mac").SetStrong(true), NewInputEdit("mac", link.Attrs().HardwareAddr.String(), "update-link", devName)).
+			AddElementWithTitle(NewLabel("MTU").SetStrong(true), NewInputEdit("mtu", fmt.Sprintf("%d", link.Attrs().MTU), "update-link", devName)).
+			AddElementWithTitle(NewLabel("Tx queue length").SetStrong(true), NewInputEdit("txqlen", fmt.Sprintf("%d", link.Attrs().TxQLen), "update-link", devName)).
+			AddElementWithTitle(NewLabel("Up").SetStrong(true), NewSwitch("up").
+				SetAction("update-link", devName).SetValue(link.Attrs().Flags&net.FlagUp != 0),
+			).
+			AddElementWithTitle(NewLabel("Promiscuous mode").SetStrong(true), NewSwitch("promisc").
+				SetAction("update-link", devName).SetValue(link.Attrs().Promisc != 0),
+			).
 			AddElementWithTitle(NewLabel("Master").SetStrong(true), masterSelect).
 			AddElementWithTitle(NewLabel("DHCP").SetStrong(true), NewSwitch("dhcp").
 				SetAction("dhcp-client", link.Attrs().Name).SetValue(dhcpOpt != nil),
 			),
+	)
+
+	if details := renderDeviceDetails(link); details != nil {
+		page.AddElements(
+			NewHeader("Device options"),
+			details,
+		)
+	}
+
+	page.AddElements(
 		NewHeader("IP addresses"),
 		NewButton("Add address", "add-ip-address", link.Attrs().Name, ""),
 		addressTable,
+		NewHeader("Neighbors"),
+		renderNeighbors(link),
 	)
+
+	page.AddElements(p.renderStats(devName)...)
+
+	return page
 }
 
 func (p *Plugin) renderDevList() Page {
@@ -696,20 +858,9 @@ func (p *Plugin) renderDevList() Page {
 	table := NewTable("Dev name", "Type", "Mac", "IP", "")
 
 	for _, l := range links {
-		addrs, err := netlink.AddrList(l, netlink.FAMILY_V4)
-		if err != nil {
-			panic(err)
-		}
-
-		addrsList := NewElementsList().SetModeLine()
-
-		for _, a := range addrs {
-			addrsList.AddElements(NewLabel(a.IPNet.String()))
-		}
-
 		controls := NewLine()
 
-		if l.Type() == "bridge" || l.Type() == "vlan" {
+		if isVirtualDevice(l) {
 			controls.Add(
 				NewImageButton("trash", "delete-device", l.Attrs().Name, "confirm").SetLinkStyle(),
 			)
@@ -718,8 +869,8 @@ func (p *Plugin) renderDevList() Page {
 		table.AddLine(
 			NewButton(l.Attrs().Name, "select-dev", l.Attrs().Name).SetLinkStyle(),
 			NewLabel(l.Type()),
-			NewLabel(l.Attrs().HardwareAddr.String()).SetMonospace(true),
-			addrsList,
+			NewLabel(l.Attrs().HardwareAddr.String()).SetMonospace(true).SetID(macLabelID(l.Attrs().Name)),
+			NewLabel(formatAddrs(l)).SetID(addrsLabelID(l.Attrs().Name)),
 			controls,
 		)
 	}
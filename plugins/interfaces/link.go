@@ -0,0 +1,191 @@
+package interfaces
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+)
+
+type linkUpdateDef struct {
+	MTU     *string `json:"mtu"`
+	MAC     *string `json:"mac"`
+	TxQLen  *string `json:"txqlen"`
+	Alias   *string `json:"alias"`
+	Up      *bool   `json:"up"`
+	Promisc *bool   `json:"promisc"`
+}
+
+func (ps *PluginSettings) interfaceByName(name string) *interfaceConfig {
+	for _, i := range ps.Interfaces {
+		if i.Name == name {
+			return i
+		}
+	}
+
+	i := &interfaceConfig{
+		Name: name,
+	}
+
+	ps.Interfaces = append(ps.Interfaces, i)
+
+	return i
+}
+
+// parse validates update request and stores the new values in the interface config.
+func (d *linkUpdateDef) parse(cfg *interfaceConfig) error {
+	if d.MTU != nil {
+		mtu, err := strconv.Atoi(strings.TrimSpace(*d.MTU))
+		if err != nil || mtu < 68 || mtu > 65535 {
+			return errors.New("incorrect MTU")
+		}
+
+		cfg.MTU = mtu
+	}
+
+	if d.MAC != nil {
+		mac, err := net.ParseMAC(strings.TrimSpace(*d.MAC))
+		if err != nil {
+			return errors.New("incorrect MAC address")
+		}
+
+		cfg.MAC = mac.String()
+	}
+
+	if d.TxQLen != nil {
+		qlen, err := strconv.Atoi(strings.TrimSpace(*d.TxQLen))
+		if err != nil || qlen < 0 {
+			return errors.New("incorrect transmit queue length")
+		}
+
+		cfg.TxQLen = qlen
+	}
+
+	if d.Alias != nil {
+		alias := strings.TrimSpace(*d.Alias)
+		cfg.Alias = &alias
+	}
+
+	if d.Up != nil {
+		up := *d.Up
+		cfg.Up = &up
+	}
+
+	if d.Promisc != nil {
+		promisc := *d.Promisc
+		cfg.Promisc = &promisc
+	}
+
+	return nil
+}
+
+// linkConfig returns the current attributes of the link as the interface config.
+func linkConfig(attrs *netlink.LinkAttrs) *interfaceConfig {
+	alias := attrs.Alias
+	up := attrs.Flags&net.FlagUp != 0
+	promisc := attrs.Promisc != 0
+
+	return &interfaceConfig{
+		Name:    attrs.Name,
+		MTU:     attrs.MTU,
+		MAC:     attrs.HardwareAddr.String(),
+		TxQLen:  attrs.TxQLen,
+		Alias:   &alias,
+		Up:      &up,
+		Promisc: &promisc,
+	}
+}
+
+// restoreLinkConfig sets the attributes which were saved by linkConfig.
+func restoreLinkConfig(index int, previous *interfaceConfig) error {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return err
+	}
+
+	return setLinkAttrs(link, previous)
+}
+
+// applyLinkConfig sets link attributes which differ from the interface config,
+// attributes are set one by one, so the ones set before a failure are restored.
+func applyLinkConfig(link netlink.Link, cfg *interfaceConfig) error {
+	previous := linkConfig(link.Attrs())
+
+	err := setLinkAttrs(link, cfg)
+	if err != nil {
+		if restoreErr := restoreLinkConfig(link.Attrs().Index, previous); restoreErr != nil {
+			return errors.Wrapf(err, "failed to restore link attributes: %v", restoreErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func setLinkAttrs(link netlink.Link, cfg *interfaceConfig) error {
+	attrs := link.Attrs()
+
+	var err error
+
+	if cfg.MTU > 0 && cfg.MTU != attrs.MTU {
+		err = netlink.LinkSetMTU(link, cfg.MTU)
+		if err != nil {
+			return errors.Wrap(err, "failed to set MTU")
+		}
+	}
+
+	if cfg.MAC != "" && cfg.MAC != attrs.HardwareAddr.String() {
+		mac, err := net.ParseMAC(cfg.MAC)
+		if err != nil {
+			return err
+		}
+
+		err = netlink.LinkSetHardwareAddr(link, mac)
+		if err != nil {
+			return errors.Wrap(err, "failed to set MAC address")
+		}
+	}
+
+	if cfg.TxQLen > 0 && cfg.TxQLen != attrs.TxQLen {
+		err = netlink.LinkSetTxQLen(link, cfg.TxQLen)
+		if err != nil {
+			return errors.Wrap(err, "failed to set transmit queue length")
+		}
+	}
+
+	if cfg.Alias != nil && *cfg.Alias != attrs.Alias {
+		err = netlink.LinkSetAlias(link, *cfg.Alias)
+		if err != nil {
+			return errors.Wrap(err, "failed to set alias")
+		}
+	}
+
+	if cfg.Promisc != nil && *cfg.Promisc != (attrs.Promisc != 0) {
+		if *cfg.Promisc {
+			err = netlink.SetPromiscOn(link)
+		} else {
+			err = netlink.SetPromiscOff(link)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "failed to set promiscuous mode")
+		}
+	}
+
+	if cfg.Up != nil && *cfg.Up != (attrs.Flags&net.FlagUp != 0) {
+		if *cfg.Up {
+			err = netlink.LinkSetUp(link)
+		} else {
+			err = netlink.LinkSetDown(link)
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "failed to change link state")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package interfaces
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const netplanFile = "90-qubert.yaml"
+
+// netplanDir is a variable to be replaced by tests.
+var netplanDir = "/etc/netplan"
+
+type netplanParameters struct {
+	Mode               string `yaml:"mode,omitempty"`
+	MiiMonitorInterval int    `yaml:"mii-monitor-interval,omitempty"`
+}
+
+type netplanInterface struct {
+	DHCP4          bool               `yaml:"dhcp4,omitempty"`
+	Addresses      []string           `yaml:"addresses,omitempty"`
+	MTU            int                `yaml:"mtu,omitempty"`
+	MACAddress     string             `yaml:"macaddress,omitempty"`
+	ActivationMode string             `yaml:"activation-mode,omitempty"`
+	Interfaces     []string           `yaml:"interfaces,omitempty"`
+	Parameters     *netplanParameters `yaml:"parameters,omitempty"`
+	ID             int                `yaml:"id,omitempty"`
+	Link           string             `yaml:"link,omitempty"`
+	Mode           string             `yaml:"mode,omitempty"`
+	Remote         string             `yaml:"remote,omitempty"`
+	Port           int                `yaml:"port,omitempty"`
+}
+
+type netplanNetwork struct {
+	Version   int                          `yaml:"version"`
+	Renderer  string                       `yaml:"renderer,omitempty"`
+	Ethernets map[string]*netplanInterface `yaml:"ethernets,omitempty"`
+	Bonds     map[string]*netplanInterface `yaml:"bonds,omitempty"`
+	Bridges   map[string]*netplanInterface `yaml:"bridges,omitempty"`
+	Vlans     map[string]*netplanInterface `yaml:"vlans,omitempty"`
+	Tunnels   map[string]*netplanInterface `yaml:"tunnels,omitempty"`
+}
+
+type netplanConfig struct {
+	Network netplanNetwork `yaml:"network"`
+}
+
+type netplanBackend struct{}
+
+func (b *netplanBackend) name() string  { return "netplan" }
+func (b *netplanBackend) title() string { return "Netplan" }
+
+func addNetplanInterface(m *map[string]*netplanInterface, name string) *netplanInterface {
+	if *m == nil {
+		*m = make(map[string]*netplanInterface)
+	}
+
+	i, ok := (*m)[name]
+	if !ok {
+		i = &netplanInterface{}
+		(*m)[name] = i
+	}
+
+	return i
+}
+
+func (b *netplanBackend) render(ps *PluginSettings) ([]*configFile, []string) {
+	var warnings []string
+
+	cfg := netplanConfig{
+		Network: netplanNetwork{
+			Version: 2,
+		},
+	}
+
+	n := &cfg.Network
+
+	for _, name := range ps.managedLinkNames() {
+		var ni *netplanInterface
+
+		if d := ps.deviceByName(name); d != nil {
+			switch d.Type {
+			case devTypeVlan:
+				ni = addNetplanInterface(&n.Vlans, name)
+				ni.ID = d.VlanID
+				ni.Link = d.Parent
+			case devTypeBridge:
+				ni = addNetplanInterface(&n.Bridges, name)
+			case devTypeBond:
+				ni = addNetplanInterface(&n.Bonds, name)
+				ni.Interfaces = d.Slaves
+
+				if d.Mode != "" || d.Miimon > 0 {
+					ni.Parameters = &netplanParameters{
+						Mode:               d.Mode,
+						MiiMonitorInterval: d.Miimon,
+					}
+				}
+			case devTypeVxlan:
+				ni = addNetplanInterface(&n.Tunnels, name)
+				ni.Mode = devTypeVxlan
+				ni.ID = d.VNI
+				ni.Link = d.Parent
+				ni.Remote = d.Remote
+
+				if d.Port != defaultVxlanPort {
+					ni.Port = d.Port
+				}
+			default:
+				warnings = append(warnings, fmt.Sprintf("%s: %s devices are not supported by netplan", name, d.Type))
+				continue
+			}
+		} else {
+			ni = addNetplanInterface(&n.Ethernets, name)
+		}
+
+		i := ps.interfaceConfig(name)
+
+		ni.DHCP4 = i.DHCP
+		ni.Addresses = i.IpAddrs
+		ni.MTU = i.MTU
+		ni.MACAddress = i.MAC
+
+		if i.Up != nil && !*i.Up {
+			ni.ActivationMode = "off"
+		}
+
+		warnings = append(warnings, linkWarnings(i, "mtu", "mac", "up")...)
+	}
+
+	// netplan requires the definitions of all interfaces which are used by the devices
+	for _, d := range ps.Devices {
+		names := append([]string{d.Parent}, d.Slaves...)
+
+		for _, name := range names {
+			if name == "" || n.Vlans[name] != nil || n.Bonds[name] != nil || n.Bridges[name] != nil || n.Tunnels[name] != nil {
+				continue
+			}
+
+			addNetplanInterface(&n.Ethernets, name)
+		}
+	}
+
+	data, err := yaml.Marshal(&cfg)
+	if err != nil {
+		return nil, append(warnings, err.Error())
+	}
+
+	return []*configFile{
+		{
+			path: filepath.Join(netplanDir, netplanFile),
+			perm: 0600,
+			data: fmt.Sprintf("# %s\n%s", generatedHeader, data),
+		},
+	}, warnings
+}
+
+func (b *netplanBackend) generatedFiles() ([]string, error) {
+	return generatedFilesByPattern(filepath.Join(netplanDir, "*.yaml"))
+}
+
+func (b *netplanBackend) load() (*PluginSettings, error) {
+	paths, err := filepath.Glob(filepath.Join(netplanDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	// files are applied in the lexicographical order, the later file overrides the former
+	sort.Strings(paths)
+
+	var n netplanNetwork
+
+	for _, path := range paths {
+		data, _, err := readFileIfExist(path)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg := netplanConfig{}
+
+		err = yaml.Unmarshal([]byte(data), &cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse [%s]", path)
+		}
+
+		for _, m := range []struct {
+			dst *map[string]*netplanInterface
+			src map[string]*netplanInterface
+		}{
+			{&n.Ethernets, cfg.Network.Ethernets},
+			{&n.Bonds, cfg.Network.Bonds},
+			{&n.Bridges, cfg.Network.Bridges},
+			{&n.Vlans, cfg.Network.Vlans},
+			{&n.Tunnels, cfg.Network.Tunnels},
+		} {
+			for name, i := range m.src {
+				if i == nil {
+					i = &netplanInterface{}
+				}
+
+				*addNetplanInterface(m.dst, name) = *i
+			}
+		}
+	}
+
+	result := &PluginSettings{}
+
+	addInterface := func(name string, ni *netplanInterface) {
+		if !ni.DHCP4 && len(ni.Addresses) == 0 && ni.MTU == 0 && ni.MACAddress == "" {
+			return
+		}
+
+		result.Interfaces = append(result.Interfaces, &interfaceConfig{
+			Name:    name,
+			IpAddrs: ni.Addresses,
+			DHCP:    ni.DHCP4,
+			MTU:     ni.MTU,
+			MAC:     ni.MACAddress,
+		})
+	}
+
+	for name, ni := range n.Ethernets {
+		addInterface(name, ni)
+	}
+
+	for name, ni := range n.Vlans {
+		result.setDevice(&deviceConfig{
+			Name:   name,
+			Type:   devTypeVlan,
+			Parent: ni.Link,
+			VlanID: ni.ID,
+		})
+
+		addInterface(name, ni)
+	}
+
+	for name, ni := range n.Bridges {
+		result.setDevice(&deviceConfig{
+			Name: name,
+			Type: devTypeBridge,
+		})
+
+		addInterface(name, ni)
+	}
+
+	for name, ni := range n.Bonds {
+		d := &deviceConfig{
+			Name:   name,
+			Type:   devTypeBond,
+			Slaves: ni.Interfaces,
+		}
+
+		if ni.Parameters != nil {
+			d.Mode = ni.Parameters.Mode
+			d.Miimon = ni.Parameters.MiiMonitorInterval
+		}
+
+		result.setDevice(d)
+
+		addInterface(name, ni)
+	}
+
+	for name, ni := range n.Tunnels {
+		if ni.Mode != devTypeVxlan {
+			continue
+		}
+
+		port := ni.Port
+		if port == 0 {
+			port = defaultVxlanPort
+		}
+
+		result.setDevice(&deviceConfig{
+			Name:   name,
+			Type:   devTypeVxlan,
+			Parent: ni.Link,
+			VNI:    ni.ID,
+			Remote: ni.Remote,
+			Port:   port,
+		})
+
+		addInterface(name, ni)
+	}
+
+	sort.Slice(result.Devices, func(i, j int) bool { return result.Devices[i].Name < result.Devices[j].Name })
+	sort.Slice(result.Interfaces, func(i, j int) bool { return result.Interfaces[i].Name < result.Interfaces[j].Name })
+
+	return result, nil
+}
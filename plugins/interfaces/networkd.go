@@ -0,0 +1,284 @@
+package interfaces
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const networkdFilePrefix = "10-qubert-"
+
+// networkdDir is a variable to be replaced by tests.
+var networkdDir = "/etc/systemd/network"
+
+type networkdBackend struct{}
+
+func (b *networkdBackend) name() string  { return "networkd" }
+func (b *networkdBackend) title() string { return "systemd-networkd" }
+
+func (b *networkdBackend) file(name, ext, data string) *configFile {
+	return &configFile{
+		path: filepath.Join(networkdDir, networkdFilePrefix+name+ext),
+		perm: 0644,
+		data: fmt.Sprintf("# %s\n\n%s", generatedHeader, data),
+	}
+}
+
+func networkdBool(v bool) string {
+	if v {
+		return "yes"
+	}
+
+	return "no"
+}
+
+var networkdTuntapSections = map[string]string{
+	"tun": "Tun",
+	"tap": "Tap",
+}
+
+func (b *networkdBackend) renderNetdev(d *deviceConfig) string {
+	var ini iniBuilder
+
+	kind := d.Type
+
+	switch d.Type {
+	case devTypeTuntap:
+		kind = d.Mode
+	}
+
+	ini.section("NetDev", "Name", d.Name, "Kind", kind)
+
+	switch d.Type {
+	case devTypeVlan:
+		ini.section("VLAN", "Id", strconv.Itoa(d.VlanID))
+	case devTypeBond:
+		miimon := ""
+		if d.Miimon > 0 {
+			miimon = fmt.Sprintf("%dms", d.Miimon)
+		}
+
+		ini.section("Bond", "Mode", d.Mode, "MIIMonitorSec", miimon)
+	case devTypeTuntap:
+		multiQueue := ""
+		if d.MultiQueue {
+			multiQueue = networkdBool(true)
+		}
+
+		ini.section(networkdTuntapSections[d.Mode], "User", d.Owner, "Group", d.Group, "MultiQueue", multiQueue)
+	case devTypeMacvlan:
+		ini.section("MACVLAN", "Mode", d.Mode)
+	case devTypeIPVlan:
+		ini.section("IPVLAN", "Mode", strings.ToUpper(d.Mode))
+	case devTypeVxlan:
+		port := ""
+		if d.Port > 0 && d.Port != defaultVxlanPort {
+			port = strconv.Itoa(d.Port)
+		}
+
+		ini.section("VXLAN", "VNI", strconv.Itoa(d.VNI), "Remote", d.Remote, "DestinationPort", port)
+	case devTypeVeth:
+		ini.section("Peer", "Name", d.Peer)
+	}
+
+	return ini.String()
+}
+
+func (b *networkdBackend) renderNetwork(ps *PluginSettings, name string) (string, []string) {
+	var ini iniBuilder
+
+	i := ps.interfaceConfig(name)
+
+	ini.section("Match", "Name", name)
+
+	activation := ""
+	if i.Up != nil && !*i.Up {
+		activation = "down"
+	}
+
+	promisc := ""
+	if i.Promisc != nil && *i.Promisc {
+		promisc = networkdBool(true)
+	}
+
+	ini.section("Link", "MTUBytes", itoa(i.MTU), "MACAddress", i.MAC, "ActivationPolicy", activation, "Promiscuous", promisc)
+
+	var network []string
+
+	if i.DHCP {
+		network = append(network, "DHCP", "ipv4")
+	}
+
+	for _, a := range i.IpAddrs {
+		network = append(network, "Address", a)
+	}
+
+	for _, d := range ps.Devices {
+		if d.Parent != name {
+			continue
+		}
+
+		switch d.Type {
+		case devTypeVlan, devTypeMacvlan, devTypeIPVlan, devTypeVxlan:
+			network = append(network, strings.ToUpper(d.Type), d.Name)
+		}
+	}
+
+	network = append(network, "Bond", ps.bondOf(name))
+
+	ini.section("Network", network...)
+
+	return ini.String(), linkWarnings(i, "mtu", "mac", "up", "promisc")
+}
+
+func (b *networkdBackend) render(ps *PluginSettings) ([]*configFile, []string) {
+	var (
+		files    []*configFile
+		warnings []string
+	)
+
+	names := ps.managedLinkNames()
+
+	// parents of the devices need the network file to attach the devices
+	for _, d := range ps.Devices {
+		names = append(names, d.Parent)
+		names = append(names, d.Slaves...)
+	}
+
+	done := make(map[string]bool)
+
+	for _, name := range names {
+		if name == "" || done[name] {
+			continue
+		}
+
+		done[name] = true
+
+		if d := ps.deviceByName(name); d != nil {
+			files = append(files, b.file(name, ".netdev", b.renderNetdev(d)))
+		}
+
+		data, w := b.renderNetwork(ps, name)
+		files = append(files, b.file(name, ".network", data))
+		warnings = append(warnings, w...)
+	}
+
+	return files, warnings
+}
+
+func (b *networkdBackend) generatedFiles() ([]string, error) {
+	return generatedFilesByPattern(filepath.Join(networkdDir, networkdFilePrefix+"*"))
+}
+
+func (b *networkdBackend) load() (*PluginSettings, error) {
+	result := &PluginSettings{}
+
+	netdevs, err := filepath.Glob(filepath.Join(networkdDir, "*.netdev"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range netdevs {
+		data, _, err := readFileIfExist(path)
+		if err != nil {
+			return nil, err
+		}
+
+		ini := parseIni(data)
+
+		d := &deviceConfig{
+			Name: ini.first("NetDev", "Name"),
+			Type: ini.first("NetDev", "Kind"),
+		}
+
+		switch d.Type {
+		case devTypeVlan:
+			d.VlanID, _ = strconv.Atoi(ini.first("VLAN", "Id"))
+		case devTypeBridge:
+		case devTypeBond:
+			d.Mode = ini.first("Bond", "Mode")
+			d.Miimon, _ = strconv.Atoi(strings.TrimSuffix(ini.first("Bond", "MIIMonitorSec"), "ms"))
+		case "tun", "tap":
+			section := networkdTuntapSections[d.Type]
+
+			d.Mode = d.Type
+			d.Type = devTypeTuntap
+			d.Owner = ini.first(section, "User")
+			d.Group = ini.first(section, "Group")
+			d.MultiQueue = ini.first(section, "MultiQueue") == "yes"
+		case devTypeMacvlan:
+			d.Mode = ini.first("MACVLAN", "Mode")
+		case devTypeIPVlan:
+			d.Mode = strings.ToLower(ini.first("IPVLAN", "Mode"))
+		case devTypeVxlan:
+			d.VNI, _ = strconv.Atoi(ini.first("VXLAN", "VNI"))
+			d.Remote = ini.first("VXLAN", "Remote")
+			d.Port, _ = strconv.Atoi(ini.first("VXLAN", "DestinationPort"))
+		case devTypeVeth:
+			d.Peer = ini.first("Peer", "Name")
+		default:
+			continue
+		}
+
+		if d.Name == "" {
+			continue
+		}
+
+		result.setDevice(d)
+	}
+
+	networks, err := filepath.Glob(filepath.Join(networkdDir, "*.network"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range networks {
+		data, _, err := readFileIfExist(path)
+		if err != nil {
+			return nil, err
+		}
+
+		ini := parseIni(data)
+
+		// only files matched to the one interface by name can be imported
+		match := ini.get("Match", "Name")
+		if len(match) != 1 || strings.ContainsAny(match[0], "*?[") {
+			continue
+		}
+
+		name := match[0]
+
+		for _, kind := range []string{"VLAN", "MACVLAN", "IPVLAN", "VXLAN"} {
+			for _, devName := range ini.get("Network", kind) {
+				if d := result.deviceByName(devName); d != nil {
+					d.Parent = name
+				}
+			}
+		}
+
+		if bond := result.deviceByName(ini.first("Network", "Bond")); bond != nil {
+			bond.Slaves = append(bond.Slaves, name)
+		}
+
+		i := &interfaceConfig{
+			Name: name,
+			MAC:  ini.first("Link", "MACAddress"),
+		}
+
+		i.MTU, _ = strconv.Atoi(ini.first("Link", "MTUBytes"))
+
+		switch ini.first("Network", "DHCP") {
+		case "yes", "true", "ipv4":
+			i.DHCP = true
+		}
+
+		i.IpAddrs = append(ini.get("Network", "Address"), ini.get("Address", "Address")...)
+
+		if i.DHCP || len(i.IpAddrs) > 0 || i.MTU > 0 || i.MAC != "" {
+			result.Interfaces = append(result.Interfaces, i)
+		}
+	}
+
+	return result, nil
+}
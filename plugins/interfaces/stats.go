@@ -0,0 +1,268 @@
+package interfaces
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	statsHistoryStep = 10 * time.Second
+	statsHistorySize = int(time.Hour / statsHistoryStep)
+)
+
+type statsPoint struct {
+	at time.Time
+
+	rxBytes uint64
+	txBytes uint64
+}
+
+type linkStats struct {
+	current netlink.LinkStatistics
+	sampled time.Time
+
+	rxRate float64
+	txRate float64
+
+	// last history point and rates (bytes per second) for the last hour
+	historyPoint statsPoint
+	historyCount int
+	rxHistory    []float64
+	txHistory    []float64
+}
+
+type statsCollector struct {
+	mx    sync.Mutex
+	links map[string]*linkStats
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		links: make(map[string]*linkStats),
+	}
+}
+
+func rate(cur, prev uint64, d time.Duration) float64 {
+	if cur < prev || d <= 0 {
+		return 0
+	}
+
+	return float64(cur-prev) / d.Seconds()
+}
+
+func appendHistory(history []float64, v float64) []float64 {
+	history = append(history, v)
+
+	if len(history) > statsHistorySize {
+		history = history[len(history)-statsHistorySize:]
+	}
+
+	return history
+}
+
+// sample reads statistics of all links and returns names of the sampled links.
+func (c *statsCollector) sample() ([]string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	now := time.Now()
+	names := make([]string, 0, len(links))
+	exist := make(map[string]bool, len(links))
+
+	for _, l := range links {
+		attrs := l.Attrs()
+		if attrs.Statistics == nil {
+			continue
+		}
+
+		names = append(names, attrs.Name)
+		exist[attrs.Name] = true
+
+		s, ok := c.links[attrs.Name]
+		if !ok {
+			s = &linkStats{
+				historyPoint: statsPoint{
+					at:      now,
+					rxBytes: attrs.Statistics.RxBytes,
+					txBytes: attrs.Statistics.TxBytes,
+				},
+			}
+
+			c.links[attrs.Name] = s
+		} else {
+			d := now.Sub(s.sampled)
+
+			s.rxRate = rate(attrs.Statistics.RxBytes, s.current.RxBytes, d)
+			s.txRate = rate(attrs.Statistics.TxBytes, s.current.TxBytes, d)
+		}
+
+		s.current = *attrs.Statistics
+		s.sampled = now
+
+		if d := now.Sub(s.historyPoint.at); d >= statsHistoryStep {
+			s.rxHistory = appendHistory(s.rxHistory, rate(s.current.RxBytes, s.historyPoint.rxBytes, d))
+			s.txHistory = appendHistory(s.txHistory, rate(s.current.TxBytes, s.historyPoint.txBytes, d))
+			s.historyCount++
+
+			s.historyPoint = statsPoint{
+				at:      now,
+				rxBytes: s.current.RxBytes,
+				txBytes: s.current.TxBytes,
+			}
+		}
+	}
+
+	for name := range c.links {
+		if !exist[name] {
+			delete(c.links, name)
+		}
+	}
+
+	return names, nil
+}
+
+// get returns a copy of the link statistics.
+func (c *statsCollector) get(name string) *linkStats {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	s, ok := c.links[name]
+	if !ok {
+		return nil
+	}
+
+	result := *s
+	result.rxHistory = append([]float64{}, s.rxHistory...)
+	result.txHistory = append([]float64{}, s.txHistory...)
+
+	return &result
+}
+
+func (p *Plugin) sendStats(name string, s *linkStats) bool {
+	updates := []Update{
+		NewUpdateLabel("rx-rate", "%s/s", FormatBytes(s.rxRate)),
+		NewUpdateLabel("tx-rate", "%s/s", FormatBytes(s.txRate)),
+		NewUpdateLabel("rx-bytes", FormatBytes(float64(s.current.RxBytes))),
+		NewUpdateLabel("tx-bytes", FormatBytes(float64(s.current.TxBytes))),
+		NewUpdateLabel("rx-packets", "%d", s.current.RxPackets),
+		NewUpdateLabel("tx-packets", "%d", s.current.TxPackets),
+		NewUpdateLabel("rx-errors", "%d", s.current.RxErrors),
+		NewUpdateLabel("tx-errors", "%d", s.current.TxErrors),
+		NewUpdateLabel("rx-dropped", "%d", s.current.RxDropped),
+		NewUpdateLabel("tx-dropped", "%d", s.current.TxDropped),
+	}
+
+	wasSent := false
+
+	for _, u := range updates {
+		wasSent = p.api.SendUpdate(u, name) || wasSent
+	}
+
+	return wasSent
+}
+
+func (p *Plugin) runStatsMonitor() {
+	go func() {
+		lastHistoryCount := make(map[string]int)
+
+		for {
+			names, err := p.stats.sample()
+			if err != nil {
+				fmt.Println(err)
+			}
+
+			wasSent := false
+
+			for _, name := range names {
+				s := p.stats.get(name)
+				if s == nil {
+					continue
+				}
+
+				if p.sendStats(name, s) {
+					wasSent = true
+
+					if s.historyCount != lastHistoryCount[name] && len(s.rxHistory) > 0 {
+						p.api.SendUpdate(NewUpdateChart("traffic-chart",
+							s.rxHistory[len(s.rxHistory)-1],
+							s.txHistory[len(s.txHistory)-1],
+						), name)
+					}
+				}
+
+				lastHistoryCount[name] = s.historyCount
+			}
+
+			interval := 2 * time.Second
+			if wasSent {
+				interval = time.Second
+			}
+
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+func (p *Plugin) renderStats(name string) []Element {
+	s := p.stats.get(name)
+	if s == nil {
+		s = &linkStats{}
+	}
+
+	statLabel := func(id string, text string, a ...interface{}) *Label {
+		return NewLabel(text, a...).SetID(id).SetMonospace(true)
+	}
+
+	table := NewTable("", "RX", "TX")
+
+	table.AddLine(
+		NewLabel("Rate").SetStrong(true),
+		statLabel("rx-rate", "%s/s", FormatBytes(s.rxRate)),
+		statLabel("tx-rate", "%s/s", FormatBytes(s.txRate)),
+	)
+	table.AddLine(
+		NewLabel("Bytes").SetStrong(true),
+		statLabel("rx-bytes", FormatBytes(float64(s.current.RxBytes))),
+		statLabel("tx-bytes", FormatBytes(float64(s.current.TxBytes))),
+	)
+	table.AddLine(
+		NewLabel("Packets").SetStrong(true),
+		statLabel("rx-packets", "%d", s.current.RxPackets),
+		statLabel("tx-packets", "%d", s.current.TxPackets),
+	)
+	table.AddLine(
+		NewLabel("Errors").SetStrong(true),
+		statLabel("rx-errors", "%d", s.current.RxErrors),
+		statLabel("tx-errors", "%d", s.current.TxErrors),
+	)
+	table.AddLine(
+		NewLabel("Dropped").SetStrong(true),
+		statLabel("rx-dropped", "%d", s.current.RxDropped),
+		statLabel("tx-dropped", "%d", s.current.TxDropped),
+	)
+
+	chart := NewChart("traffic-chart", statsHistorySize).
+		SetUnit("B/s").
+		AddSeries("RX", StyleSuccess, s.rxHistory...).
+		AddSeries("TX", StylePrimary, s.txHistory...)
+
+	return []Element{
+		NewHeader("Statistics"),
+		table,
+		NewCardWithTitle("Traffic for the last hour", chart),
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func lookupGroup(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		g, err = user.LookupGroupId(name)
+		if err != nil {
+			return 0, errors.Errorf("group [%s] not found", name)
+		}
+	}
+
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(gid), nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		u, err = user.LookupId(name)
+		if err != nil {
+			return nil, errors.Errorf("user [%s] not found", name)
+		}
+	}
+
+	return u, nil
+}
+
+// credential returns the credential of the process, it is nil when the service runs as root.
+func (s *service) credential() (*syscall.Credential, *user.User, error) {
+	if s.User == "" && s.Group == "" && len(s.Groups) == 0 {
+		return nil, nil, nil
+	}
+
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	var u *user.User
+
+	if s.User != "" {
+		var err error
+
+		u, err = lookupUser(s.User)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cred.Uid = uint32(uid)
+		cred.Gid = uint32(gid)
+	}
+
+	if s.Group != "" {
+		gid, err := lookupGroup(s.Group)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cred.Gid = gid
+	}
+
+	for _, g := range s.Groups {
+		gid, err := lookupGroup(g)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cred.Groups = append(cred.Groups, gid)
+	}
+
+	return cred, u, nil
+}
+
+// environ returns the environment of qubert with the user variables and the service variables.
+func (s *service) environ(u *user.User) []string {
+	env := os.Environ()
+
+	if u != nil {
+		env = setEnv(env, "USER", u.Username)
+		env = setEnv(env, "LOGNAME", u.Username)
+		env = setEnv(env, "HOME", u.HomeDir)
+	}
+
+	for _, e := range s.Env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		env = setEnv(env, kv[0], kv[1])
+	}
+
+	return env
+}
+
+func setEnv(env []string, name string, value string) []string {
+	for i, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			env[i] = fmt.Sprintf("%s=%s", name, value)
+			return env
+		}
+	}
+
+	return append(env, fmt.Sprintf("%s=%s", name, value))
+}
+
+// parseEnv parses variables from the text, one variable per line.
+func parseEnv(text string) ([]string, error) {
+	env := []string{}
+
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || !envNameRe.MatchString(kv[0]) {
+			return nil, errors.Errorf("incorrect variable at line %d", n+1)
+		}
+
+		env = append(env, line)
+	}
+
+	return env, nil
+}
+
+func parseUmask(umask string) (int, error) {
+	if umask == "" {
+		return -1, nil
+	}
+
+	v, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, errors.New("incorrect umask")
+	}
+
+	return int(v), nil
+}
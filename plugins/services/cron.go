@@ -0,0 +1,219 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const everyPrefix = "@every "
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	min   int
+	max   int
+	names []string
+}
+
+var cronFields = []cronField{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// schedule is a parsed cron expression or an interval.
+type schedule struct {
+	every time.Duration
+
+	// allowed values of minute, hour, day of month, month and day of week
+	fields [5]map[int]bool
+
+	domAny bool
+	dowAny bool
+}
+
+// parseSchedule parses a cron expression with 5 fields, an alias like @daily or an interval like "@every 5m".
+func parseSchedule(expr string) (*schedule, error) {
+	expr = strings.TrimSpace(expr)
+
+	if strings.HasPrefix(expr, everyPrefix) {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, everyPrefix)))
+		if err != nil || d < time.Second {
+			return nil, errors.New("incorrect interval")
+		}
+
+		return &schedule{every: d}, nil
+	}
+
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, errors.New("cron expression must have 5 fields: minute hour day month weekday")
+	}
+
+	sc := &schedule{
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}
+
+	for i, part := range parts {
+		values, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %d", i+1)
+		}
+
+		sc.fields[i] = values
+	}
+
+	// sunday can be 0 or 7
+	if sc.fields[4][7] {
+		sc.fields[4][0] = true
+	}
+
+	return sc, nil
+}
+
+func parseCronValue(v string, f cronField) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(v, name) {
+			return f.min + i, nil
+		}
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < f.min || n > f.max {
+		return 0, errors.Errorf("incorrect value [%s]", v)
+	}
+
+	return n, nil
+}
+
+func parseCronField(expr string, f cronField) (map[int]bool, error) {
+	values := map[int]bool{}
+
+	for _, item := range strings.Split(expr, ",") {
+		step := 1
+
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			var err error
+
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return nil, errors.Errorf("incorrect step [%s]", item[i+1:])
+			}
+
+			item = item[:i]
+		}
+
+		from, to := f.min, f.max
+
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			r := strings.SplitN(item, "-", 2)
+
+			var err error
+
+			if from, err = parseCronValue(r[0], f); err != nil {
+				return nil, err
+			}
+
+			if to, err = parseCronValue(r[1], f); err != nil {
+				return nil, err
+			}
+
+			if from > to {
+				return nil, errors.Errorf("incorrect range [%s]", item)
+			}
+		default:
+			v, err := parseCronValue(item, f)
+			if err != nil {
+				return nil, err
+			}
+
+			from = v
+			if step > 1 {
+				to = f.max
+			} else {
+				to = v
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (sc *schedule) matchDay(t time.Time) bool {
+	dom := sc.fields[2][t.Day()]
+	dow := sc.fields[4][int(t.Weekday())]
+
+	// like in cron, the day matches by any of the fields when both are restricted
+	switch {
+	case sc.domAny && sc.dowAny:
+		return true
+	case sc.domAny:
+		return dow
+	case sc.dowAny:
+		return dom
+	}
+
+	return dom || dow
+}
+
+// next returns the first time after the given time which matches the schedule.
+func (sc *schedule) next(after time.Time) time.Time {
+	if sc.every > 0 {
+		return after.Add(sc.every)
+	}
+
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// the schedule may never match, like the 31st of February
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !sc.fields[3][int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !sc.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !sc.fields[1][t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !sc.fields[0][t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
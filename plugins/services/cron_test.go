@@ -0,0 +1,90 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"30-10 * * * *",
+		"* * * foo *",
+		"* * * * mon-foo",
+		"@every 500ms",
+		"@every x",
+		"@sometimes",
+	} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("[%s] is parsed without an error", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	// 2024-01-01 is monday
+	monday := date(2024, time.January, 1, 10, 7)
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		next  time.Time
+	}{
+		{"every minute", "* * * * *", monday, date(2024, time.January, 1, 10, 8)},
+		{"seconds are truncated", "* * * * *", monday.Add(30 * time.Second), date(2024, time.January, 1, 10, 8)},
+		{"value", "30 * * * *", monday, date(2024, time.January, 1, 10, 30)},
+		{"list", "5,10 * * * *", monday, date(2024, time.January, 1, 10, 10)},
+		{"range", "0 9-17 * * *", date(2024, time.January, 1, 17, 30), date(2024, time.January, 2, 9, 0)},
+		{"step", "*/15 * * * *", monday, date(2024, time.January, 1, 10, 15)},
+		{"step from value", "5/20 * * * *", date(2024, time.January, 1, 10, 50), date(2024, time.January, 1, 11, 5)},
+		{"range with step", "10-30/10 * * * *", date(2024, time.January, 1, 10, 30), date(2024, time.January, 1, 11, 10)},
+		{"next day", "0 8 * * *", monday, date(2024, time.January, 2, 8, 0)},
+		{"next month", "0 0 1 * *", monday, date(2024, time.February, 1, 0, 0)},
+		{"next year", "0 0 1 1 *", monday, date(2025, time.January, 1, 0, 0)},
+		{"month names", "0 0 1 jul,DEC *", monday, date(2024, time.July, 1, 0, 0)},
+		{"weekday names", "0 12 * * mon-fri", date(2024, time.January, 6, 9, 0), date(2024, time.January, 8, 12, 0)},
+		{"sunday as 0", "0 0 * * 0", monday, date(2024, time.January, 7, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", monday, date(2024, time.January, 7, 0, 0)},
+		{"day of week only", "0 0 * * fri", monday, date(2024, time.January, 5, 0, 0)},
+		{"day of month only", "0 0 13 * *", monday, date(2024, time.January, 13, 0, 0)},
+		{"day of month or week by week", "0 0 13 * fri", monday, date(2024, time.January, 5, 0, 0)},
+		{"day of month or week by month", "0 0 13 * fri", date(2024, time.January, 12, 0, 0), date(2024, time.January, 13, 0, 0)},
+		{"restricted day of month with any weekday", "0 0 13 * *", date(2024, time.January, 12, 0, 0), date(2024, time.January, 13, 0, 0)},
+		{"short months are skipped", "0 0 31 * *", date(2024, time.January, 31, 0, 0), date(2024, time.March, 31, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2024, time.March, 1, 0, 0), date(2028, time.February, 29, 0, 0)},
+		{"impossible date", "0 0 30 2 *", monday, time.Time{}},
+		{"impossible day of month", "0 0 31 apr,jun,sep,nov *", monday, time.Time{}},
+		{"daily alias", "@daily", monday, date(2024, time.January, 2, 0, 0)},
+		{"weekly alias", "@weekly", monday, date(2024, time.January, 7, 0, 0)},
+		{"hourly alias", "@hourly", monday, date(2024, time.January, 1, 11, 0)},
+		{"interval", "@every 90m", monday, date(2024, time.January, 1, 11, 37)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := parseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("[%s]: %v", tt.expr, err)
+			}
+
+			if next := sc.next(tt.after); !next.Equal(tt.next) {
+				t.Errorf("[%s] after %s: got %s, expected %s", tt.expr, tt.after, next, tt.next)
+			}
+		})
+	}
+}
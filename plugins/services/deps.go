@@ -0,0 +1,385 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+
+	"qubert/uuid"
+)
+
+// dependencyTimeout is the max time to wait for a dependency to become ready.
+const dependencyTimeout = time.Minute
+
+// dependencies returns services which must be started before the service.
+func (ps *PluginSettings) dependencies(s *service) []*service {
+	var deps []*service
+
+	for _, id := range append(append([]uuid.UUID{}, s.After...), s.Requires...) {
+		if d := ps.FindServiceByUUID(id); d != nil {
+			deps = append(deps, d)
+		}
+	}
+
+	return deps
+}
+
+// dependents returns services which are started after the service.
+func (ps *PluginSettings) dependents(s *service) []*service {
+	var deps []*service
+
+	for _, d := range ps.Services {
+		for _, dep := range ps.dependencies(d) {
+			if dep == s {
+				deps = append(deps, d)
+				break
+			}
+		}
+	}
+
+	return deps
+}
+
+// startOrder sorts the services so dependencies go before dependents,
+// it fails when dependencies have a cycle.
+func (ps *PluginSettings) startOrder(services []*service) ([]*service, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	var (
+		order []*service
+		path  []string
+		visit func(s *service) error
+	)
+
+	include := map[*service]bool{}
+	for _, s := range services {
+		include[s] = true
+	}
+
+	marks := map[*service]int{}
+
+	visit = func(s *service) error {
+		path = append(path, s.Name)
+		defer func() { path = path[:len(path)-1] }()
+
+		switch marks[s] {
+		case visiting:
+			i := 0
+			for path[i] != s.Name {
+				i++
+			}
+
+			return errors.Errorf("dependency cycle: %s", strings.Join(path[i:], " -> "))
+		case visited:
+			return nil
+		}
+
+		marks[s] = visiting
+
+		for _, d := range ps.dependencies(s) {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+
+		marks[s] = visited
+
+		if include[s] {
+			order = append(order, s)
+		}
+
+		return nil
+	}
+
+	for _, s := range services {
+		if err := visit(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// validateDependencies checks all services for dependency cycles.
+func (ps *PluginSettings) validateDependencies() error {
+	_, err := ps.startOrder(ps.Services)
+
+	return err
+}
+
+// withService returns the settings where the service with the same ID is replaced by s,
+// it is used to validate changed dependencies before they are applied.
+func (ps *PluginSettings) withService(s *service) *PluginSettings {
+	services := make([]*service, 0, len(ps.Services))
+
+	for _, d := range ps.Services {
+		if d.ID == s.ID {
+			d = s
+		}
+
+		services = append(services, d)
+	}
+
+	return &PluginSettings{Services: services}
+}
+
+// withRequired adds required services to the list recursively.
+func (ps *PluginSettings) withRequired(services []*service) []*service {
+	var (
+		res []*service
+		add func(s *service)
+	)
+
+	added := map[*service]bool{}
+
+	add = func(s *service) {
+		if added[s] {
+			return
+		}
+
+		added[s] = true
+		res = append(res, s)
+
+		for _, id := range s.Requires {
+			if d := ps.FindServiceByUUID(id); d != nil {
+				add(d)
+			}
+		}
+	}
+
+	for _, s := range services {
+		add(s)
+	}
+
+	return res
+}
+
+// serviceIDs maps service names to IDs.
+func (ps *PluginSettings) serviceIDs(names []string, self *service) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+
+	for _, name := range names {
+		d := ps.FindServiceByName(name)
+		if d == nil {
+			return nil, errors.Errorf("service [%s] not found", name)
+		}
+
+		if d == self {
+			return nil, errors.New("service can't depend on itself")
+		}
+
+		ids = append(ids, d.ID)
+	}
+
+	return ids, nil
+}
+
+func (ps *PluginSettings) serviceNames(ids []uuid.UUID) []string {
+	names := []string{}
+
+	for _, id := range ids {
+		if d := ps.FindServiceByUUID(id); d != nil {
+			names = append(names, d.Name)
+		}
+	}
+
+	return names
+}
+
+// removeDependency removes the deleted service from dependencies of other services.
+func (ps *PluginSettings) removeDependency(id uuid.UUID) {
+	remove := func(ids []uuid.UUID) []uuid.UUID {
+		res := ids[:0]
+		for _, v := range ids {
+			if v != id {
+				res = append(res, v)
+			}
+		}
+
+		return res
+	}
+
+	for _, s := range ps.Services {
+		s.After = remove(s.After)
+		s.Requires = remove(s.Requires)
+	}
+}
+
+// ready returns true when the service is started and its health check passed,
+// running is false when the service isn't started and its restart isn't pending.
+func (s *service) ready() (ready bool, running bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	running = s.process != nil || s.restart.timer != nil
+
+	if s.process == nil || s.stopping {
+		return false, running
+	}
+
+	return !s.useHealthCheck() || s.health.status == healthHealthy, running
+}
+
+// waitReady waits for the dependency, it fails when the dependency isn't started.
+func (p *Plugin) waitReady(d *service) error {
+	deadline := time.Now().Add(dependencyTimeout)
+
+	for {
+		ready, running := d.ready()
+		if ready {
+			return nil
+		}
+
+		if !running {
+			return errors.Errorf("dependency [%s] is not running", d.Name)
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("dependency [%s] is not ready in %s", d.Name, dependencyTimeout)
+		}
+
+		select {
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// startServices starts the services with required services in the dependency order,
+// every service waits for its dependencies which are running or being started.
+func (p *Plugin) startServices(services []*service) error {
+	order, err := p.settings.startOrder(p.settings.withRequired(services))
+	if err != nil {
+		return err
+	}
+
+	starting := map[*service]bool{}
+	for _, s := range order {
+		starting[s] = true
+	}
+
+	go func() {
+		failed := map[*service]bool{}
+
+		for _, s := range order {
+			if s.running() {
+				continue
+			}
+
+			err := p.startAfterDependencies(s, starting, failed)
+			if err != nil {
+				failed[s] = true
+
+				s.output.add(streamSystem, fmt.Sprintf("not started: %v", err))
+			}
+
+			p.api.Reload()
+		}
+	}()
+
+	return nil
+}
+
+func (p *Plugin) startAfterDependencies(s *service, starting map[*service]bool, failed map[*service]bool) error {
+	for _, id := range s.Requires {
+		d := p.settings.FindServiceByUUID(id)
+		if d == nil {
+			continue
+		}
+
+		if failed[d] {
+			return errors.Errorf("dependency [%s] failed", d.Name)
+		}
+
+		if err := p.waitReady(d); err != nil {
+			return err
+		}
+	}
+
+	// ordering only dependencies are waited if they are running or being started
+	for _, id := range s.After {
+		d := p.settings.FindServiceByUUID(id)
+		if d == nil || failed[d] || (!d.running() && !starting[d]) {
+			continue
+		}
+
+		if err := p.waitReady(d); err != nil {
+			s.output.add(streamSystem, fmt.Sprintf("starting without dependency: %v", err))
+		}
+	}
+
+	return p.startService(s)
+}
+
+// stopServices stops the services in the reverse dependency order,
+// a service is stopped after all its dependents are stopped.
+func (p *Plugin) stopServices(services []*service) {
+	done := map[*service]chan struct{}{}
+	for _, s := range services {
+		done[s] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+
+	for _, s := range services {
+		wg.Add(1)
+		go func(s *service) {
+			defer wg.Done()
+			defer close(done[s])
+
+			for _, d := range p.settings.dependents(s) {
+				if ch, ok := done[d]; ok {
+					<-ch
+				}
+			}
+
+			err := s.stop()
+			if err != nil {
+				s.output.add(streamSystem, fmt.Sprintf("stop error: %v", err))
+			}
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+func renderDependencies(ps *PluginSettings, s *service) []Element {
+	serviceID := s.ID.String()
+
+	list := NewElementsList().SetModeLine().
+		AddElementWithTitle(
+			NewLabel("After").SetStrong(true),
+			NewTagsEdit("after", ps.serviceNames(s.After), "update", serviceID),
+		).
+		AddElementWithTitle(
+			NewLabel("Requires").SetStrong(true),
+			NewTagsEdit("requires", ps.serviceNames(s.Requires), "update", serviceID),
+		)
+
+	if names := ps.serviceNames(idsOf(ps.dependents(s))); len(names) > 0 {
+		list.AddElementWithTitle(NewLabel("Dependents").SetStrong(true), NewLabel("%s", strings.Join(names, ", ")))
+	}
+
+	return []Element{
+		NewHeader("Dependencies"),
+		list,
+	}
+}
+
+func idsOf(services []*service) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(services))
+	for _, s := range services {
+		ids = append(ids, s.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	healthNone = ""
+	healthTCP  = "tcp"
+	healthHTTP = "http"
+	healthExec = "exec"
+
+	healthStarting  = "starting"
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+
+	defaultHealthInterval  = 10
+	defaultHealthTimeout   = 3
+	defaultHealthThreshold = 3
+	defaultHealthStatus    = http.StatusOK
+)
+
+var healthTypes = []string{
+	healthTCP,
+	healthHTTP,
+	healthExec,
+}
+
+// healthState holds the runtime state of the health check.
+type healthState struct {
+	status    string
+	failures  int
+	lastCheck time.Time
+	lastError string
+	checking  bool
+}
+
+func validateHealthType(t string) error {
+	if t == healthNone {
+		return nil
+	}
+
+	for _, h := range healthTypes {
+		if h == t {
+			return nil
+		}
+	}
+
+	return errors.New("unknown health check type")
+}
+
+func validateHealthTarget(t string, target string) error {
+	if target == "" {
+		return nil
+	}
+
+	switch t {
+	case healthTCP:
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return errors.New("address must be in the host:port form")
+		}
+	case healthHTTP:
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return errors.New("URL must start with http:// or https://")
+		}
+	}
+
+	return nil
+}
+
+func (s *service) healthSettings() (interval time.Duration, timeout time.Duration, threshold int) {
+	interval, timeout, threshold = defaultHealthInterval*time.Second, defaultHealthTimeout*time.Second, defaultHealthThreshold
+
+	if s.HealthInterval > 0 {
+		interval = time.Duration(s.HealthInterval) * time.Second
+	}
+
+	if s.HealthTimeout > 0 {
+		timeout = time.Duration(s.HealthTimeout) * time.Second
+	}
+
+	if s.HealthThreshold > 0 {
+		threshold = s.HealthThreshold
+	}
+
+	return
+}
+
+func (s *service) healthExpectedStatus() int {
+	if s.HealthStatus > 0 {
+		return s.HealthStatus
+	}
+
+	return defaultHealthStatus
+}
+
+func (s *service) useHealthCheck() bool {
+	return s.HealthType != healthNone && s.HealthTarget != ""
+}
+
+// healthCheck is the settings of the check, they are copied under s.mx because the check runs without it.
+type healthCheck struct {
+	typ       string
+	target    string
+	status    int
+	timeout   time.Duration
+	threshold int
+}
+
+// healthCheck returns the settings of the check, s.mx must be held.
+func (s *service) healthCheck() healthCheck {
+	_, timeout, threshold := s.healthSettings()
+
+	return healthCheck{
+		typ:       s.HealthType,
+		target:    s.HealthTarget,
+		status:    s.healthExpectedStatus(),
+		timeout:   timeout,
+		threshold: threshold,
+	}
+}
+
+// run runs the check once.
+func (c healthCheck) run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	switch c.typ {
+	case healthTCP:
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.target)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+
+	case healthHTTP:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.target, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != c.status {
+			return errors.Errorf("unexpected status %d, expected %d", resp.StatusCode, c.status)
+		}
+
+		return nil
+
+	case healthExec:
+		out, err := exec.CommandContext(ctx, "/bin/sh", "-c", c.target).CombinedOutput()
+		if err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				return errors.Wrap(err, msg)
+			}
+
+			return err
+		}
+
+		return nil
+	}
+
+	return errors.New("unknown health check type")
+}
+
+func (p *Plugin) runHealthCheck(s *service, c healthCheck) {
+	err := c.run()
+
+	if p.updateHealth(s, err, c.threshold) {
+		p.api.Reload()
+	}
+}
+
+// updateHealth applies the result of the check, it returns true when the health status is changed.
+func (p *Plugin) updateHealth(s *service, err error, threshold int) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.health.checking = false
+	s.health.lastCheck = time.Now()
+
+	// the service was stopped or restarted during the check
+	if s.process == nil || s.health.status == "" {
+		return false
+	}
+
+	prev := s.health.status
+
+	if err == nil {
+		s.health.failures = 0
+		s.health.lastError = ""
+		s.health.status = healthHealthy
+	} else {
+		s.health.failures++
+		s.health.lastError = err.Error()
+
+		if s.health.failures >= threshold {
+			s.health.status = healthUnhealthy
+		}
+	}
+
+	if prev == s.health.status {
+		return false
+	}
+
+	s.output.add(streamSystem, fmt.Sprintf("health: %s", s.health.status))
+
+	if s.health.status == healthUnhealthy && s.HealthRestart {
+		p.restartUnhealthy(s)
+	}
+
+	return true
+}
+
+// restartUnhealthy stops the service and starts it again, the restart counter is increased.
+// s.mx must be held.
+func (p *Plugin) restartUnhealthy(s *service) {
+	exited := s.exited
+
+	s.output.add(streamSystem, "restarting unhealthy service")
+
+	go func() {
+		err := s.stop()
+		if err != nil {
+			s.output.add(streamSystem, fmt.Sprintf("stop error: %v", err))
+			return
+		}
+
+		<-exited
+
+		s.mx.Lock()
+
+		count := s.restart.count + 1
+
+		err = p.startServiceLocked(s)
+		if err != nil {
+			s.output.add(streamSystem, fmt.Sprintf("restart error: %v", err))
+		}
+
+		s.restart.count = count
+
+		s.mx.Unlock()
+
+		p.api.Reload()
+	}()
+}
+
+func (p *Plugin) runHealthMonitor() {
+	go func() {
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+
+			for _, s := range p.services() {
+				s.mx.Lock()
+
+				if s.healthCheckDue() {
+					s.health.checking = true
+
+					go p.runHealthCheck(s, s.healthCheck())
+				}
+
+				s.mx.Unlock()
+			}
+		}
+	}()
+}
+
+// healthCheckDue returns true when the interval since the last check is passed, s.mx must be held.
+func (s *service) healthCheckDue() bool {
+	if s.process == nil || s.stopping || !s.useHealthCheck() || s.health.checking {
+		return false
+	}
+
+	interval, _, _ := s.healthSettings()
+
+	last := s.health.lastCheck
+	if last.IsZero() {
+		last = s.startedAt
+	}
+
+	return time.Since(last) >= interval
+}
+
+func healthBadge(s *service) *Badge {
+	switch {
+	case !s.useHealthCheck() || s.process == nil:
+		return nil
+	case s.health.status == healthHealthy:
+		return NewBadge(healthHealthy).SetStyle(StyleSuccess)
+	case s.health.status == healthUnhealthy:
+		return NewBadge(healthUnhealthy).SetStyle(StyleDanger)
+	}
+
+	return NewBadge(healthStarting).SetStyle(StyleSecondary)
+}
+
+// statusBadges returns the status badge with the health badge next to it.
+func statusBadges(s *service) Element {
+	if b := healthBadge(s); b != nil {
+		return NewLine(statusBadge(s), b)
+	}
+
+	return statusBadge(s)
+}
+
+func renderHealth(s *service) []Element {
+	serviceID := s.ID.String()
+
+	interval, timeout, threshold := s.healthSettings()
+
+	typeSelect := NewSelectEdit("health-type", "update", serviceID).
+		AddNamedOption("none", healthNone).
+		SetValue(s.HealthType)
+	for _, t := range healthTypes {
+		typeSelect.AddOption(t)
+	}
+
+	var targetTitle string
+	switch s.HealthType {
+	case healthTCP:
+		targetTitle = "Address"
+	case healthHTTP:
+		targetTitle = "URL"
+	case healthExec:
+		targetTitle = "Command"
+	default:
+		return []Element{
+			NewHeader("Health check"),
+			NewElementsList().SetModeLine().
+				AddElementWithTitle(NewLabel("Type").SetStrong(true), typeSelect),
+		}
+	}
+
+	list := NewElementsList().SetModeLine().
+		AddElementWithTitle(NewLabel("Type").SetStrong(true), typeSelect).
+		AddElementWithTitle(
+			NewLabel(targetTitle).SetStrong(true),
+			NewInputEdit("health-target", s.HealthTarget, "update", serviceID),
+		)
+
+	if s.HealthType == healthHTTP {
+		list.AddElementWithTitle(
+			NewLabel("Expected status").SetStrong(true),
+			NewInputEdit("health-status", fmt.Sprintf("%d", s.healthExpectedStatus()), "update", serviceID),
+		)
+	}
+
+	var lastCheck string
+	if !s.health.lastCheck.IsZero() {
+		lastCheck = s.health.lastCheck.Format(time.RFC1123)
+	}
+
+	list.
+		AddElementWithTitle(
+			NewLabel("Interval (sec)").SetStrong(true),
+			NewInputEdit("health-interval", fmt.Sprintf("%d", interval/time.Second), "update", serviceID),
+		).
+		AddElementWithTitle(
+			NewLabel("Timeout (sec)").SetStrong(true),
+			NewInputEdit("health-timeout", fmt.Sprintf("%d", timeout/time.Second), "update", serviceID),
+		).
+		AddElementWithTitle(
+			NewLabel("Failure threshold").SetStrong(true),
+			NewInputEdit("health-threshold", fmt.Sprintf("%d", threshold), "update", serviceID),
+		).
+		AddElementWithTitle(
+			NewLabel("Restart when unhealthy").SetStrong(true),
+			NewSwitch("health-restart").SetAction("update", serviceID).SetValue(s.HealthRestart),
+		).
+		AddElementWithTitle(NewLabel("Last check").SetStrong(true), NewLabel(lastCheck)).
+		AddElementWithTitle(NewLabel("Failures").SetStrong(true), NewLabel("%d", s.health.failures)).
+		AddElementWithTitle(NewLabel("Last error").SetStrong(true), NewLabel("%s", s.health.lastError))
+
+	return []Element{
+		NewHeader("Health check"),
+		list,
+	}
+}
@@ -0,0 +1,408 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	typeService = "service"
+	typeJob     = "job"
+
+	overlapSkip    = "skip"
+	overlapQueue   = "queue"
+	overlapReplace = "replace"
+
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+
+	runSuccess = "success"
+	runFailed  = "failed"
+	runTimeout = "timeout"
+	runStopped = "stopped"
+
+	jobHistorySize    = 20
+	jobRunOutputLines = 200
+)
+
+var serviceTypes = []string{
+	typeService,
+	typeJob,
+}
+
+var overlapPolicies = []string{
+	overlapSkip,
+	overlapQueue,
+	overlapReplace,
+}
+
+// jobRun is a record of the job run.
+type jobRun struct {
+	// id is increased with every run, so the run is found while the history shifts
+	id        int
+	trigger   string
+	startedAt time.Time
+	duration  time.Duration
+	exitCode  int
+	status    string
+	output    []outputLine
+}
+
+// jobState holds the runtime state of the job.
+type jobState struct {
+	next    time.Time
+	queued  bool
+	timeout *time.Timer
+	// the run is stopped by the timeout
+	timedOut bool
+
+	current *jobRun
+	history []*jobRun
+	lastRun int
+}
+
+func (s *service) isJob() bool {
+	return s.Type == typeJob
+}
+
+func validateServiceType(t string) error {
+	for _, v := range serviceTypes {
+		if v == t {
+			return nil
+		}
+	}
+
+	return errors.New("unknown service type")
+}
+
+func validateOverlapPolicy(policy string) error {
+	for _, v := range overlapPolicies {
+		if v == policy {
+			return nil
+		}
+	}
+
+	return errors.New("unknown overlap policy")
+}
+
+func (s *service) overlapPolicy() string {
+	if s.Overlap == "" {
+		return overlapSkip
+	}
+
+	return s.Overlap
+}
+
+// scheduleNext computes the next run of the job, it is zero when the job has no schedule.
+// s.mx must be held.
+func (s *service) scheduleNext(after time.Time) {
+	s.job.next = time.Time{}
+
+	if s.Schedule == "" {
+		return
+	}
+
+	sc, err := parseSchedule(s.Schedule)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	s.job.next = sc.next(after)
+}
+
+// runJob starts the job, a running job is handled by the overlap policy.
+func (p *Plugin) runJob(s *service, trigger string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.process != nil {
+		switch s.overlapPolicy() {
+		case overlapQueue:
+			s.job.queued = true
+			s.output.add(streamSystem, "run queued: the previous run is still running")
+
+			return nil
+		case overlapReplace:
+			s.output.add(streamSystem, "the previous run is replaced")
+
+			exited := s.exited
+
+			go func() {
+				err := s.stop()
+				if err != nil {
+					s.output.add(streamSystem, fmt.Sprintf("stop error: %v", err))
+					return
+				}
+
+				<-exited
+
+				err = p.runJob(s, trigger)
+				if err != nil {
+					fmt.Println(err)
+				}
+			}()
+
+			return nil
+		}
+
+		s.output.add(streamSystem, "run skipped: the previous run is still running")
+
+		return errors.New("job is already running")
+	}
+
+	s.job.lastRun++
+
+	run := &jobRun{
+		id:        s.job.lastRun,
+		trigger:   trigger,
+		startedAt: time.Now(),
+	}
+
+	// the restart state is used only to stop the run by the user
+	s.restart = restartState{}
+	s.job.timedOut = false
+
+	err := s.start(func() {
+		p.onJobExit(s)
+	})
+	if err != nil {
+		run.status = runFailed
+		run.exitCode = -1
+		run.output = s.output.since(run.startedAt, jobRunOutputLines)
+		s.addJobRun(run)
+
+		return err
+	}
+
+	s.job.current = run
+
+	if s.Timeout > 0 {
+		timeout := s.Timeout
+
+		s.job.timeout = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			s.mx.Lock()
+
+			// the run is finished while the timer waits for the lock
+			if s.job.current != run {
+				s.mx.Unlock()
+				return
+			}
+
+			s.job.timedOut = true
+			s.output.add(streamSystem, fmt.Sprintf("run timeout %ds is reached", timeout))
+
+			s.mx.Unlock()
+
+			err := s.stop()
+			if err != nil {
+				fmt.Println(err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// addJobRun adds the finished run to the history, s.mx must be held.
+func (s *service) addJobRun(run *jobRun) {
+	s.job.history = append([]*jobRun{run}, s.job.history...)
+	if len(s.job.history) > jobHistorySize {
+		s.job.history = s.job.history[:jobHistorySize]
+	}
+}
+
+// findJobRun returns the run from the history by its id, s.mx must be held.
+func (s *service) findJobRun(id int) *jobRun {
+	for _, run := range s.job.history {
+		if run.id == id {
+			return run
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) onJobExit(s *service) {
+	defer p.api.Reload()
+
+	s.mx.Lock()
+
+	if t := s.job.timeout; t != nil {
+		t.Stop()
+		s.job.timeout = nil
+	}
+
+	if run := s.job.current; run != nil {
+		s.job.current = nil
+
+		run.duration = time.Since(run.startedAt).Round(time.Millisecond)
+		run.output = s.output.since(run.startedAt, jobRunOutputLines)
+		run.exitCode = -1
+
+		if ps := s.processState; ps != nil {
+			run.exitCode = ps.ExitCode()
+		}
+
+		switch {
+		case s.job.timedOut:
+			run.status = runTimeout
+		case s.processState != nil && s.processState.Success():
+			run.status = runSuccess
+		case s.restart.stopped:
+			run.status = runStopped
+		default:
+			run.status = runFailed
+		}
+
+		s.addJobRun(run)
+	}
+
+	queued := s.job.queued
+	s.job.queued = false
+
+	s.mx.Unlock()
+
+	select {
+	case <-p.ctx.Done():
+		return
+	default:
+	}
+
+	if queued {
+		err := p.runJob(s, triggerSchedule)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func (p *Plugin) runJobScheduler() {
+	go func() {
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+
+			now := time.Now()
+
+			for _, s := range p.services() {
+				if !s.takeScheduledRun(now) {
+					continue
+				}
+
+				err := p.runJob(s, triggerSchedule)
+				if err != nil {
+					fmt.Println(err)
+				}
+
+				p.api.Reload()
+			}
+		}
+	}()
+}
+
+// takeScheduledRun returns true when the scheduled run of the job is due, the next run is scheduled.
+func (s *service) takeScheduledRun(now time.Time) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if !s.isJob() || s.job.next.IsZero() || now.Before(s.job.next) {
+		return false
+	}
+
+	s.scheduleNext(now)
+
+	return true
+}
+
+func runStatusBadge(status string) *Badge {
+	switch status {
+	case runSuccess:
+		return NewBadge(status).SetStyle(StyleSuccess)
+	case runStopped:
+		return NewBadge(status).SetStyle(StyleSecondary)
+	case runTimeout:
+		return NewBadge(status).SetStyle(StyleWarning)
+	}
+
+	return NewBadge(status).SetStyle(StyleDanger)
+}
+
+func renderJob(s *service) []Element {
+	serviceID := s.ID.String()
+
+	overlapSelect := NewSelectEdit("overlap", "update", serviceID).SetValue(s.overlapPolicy())
+	for _, o := range overlapPolicies {
+		overlapSelect.AddOption(o)
+	}
+
+	var next string
+	if !s.job.next.IsZero() {
+		next = s.job.next.Format(time.RFC1123)
+	}
+
+	timeout := ""
+	if s.Timeout > 0 {
+		timeout = fmt.Sprintf("%d", s.Timeout)
+	}
+
+	history := NewTable("Started", "Trigger", "Duration", "Exit code", "Status", "")
+
+	if run := s.job.current; run != nil {
+		history.AddLine(
+			NewLabel(run.startedAt.Format(time.RFC1123)),
+			NewLabel(run.trigger),
+			NewLabel("%s", time.Since(run.startedAt).Round(time.Second)),
+			NewLabel(""),
+			NewBadge("running").SetStyle(StylePrimary),
+			NewLabel(""),
+		)
+	}
+
+	for _, run := range s.job.history {
+		history.AddLine(
+			NewLabel(run.startedAt.Format(time.RFC1123)),
+			NewLabel(run.trigger),
+			NewLabel("%s", run.duration),
+			NewLabel("%d", run.exitCode),
+			runStatusBadge(run.status),
+			NewButton("Output", "job-output", serviceID, strconv.Itoa(run.id)).SetStyle(StyleSecondary),
+		)
+	}
+
+	return []Element{
+		NewHeader("Job"),
+		NewElementsList().SetModeLine().
+			AddElementWithTitle(
+				NewLabel("Schedule").SetStrong(true),
+				NewInputEdit("schedule", s.Schedule, "update", serviceID),
+			).
+			AddElementWithTitle(
+				NewLabel("Timeout (sec)").SetStrong(true),
+				NewInputEdit("timeout", timeout, "update", serviceID),
+			).
+			AddElementWithTitle(NewLabel("Overlap").SetStrong(true), overlapSelect).
+			AddElementWithTitle(NewLabel("Next run").SetStrong(true), NewLabel(next)),
+		NewText("Schedule is a cron expression like \"*/5 * * * *\", an alias like @daily or an interval like \"@every 10m\"."),
+		NewHeader("Runs"),
+		history,
+	}
+}
+
+func renderJobOutput(run *jobRun) Element {
+	logView := NewLogView("job-output", jobRunOutputLines)
+
+	for _, l := range run.output {
+		logView.AddLines(l.logLine())
+	}
+
+	return logView
+}
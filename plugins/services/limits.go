@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	// the cgroup of the qubert unit is delegated to it by Delegate=yes, qubert runs in the supervisor
+	// group of it and services with limits in the services group
+	cgroupSupervisor = "supervisor"
+	cgroupServices   = "services"
+	// services started by older versions are in this group at the root of the hierarchy
+	cgroupLegacyParent = "qubert"
+	cpuPeriod          = 100000
+
+	rlimitUnlimited = "unlimited"
+
+	// not defined in the syscall package
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+type rlimitDef struct {
+	name     string
+	title    string
+	resource int
+}
+
+var rlimits = []rlimitDef{
+	{"nofile", "Open files", syscall.RLIMIT_NOFILE},
+	{"nproc", "Processes", rlimitNproc},
+	{"core", "Core file size", syscall.RLIMIT_CORE},
+	{"stack", "Stack size", syscall.RLIMIT_STACK},
+	{"as", "Address space", syscall.RLIMIT_AS},
+	{"memlock", "Locked memory", rlimitMemlock},
+}
+
+func rlimitByName(name string) *rlimitDef {
+	for i := range rlimits {
+		if rlimits[i].name == name {
+			return &rlimits[i]
+		}
+	}
+
+	return nil
+}
+
+func parseRlimit(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+
+	if value == rlimitUnlimited {
+		return math.MaxUint64, nil
+	}
+
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("incorrect limit value")
+	}
+
+	return v, nil
+}
+
+func formatRlimit(v uint64) string {
+	if v == math.MaxUint64 {
+		return rlimitUnlimited
+	}
+
+	return fmt.Sprintf("%d", v)
+}
+
+// parseMemory parses memory size with an optional K, M, G or T suffix, the empty value means no limit.
+func parseMemory(value string) (uint64, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+
+	v, err := ParseSize(value)
+	if err != nil {
+		return 0, errors.Wrap(err, "incorrect memory size")
+	}
+
+	if v == 0 {
+		return 0, errors.New("incorrect memory size")
+	}
+
+	return v, nil
+}
+
+func cgroupAvailable() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+
+	return err == nil
+}
+
+// delegatedCgroup is set up once, see servicesCgroup.
+var delegatedCgroup struct {
+	once sync.Once
+	dir  string
+	err  error
+}
+
+// servicesCgroup returns the parent cgroup of services. The first call moves qubert to the supervisor
+// group, so it must be done before services are started, otherwise they stay in the delegated cgroup
+// and controllers can't be enabled for children of a cgroup with processes.
+func servicesCgroup() (string, error) {
+	delegatedCgroup.once.Do(func() {
+		delegatedCgroup.dir, delegatedCgroup.err = setupDelegatedCgroup()
+	})
+
+	return delegatedCgroup.dir, delegatedCgroup.err
+}
+
+func setupDelegatedCgroup() (string, error) {
+	if !cgroupAvailable() {
+		return "", errors.New("cgroup v2 is not available")
+	}
+
+	path := processCgroupPath(os.Getpid())
+
+	// systemd 254 and newer moves the process to the subgroup by DelegateSubgroup
+	if filepath.Base(path) == cgroupSupervisor {
+		path = filepath.Dir(path)
+	}
+
+	if !strings.HasSuffix(path, ".service") {
+		return "", errors.New("qubert must be run as a systemd service with Delegate=yes to use cgroup limits")
+	}
+
+	dir := filepath.Join(cgroupRoot, path)
+
+	supervisor := filepath.Join(dir, cgroupSupervisor)
+
+	err := os.MkdirAll(supervisor, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(supervisor, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to move qubert to the supervisor cgroup")
+	}
+
+	services := filepath.Join(dir, cgroupServices)
+
+	err = os.MkdirAll(services, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = enableControllers(dir, "cpu", "memory")
+	if err != nil {
+		return "", err
+	}
+
+	err = enableControllers(services, "cpu", "memory")
+	if err != nil {
+		return "", err
+	}
+
+	return services, nil
+}
+
+func (s *service) cgroupPath() string {
+	dir, err := servicesCgroup()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, s.ID.String())
+}
+
+// processCgroupPath returns the cgroup v2 path of the process relative to the root of the hierarchy.
+func processCgroupPath(pid int) string {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if path := strings.TrimPrefix(line, "0::"); path != line {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// processCgroup returns the cgroup directory of the process when it is created by qubert,
+// processes started by older versions are in cgroups at the root of the hierarchy.
+func processCgroup(pid int) string {
+	path := processCgroupPath(pid)
+	if path == "" {
+		return ""
+	}
+
+	dir := filepath.Join(cgroupRoot, path)
+
+	if strings.HasPrefix(path, "/"+cgroupLegacyParent+"/") {
+		return dir
+	}
+
+	if services, err := servicesCgroup(); err == nil && filepath.Dir(dir) == services {
+		return dir
+	}
+
+	return ""
+}
+
+func (s *service) useCgroup() bool {
+	return s.CPUQuota > 0 || s.MemoryMax != ""
+}
+
+func enableControllers(dir string, controllers ...string) error {
+	for _, c := range controllers {
+		err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to enable %s controller", c)
+		}
+	}
+
+	return nil
+}
+
+// setupCgroup creates the cgroup of the service with the limits and returns the opened cgroup directory.
+func (s *service) setupCgroup() (*os.File, error) {
+	if !s.useCgroup() {
+		return nil, nil
+	}
+
+	parent, err := servicesCgroup()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(parent, s.ID.String())
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cgroup = dir
+
+	cpuMax := "max"
+	if s.CPUQuota > 0 {
+		cpuMax = fmt.Sprintf("%d", s.CPUQuota*cpuPeriod/100)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%s %d", cpuMax, cpuPeriod)), 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set CPU quota")
+	}
+
+	memoryMax := "max"
+	if s.MemoryMax != "" {
+		v, err := parseMemory(s.MemoryMax)
+		if err != nil {
+			return nil, err
+		}
+
+		memoryMax = fmt.Sprintf("%d", v)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(memoryMax), 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set memory limit")
+	}
+
+	return os.Open(dir)
+}
+
+// removeCgroup removes the cgroup of the service, it fails while the cgroup has processes.
+func (s *service) removeCgroup() {
+	if s.cgroup != "" {
+		_ = os.Remove(s.cgroup)
+		s.cgroup = ""
+	}
+}
+
+// killCgroup kills all processes in the cgroup, children which left the process group are killed too.
+// cgroup.kill is used when the kernel supports it, otherwise processes are killed one by one.
+func killCgroup(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	if ioutil.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644) == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, f := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(f)
+		if err != nil {
+			continue
+		}
+
+		err = syscall.Kill(pid, syscall.SIGKILL)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	outputBufferSize = 1000
+	outputMaxLine    = 64 * 1024
+
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+	streamSystem = "system"
+
+	defaultLogMaxSize  = 10
+	defaultLogMaxFiles = 5
+)
+
+type outputLine struct {
+	at     time.Time
+	stream string
+	text   string
+}
+
+func (l outputLine) String() string {
+	return fmt.Sprintf("%s [%s] %s", l.at.Format(time.RFC3339), l.stream, l.text)
+}
+
+func (l outputLine) logLine() *LogLine {
+	line := &LogLine{
+		Time:   l.at.Format("2006-01-02 15:04:05"),
+		Stream: l.stream,
+		Text:   l.text,
+	}
+
+	switch l.stream {
+	case streamStderr:
+		line.Style = StyleDanger
+	case streamSystem:
+		line.Style = StylePrimary
+	}
+
+	return line
+}
+
+// rotatingFile is a log file which is renamed to file.1, file.2 ... when it reaches the max size.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSizeMB int, maxFiles int) (*rotatingFile, error) {
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultLogMaxSize
+	}
+
+	if maxFiles <= 0 {
+		maxFiles = defaultLogMaxFiles
+	}
+
+	f := &rotatingFile{
+		path:     path,
+		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+		maxFiles: maxFiles,
+	}
+
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+
+	for i := f.maxFiles; i > 0; i-- {
+		oldPath := fmt.Sprintf("%s.%d", f.path, i-1)
+		if i == 1 {
+			oldPath = f.path
+		}
+
+		err = os.Rename(oldPath, fmt.Sprintf("%s.%d", f.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return f.open()
+}
+
+func (f *rotatingFile) writeLine(line string) error {
+	if f.size > 0 && f.size+int64(len(line))+1 > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := fmt.Fprintln(f.file, line)
+	f.size += int64(n)
+
+	return err
+}
+
+func (f *rotatingFile) close() error {
+	return f.file.Close()
+}
+
+// outputBuffer keeps the last lines of the service output in a ring buffer
+// and writes all lines to the log file if it is configured.
+type outputBuffer struct {
+	mx sync.Mutex
+
+	lines []outputLine
+	next  int
+	full  bool
+
+	// lines which are not sent to clients yet
+	pending []outputLine
+
+	file *rotatingFile
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{
+		lines: make([]outputLine, outputBufferSize),
+	}
+}
+
+func (b *outputBuffer) setLogFile(path string, maxSizeMB int, maxFiles int) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.file != nil {
+		_ = b.file.close()
+		b.file = nil
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	file, err := openRotatingFile(path, maxSizeMB, maxFiles)
+	if err != nil {
+		return err
+	}
+
+	b.file = file
+
+	return nil
+}
+
+func (b *outputBuffer) add(stream string, text string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	line := outputLine{
+		at:     time.Now(),
+		stream: stream,
+		text:   text,
+	}
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	b.full = b.full || b.next == 0
+
+	if len(b.pending) < outputBufferSize {
+		b.pending = append(b.pending, line)
+	}
+
+	if b.file != nil {
+		err := b.file.writeLine(line.String())
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// all returns buffered lines from the oldest to the newest.
+func (b *outputBuffer) all() []outputLine {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if !b.full {
+		return append([]outputLine{}, b.lines[:b.next]...)
+	}
+
+	return append(append([]outputLine{}, b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// since returns the last lines added after the time, no more than the limit.
+func (b *outputBuffer) since(t time.Time, limit int) []outputLine {
+	var lines []outputLine
+
+	for _, l := range b.all() {
+		if !l.at.Before(t) {
+			lines = append(lines, l)
+		}
+	}
+
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+
+	return lines
+}
+
+// takePending returns lines which were added since the last call.
+func (b *outputBuffer) takePending() []outputLine {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	lines := b.pending
+	b.pending = nil
+
+	return lines
+}
+
+func (b *outputBuffer) clear() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.next = 0
+	b.full = false
+	b.pending = nil
+}
+
+// capture reads lines from the reader until EOF.
+func (b *outputBuffer) capture(stream string, r io.ReadCloser) {
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), outputMaxLine)
+
+	for scanner.Scan() {
+		b.add(stream, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	// the reader is closed when the output is kept open after the exit of the process
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		b.add(streamSystem, fmt.Sprintf("output reading error: %v", err))
+	}
+}
+
+func (p *Plugin) runOutputMonitor() {
+	go func() {
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			for _, s := range p.services() {
+				pending := s.output.takePending()
+				if len(pending) == 0 {
+					continue
+				}
+
+				lines := make([]*LogLine, 0, len(pending))
+				for _, l := range pending {
+					lines = append(lines, l.logLine())
+				}
+
+				p.api.SendUpdate(NewUpdateLogView("service-output", lines...), s.ID.String())
+			}
+		}
+	}()
+}
+
+func renderOutput(s *service) []Element {
+	logView := NewLogView("service-output", outputBufferSize)
+
+	for _, l := range s.output.all() {
+		logView.AddLines(l.logLine())
+	}
+
+	return []Element{
+		NewHeader("Service output"),
+		NewLine(
+			NewButton("Download", "download-output", s.ID.String()).SetImage("download").SetStyle(StyleSecondary),
+			NewButton("Clear", "clear-output", s.ID.String()).SetStyle(StyleSecondary),
+		),
+		logView,
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+
+	defaultRestartDelay    = 1
+	defaultRestartBurst    = 5
+	defaultRestartInterval = 60
+
+	maxRestartDelay = 5 * time.Minute
+)
+
+var restartPolicies = []string{
+	restartNever,
+	restartOnFailure,
+	restartAlways,
+}
+
+// restartState holds the runtime state of the restart policy.
+type restartState struct {
+	// restarts in the current interval, used for the backoff and the burst limit
+	restarts []time.Time
+
+	count   int
+	failed  bool
+	stopped bool
+
+	timer *time.Timer
+	next  time.Time
+}
+
+func validateRestartPolicy(policy string) error {
+	for _, r := range restartPolicies {
+		if r == policy {
+			return nil
+		}
+	}
+
+	return errors.New("unknown restart policy")
+}
+
+func (s *service) restartPolicy() string {
+	if s.Restart == "" {
+		return restartNever
+	}
+
+	return s.Restart
+}
+
+func (s *service) restartSettings() (delay time.Duration, burst int, interval time.Duration) {
+	delay, burst, interval = defaultRestartDelay*time.Second, defaultRestartBurst, defaultRestartInterval*time.Second
+
+	if s.RestartDelay > 0 {
+		delay = time.Duration(s.RestartDelay) * time.Second
+	}
+
+	if s.RestartBurst > 0 {
+		burst = s.RestartBurst
+	}
+
+	if s.RestartInterval > 0 {
+		interval = time.Duration(s.RestartInterval) * time.Second
+	}
+
+	return
+}
+
+// needRestart checks the policy against the last exit of the process, s.mx must be held.
+func (s *service) needRestart() bool {
+	if s.restart.stopped {
+		return false
+	}
+
+	switch s.restartPolicy() {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return s.processState == nil || !s.processState.Success()
+	}
+
+	return false
+}
+
+func (s *service) cancelRestart() {
+	if t := s.restart.timer; t != nil {
+		t.Stop()
+	}
+
+	s.restart.timer = nil
+	s.restart.next = time.Time{}
+}
+
+// isStopSignal returns true for signals which are sent by the user to stop the service,
+// the service is not restarted after them.
+func isStopSignal(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGQUIT:
+		return true
+	}
+
+	return false
+}
+
+// startService starts the service by the user request, the restart state is reset.
+func (p *Plugin) startService(s *service) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return p.startServiceLocked(s)
+}
+
+// startServiceLocked is startService for callers which hold s.mx.
+func (p *Plugin) startServiceLocked(s *service) error {
+	if s.process != nil {
+		return errors.New("service already started")
+	}
+
+	s.cancelRestart()
+	s.restart = restartState{count: s.restart.count}
+
+	return s.start(func() {
+		p.onServiceExit(s)
+	})
+}
+
+func (p *Plugin) onServiceExit(s *service) {
+	defer p.api.Reload()
+
+	select {
+	case <-p.ctx.Done():
+		return
+	default:
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	p.scheduleRestart(s)
+}
+
+// scheduleRestart starts the timer of the restart when the policy requires it, s.mx must be held.
+func (p *Plugin) scheduleRestart(s *service) {
+	if !s.needRestart() {
+		return
+	}
+
+	delay, burst, interval := s.restartSettings()
+
+	now := time.Now()
+
+	restarts := s.restart.restarts[:0]
+	for _, t := range s.restart.restarts {
+		if now.Sub(t) < interval {
+			restarts = append(restarts, t)
+		}
+	}
+
+	s.restart.restarts = restarts
+
+	if len(restarts) >= burst {
+		s.restart.failed = true
+		s.output.add(streamSystem, fmt.Sprintf("start limit hit: %d restarts in %s", len(restarts), interval))
+
+		return
+	}
+
+	// exponential backoff by the count of the restarts in the interval
+	for i := 0; i < len(restarts) && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+
+	s.output.add(streamSystem, fmt.Sprintf("restarting in %s", delay))
+
+	s.restart.next = now.Add(delay)
+	var timer *time.Timer
+
+	timer = time.AfterFunc(delay, func() {
+		defer p.api.Reload()
+
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		// the restart is canceled while the timer waits for the lock
+		if s.restart.timer != timer {
+			return
+		}
+
+		s.restart.timer = nil
+		s.restart.next = time.Time{}
+
+		if s.restart.stopped || s.process != nil {
+			return
+		}
+
+		s.restart.restarts = append(s.restart.restarts, time.Now())
+		s.restart.count++
+
+		err := s.start(func() {
+			p.onServiceExit(s)
+		})
+		if err != nil {
+			// start errors are handled as a failed run
+			s.processState = nil
+			p.scheduleRestart(s)
+		}
+	})
+
+	s.restart.timer = timer
+}
+
+func exitStatus(s *service) string {
+	if s.processState == nil {
+		return ""
+	}
+
+	if ws, ok := s.processState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return fmt.Sprintf("signal %s", ws.Signal())
+	}
+
+	return fmt.Sprintf("code %d", s.processState.ExitCode())
+}
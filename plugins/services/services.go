@@ -7,10 +7,12 @@ import (
 	"github.com/pkg/errors"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
-	"unsafe"
 
 	. "qubert/pluginTools"
 
@@ -18,75 +20,221 @@ import (
 )
 
 type service struct {
-	uuid uuid.UUID
+	ID uuid.UUID `json:"id"`
 
 	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"`
 	CMD         string   `json:"cmd"`
 	Args        []string `json:"args"`
 	Dir         string   `json:"dir"`
 	Env         []string `json:"env"`
 	Description string   `json:"description,omitempty"`
 	Autostart   bool     `json:"autostart,omitempty"`
+	KeepRunning bool     `json:"keep-running,omitempty"`
+
+	After    []uuid.UUID `json:"after,omitempty"`
+	Requires []uuid.UUID `json:"requires,omitempty"`
+
+	Restart         string `json:"restart,omitempty"`
+	RestartDelay    int    `json:"restart-delay,omitempty"`
+	RestartBurst    int    `json:"restart-burst,omitempty"`
+	RestartInterval int    `json:"restart-interval,omitempty"`
+
+	Schedule string `json:"schedule,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+	Overlap  string `json:"overlap,omitempty"`
+
+	StopSignal  string `json:"stop-signal,omitempty"`
+	StopTimeout int    `json:"stop-timeout,omitempty"`
+
+	HealthType      string `json:"health-type,omitempty"`
+	HealthTarget    string `json:"health-target,omitempty"`
+	HealthStatus    int    `json:"health-status,omitempty"`
+	HealthInterval  int    `json:"health-interval,omitempty"`
+	HealthTimeout   int    `json:"health-timeout,omitempty"`
+	HealthThreshold int    `json:"health-threshold,omitempty"`
+	HealthRestart   bool   `json:"health-restart,omitempty"`
+
+	User    string            `json:"user,omitempty"`
+	Group   string            `json:"group,omitempty"`
+	Groups  []string          `json:"groups,omitempty"`
+	Umask   string            `json:"umask,omitempty"`
+	Rlimits map[string]uint64 `json:"rlimits,omitempty"`
+
+	CPUQuota  int    `json:"cpu-quota,omitempty"`
+	MemoryMax string `json:"memory-max,omitempty"`
+
+	LogFile     string `json:"log-file,omitempty"`
+	LogMaxSize  int    `json:"log-max-size,omitempty"`
+	LogMaxFiles int    `json:"log-max-files,omitempty"`
+
+	output *outputBuffer
+
+	// mx protects the runtime state below, it isn't held while waiting for the process
+	mx sync.Mutex
 
 	process      *os.Process
 	processState *os.ProcessState
+	exited       chan struct{}
+	stopping     bool
+
+	restart restartState
+	health  healthState
+	usage   resourceUsage
+	job     jobState
+	cgroup  string
 
 	startedAt time.Time
 }
 
+// start starts the process of the service, s.mx must be held.
 func (s *service) start(cb func()) error {
+	err := s.output.setLogFile(s.LogFile, s.LogMaxSize, s.LogMaxFiles)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+
+	cred, u, err := s.credential()
+	if err != nil {
+		return err
+	}
+
+	umask, err := parseUmask(s.Umask)
+	if err != nil {
+		return err
+	}
+
+	name, env := s.CMD, s.environ(u)
+
+	if s.useWrapper(umask) {
+		env, err = s.wrapperEnviron(env, umask, cred)
+		if err != nil {
+			return err
+		}
+
+		// the wrapper is started as root and sets the credential itself
+		name, cred = wrapperPath, nil
+	}
+
+	cgroup, err := s.setupCgroup()
+	if err != nil {
+		return err
+	}
+
+	// the service runs in its own process group, so it can be stopped with all children
+	sys := &syscall.SysProcAttr{
+		Credential: cred,
+		Setpgid:    true,
+	}
+
+	if cgroup != nil {
+		defer cgroup.Close()
+
+		sys.UseCgroupFD = true
+		sys.CgroupFD = int(cgroup.Fd())
+	}
+
+	readers, writers, err := s.createOutput()
+	if err != nil {
+		s.removeCgroup()
+
+		return err
+	}
+
+	// write ends are used by the child only
+	defer writers[0].Close()
+	defer writers[1].Close()
+
 	attr := &os.ProcAttr{
 		Dir: s.Dir,
-		Env: os.Environ(),
+		Env: env,
 		Files: []*os.File{
 			os.Stdin,
-			nil,
-			nil,
-		},
-		Sys: &syscall.SysProcAttr{
-			//Noctty: true,
-			//Credential: &syscall.Credential{
-			//	Uid: uint32(os.Getuid()),
-			//	Gid: uint32(os.Getuid()),
-			//},
+			writers[0],
+			writers[1],
 		},
+		Sys: sys,
 	}
 
-	var err error
-
 	s.process, err = os.StartProcess(
-		s.CMD,
+		name,
 		append(
 			[]string{s.CMD},
 			s.Args...,
 		), attr)
 
 	if err != nil {
+		_ = readers[0].Close()
+		_ = readers[1].Close()
+
+		s.removeCgroup()
+		s.removeState()
+
+		s.output.add(streamSystem, fmt.Sprintf("failed to start: %v", err))
+
 		return err
 	}
 
 	s.startedAt = time.Now()
+	s.exited = make(chan struct{})
+	s.health = healthState{status: healthStarting}
+
+	s.output.add(streamSystem, fmt.Sprintf("started with pid %d", s.process.Pid))
+
+	err = s.saveState(s.process.Pid)
+	if err != nil {
+		s.output.add(streamSystem, fmt.Sprintf("failed to save state: %v", err))
+	}
+
+	output := s.captureOutput(readers[0], readers[1])
+
+	pr := s.process
 
 	go func() {
-		s.processState, err = s.process.Wait()
-		s.process = nil
+		state, err := pr.Wait()
 
-		cb()
+		s.finish(state, err, output, cb)
 	}()
 
 	return nil
 }
 
 func (s *service) sendSignal(sig syscall.Signal) error {
-	if p := s.process; p != nil {
-		return p.Signal(sig)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if isStopSignal(sig) {
+		pending := s.restart.timer != nil
+
+		s.restart.stopped = true
+		s.cancelRestart()
+
+		if s.process == nil && pending {
+			return nil
+		}
+	}
+
+	p := s.process
+	if p == nil {
+		return errNotStarted
+	}
+
+	// the kill is sent to the whole service, children may be in other process groups but not in other cgroups
+	if sig == syscall.SIGKILL {
+		err := signalGroup(p.Pid, sig)
+		if err != nil {
+			return err
+		}
+
+		return killCgroup(s.cgroup)
 	}
 
-	return errors.New("service not started")
+	return p.Signal(sig)
 }
 
 type PluginSettings struct {
 	Services []*service `json:"services"`
+	SortBy   string     `json:"sort-by,omitempty"`
 }
 
 func (ps *PluginSettings) FindServiceByName(name string) *service {
@@ -101,7 +249,7 @@ func (ps *PluginSettings) FindServiceByName(name string) *service {
 
 func (ps *PluginSettings) FindServiceByUUID(uuid uuid.UUID) *service {
 	for _, s := range ps.Services {
-		if s.uuid == uuid {
+		if s.ID == uuid {
 			return s
 		}
 	}
@@ -113,6 +261,28 @@ type Plugin struct {
 	api      PluginAPI
 	ctx      context.Context
 	settings PluginSettings
+
+	// mx protects the list of services, which is read by monitors
+	mx sync.Mutex
+
+	// Units is used to convert services into systemd units
+	Units UnitManager
+}
+
+// services returns a copy of the list of services for monitors.
+func (p *Plugin) services() []*service {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	return append([]*service{}, p.settings.Services...)
+}
+
+func (p *Plugin) addService(s *service) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	s.output = newOutputBuffer()
+	p.settings.Services = append(p.settings.Services, s)
 }
 
 func (p *Plugin) ID() string {
@@ -136,31 +306,124 @@ func (p *Plugin) Run(ctx context.Context, api PluginAPI) error {
 		return err
 	}
 
+	save := false
+
 	for _, s := range p.settings.Services {
-		s.uuid = uuid.New()
+		if s.ID == "" {
+			s.ID = uuid.New()
+			save = true
+		}
+
+		s.output = newOutputBuffer()
+	}
 
-		if s.Autostart {
-			_ = s.start(func() {
-				p.api.Reload()
-			}) // TODO error handling
+	// qubert leaves the cgroup of its unit before services are started, see servicesCgroup
+	if _, err := servicesCgroup(); err != nil {
+		for _, s := range p.services() {
+			if s.useCgroup() {
+				fmt.Println(err)
+				break
+			}
 		}
 	}
 
+	p.runOutputMonitor()
+	p.runHealthMonitor()
+	p.runUsageMonitor()
+	p.runJobScheduler()
+
+	var autostart []*service
+
+	for _, s := range p.services() {
+		s := s
+
+		// the service may be left running by the previous run of qubert
+		ok, err := s.reattach(func() {
+			if s.isJob() {
+				p.onJobExit(s)
+			} else {
+				p.onServiceExit(s)
+			}
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		if s.isJob() {
+			s.mx.Lock()
+			s.scheduleNext(time.Now())
+			s.mx.Unlock()
+
+			continue
+		}
+
+		if !ok && s.Autostart {
+			autostart = append(autostart, s)
+		}
+	}
+
+	err = p.startServices(autostart)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	if save {
+		err = p.api.SaveModuleConfig(&p.settings)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	// managed services are not left behind when qubert stops,
+	// Run is waited by the application, so it returns after they are stopped
+	<-p.ctx.Done()
+	p.stopAll()
+
 	return nil
 }
 
 type serviceCreateDef struct {
 	Name    string `json:"name"`
 	Command string `json:"cmd"`
+	Type    string `json:"type"`
 }
 
 type serviceUpdateDef struct {
-	Name        *string   `json:"name"`
-	Command     *string   `json:"cmd"`
-	Args        *[]string `json:"args"`
-	Dir         *string   `json:"dir"`
-	Description *string   `json:"description"`
-	Autostart   *bool     `json:"autostart"`
+	Name            *string   `json:"name"`
+	Command         *string   `json:"cmd"`
+	Args            *[]string `json:"args"`
+	Dir             *string   `json:"dir"`
+	Description     *string   `json:"description"`
+	Autostart       *bool     `json:"autostart"`
+	KeepRunning     *bool     `json:"keep-running"`
+	Schedule        *string   `json:"schedule"`
+	Timeout         *string   `json:"timeout"`
+	Overlap         *string   `json:"overlap"`
+	After           *[]string `json:"after"`
+	Requires        *[]string `json:"requires"`
+	Restart         *string   `json:"restart"`
+	RestartDelay    *string   `json:"restart-delay"`
+	RestartBurst    *string   `json:"restart-burst"`
+	RestartInterval *string   `json:"restart-interval"`
+	StopSignal      *string   `json:"stop-signal"`
+	StopTimeout     *string   `json:"stop-timeout"`
+	HealthType      *string   `json:"health-type"`
+	HealthTarget    *string   `json:"health-target"`
+	HealthStatus    *string   `json:"health-status"`
+	HealthInterval  *string   `json:"health-interval"`
+	HealthTimeout   *string   `json:"health-timeout"`
+	HealthThreshold *string   `json:"health-threshold"`
+	HealthRestart   *bool     `json:"health-restart"`
+	User            *string   `json:"user"`
+	Group           *string   `json:"group"`
+	Groups          *[]string `json:"groups"`
+	Umask           *string   `json:"umask"`
+	Env             *string   `json:"env"`
+	CPUQuota        *string   `json:"cpu-quota"`
+	MemoryMax       *string   `json:"memory-max"`
+	LogFile         *string   `json:"log-file"`
+	LogMaxSize      *string   `json:"log-max-size"`
+	LogMaxFiles     *string   `json:"log-max-files"`
 }
 
 type signalReqData struct {
@@ -174,6 +437,11 @@ func (p *Plugin) Actions() ActionsMap {
 
 			nameInput := NewInput("name")
 			cmdInput := NewInput("cmd")
+			typeSelect := NewSelect("type")
+
+			for _, t := range serviceTypes {
+				typeSelect.AddOption(t)
+			}
 
 			if action == "save" {
 				var sd serviceCreateDef
@@ -185,6 +453,7 @@ func (p *Plugin) Actions() ActionsMap {
 
 				nameInput.SetValue(sd.Name)
 				cmdInput.SetValue(sd.Command)
+				typeSelect.SetValue(sd.Type)
 
 				isValid := true
 
@@ -198,10 +467,19 @@ func (p *Plugin) Actions() ActionsMap {
 					cmdInput.SetErrorText(err.Error())
 				}
 
+				if sd.Type == "" {
+					sd.Type = typeService
+				}
+
+				if err = validateServiceType(sd.Type); err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
 				if isValid {
-					p.settings.Services = append(p.settings.Services, &service{
-						uuid: uuid.New(),
+					p.addService(&service{
+						ID:   uuid.New(),
 						Name: sd.Name,
+						Type: sd.Type,
 						Args: []string{},
 						CMD:  sd.Command,
 						Env:  []string{},
@@ -221,6 +499,7 @@ func (p *Plugin) Actions() ActionsMap {
 				NewForm().
 					AddWithTitle("Name", nameInput).
 					AddWithTitle("Command", cmdInput).
+					AddWithTitle("Type", typeSelect).
 					AddActionButtons(
 						NewButton("Cancel", "none").SetStyle(StyleSecondary),
 						NewButton("Add", "add-service", "save"),
@@ -243,40 +522,9 @@ func (p *Plugin) Actions() ActionsMap {
 				return NewErrorAlertActionResult(err)
 			}
 
-			if sd.Name != nil {
-				if err = p.validateName(*sd.Name, s.uuid); err != nil {
-					return NewErrorAlertActionResult(err)
-				}
-
-				s.Name = *sd.Name
-			}
-
-			if sd.Command != nil {
-				if err = p.validateCommand(*sd.Command); err != nil {
-					return NewErrorAlertActionResult(err)
-				}
-
-				s.CMD = *sd.Command
-			}
-
-			if unsafe.Pointer(sd.Args) != nil {
-				s.Args = *sd.Args
-			}
-
-			if sd.Dir != nil {
-				if err = p.validateWorkDir(*sd.Dir); err != nil {
-					return NewErrorAlertActionResult(err)
-				}
-
-				s.Dir = *sd.Dir
-			}
-
-			if sd.Description != nil {
-				s.Description = *sd.Description
-			}
-
-			if sd.Autostart != nil {
-				s.Autostart = *sd.Autostart
+			err = p.updateService(s, &sd)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
 			}
 
 			err = p.api.SaveModuleConfig(&p.settings)
@@ -316,7 +564,7 @@ func (p *Plugin) Actions() ActionsMap {
 					return NewErrorAlertActionResult(err)
 				}
 
-				err = p.validateName(sd.Name, s.uuid)
+				err = p.validateName(sd.Name, s.ID)
 				if err == nil {
 					s.Name = sd.Name
 
@@ -350,9 +598,23 @@ func (p *Plugin) Actions() ActionsMap {
 				return NewErrorAlertActionResult(errors.New("service not found"))
 			}
 
-			err := s.start(func() {
-				p.api.Reload()
-			})
+			if s.isJob() {
+				err := p.runJob(s, triggerManual)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				return NewReloadActionResult()
+			}
+
+			var err error
+
+			// required services are started first in the background, so errors are shown in the output
+			if len(p.settings.withRequired([]*service{s})) > 1 {
+				err = p.startServices([]*service{s})
+			} else {
+				err = p.startService(s)
+			}
 
 			if err != nil {
 				return NewErrorAlertActionResult(err)
@@ -361,6 +623,32 @@ func (p *Plugin) Actions() ActionsMap {
 			return NewReloadActionResult()
 		},
 
+		"stop": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+			confirm := len(args) > 1 && args[1] == "confirm"
+
+			if confirm {
+				return NewModalActionResult(
+					"Stop service",
+					NewLabel("Do you sure about this?"),
+					NewButton("Stop", "stop", serviceID.String()).SetStyle(StyleDanger),
+					NewButton("Cancel", "none"),
+				)
+			}
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			err := p.stopService(s)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
 		"send-signal": func(args []string, data io.Reader) ActionResult {
 			serviceID := uuid.UUID(args[0])
 			send := len(args) > 1 && args[1] == "send"
@@ -452,14 +740,303 @@ func (p *Plugin) Actions() ActionsMap {
 				)
 			}
 
-			for i, s := range p.settings.Services {
-				if s.uuid == serviceID {
-					p.settings.Services = append(p.settings.Services[:i], p.settings.Services[i+1:]...)
-					break
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			err := p.deleteService(s)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			err = p.api.SaveModuleConfig(&p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
+		"set-rlimit": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+			name := args[1]
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			if rlimitByName(name) == nil {
+				return NewErrorAlertActionResult(errors.New("unknown limit"))
+			}
+
+			reqData := struct {
+				Value string `json:"value"`
+			}{}
+
+			err := json.NewDecoder(data).Decode(&reqData)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			// the map is replaced, so a copy of the settings doesn't see the change
+			rlimits := map[string]uint64{}
+
+			s.mx.Lock()
+			for k, v := range s.Rlimits {
+				rlimits[k] = v
+			}
+			s.mx.Unlock()
+
+			if strings.TrimSpace(reqData.Value) == "" {
+				delete(rlimits, name)
+			} else {
+				v, err := parseRlimit(reqData.Value)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				rlimits[name] = v
+			}
+
+			s.mx.Lock()
+			s.Rlimits = rlimits
+			s.mx.Unlock()
+
+			err = p.api.SaveModuleConfig(&p.settings)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			return NewReloadActionResult()
+		},
+
+		"download-output": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			var buf strings.Builder
+
+			for _, l := range s.output.all() {
+				buf.WriteString(l.String())
+				buf.WriteString("\n")
+			}
+
+			return NewDownloadActionResult(fmt.Sprintf("%s.log", s.Name), "text/plain", []byte(buf.String()))
+		},
+
+		"clear-output": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			s.output.clear()
+
+			return NewReloadActionResult()
+		},
+
+		"export-unit": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+			save := len(args) > 1 && args[1] == "save"
+			enable := len(args) > 2 && args[2] == "enable"
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			if p.Units == nil {
+				return NewErrorAlertActionResult(errors.New("systemd is not available"))
+			}
+
+			if save {
+				reqData := struct {
+					Name string `json:"name"`
+					Data string `json:"data"`
+				}{}
+
+				err := json.NewDecoder(data).Decode(&reqData)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				name := strings.TrimSpace(reqData.Name)
+
+				err = p.Units.InstallUnit(name, []byte(reqData.Data), enable)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				// the unit replaces the managed service, so they don't run together
+				if enable {
+					if s.running() {
+						err = s.stop()
+						if err != nil {
+							return NewErrorAlertActionResult(err)
+						}
+					}
+
+					s.Autostart = false
+
+					err = p.api.SaveModuleConfig(&p.settings)
+					if err != nil {
+						return NewErrorAlertActionResult(err)
+					}
+
+					err = p.Units.StartUnit(name)
+					if err != nil {
+						return NewErrorAlertActionResult(errors.Wrap(err, "failed to start unit"))
+					}
+				}
+
+				return NewAlertActionResult("Systemd unit", fmt.Sprintf("Unit %s is installed.", reqData.Name))
+			}
+
+			content, warnings := renderUnit(&p.settings, s)
+
+			form := NewForm().
+				AddWithTitle("Unit name", NewInput("name").SetValue(unitName(s))).
+				AddWithTitle("Unit file", NewCodeEditor("data", content))
+
+			for _, w := range warnings {
+				form.Add(NewLine(NewBadge("warning").SetStyle(StyleWarning), NewLabel("%s", w)))
+			}
+
+			return NewFormModalActionResult(
+				"Convert to systemd unit",
+				form.AddActionButtons(
+					NewButton("Cancel", "none").SetStyle(StyleSecondary),
+					NewButton("Install", "export-unit", serviceID.String(), "save"),
+					NewButton("Install and enable", "export-unit", serviceID.String(), "save", "enable"),
+				),
+			)
+		},
+
+		"import-unit": func(args []string, data io.Reader) ActionResult {
+			save := len(args) > 0 && args[0] == "save"
+
+			if p.Units == nil {
+				return NewErrorAlertActionResult(errors.New("systemd is not available"))
+			}
+
+			if save {
+				reqData := struct {
+					Name string `json:"name"`
+				}{}
+
+				err := json.NewDecoder(data).Decode(&reqData)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				content, err := p.Units.ReadUnitFile(reqData.Name)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				s, warnings, err := serviceFromUnit(reqData.Name, string(content))
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				if err = p.validateName(s.Name, ""); err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				if err = p.validateCommand(s.CMD); err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				s.ID = uuid.New()
+
+				// the service is started by systemd until the unit is disabled
+				s.Autostart = false
+
+				p.addService(s)
+
+				err = p.api.SaveModuleConfig(&p.settings)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				if len(warnings) > 0 {
+					return NewAlertActionResult("Unit is imported with warnings", strings.Join(warnings, "\n"))
 				}
+
+				return NewReloadActionResult()
+			}
+
+			names, err := p.Units.ServiceUnitNames()
+			if err != nil {
+				return NewErrorAlertActionResult(err)
+			}
+
+			unitSelect := NewSelect("name")
+			for _, name := range names {
+				unitSelect.AddOption(name)
+			}
+
+			return NewFormModalActionResult(
+				"Import systemd unit",
+				NewForm().
+					AddWithTitle("Unit", unitSelect).
+					AddActionButtons(
+						NewButton("Cancel", "none").SetStyle(StyleSecondary),
+						NewButton("Import", "import-unit", "save"),
+					),
+			)
+		},
+
+		"job-output": func(args []string, data io.Reader) ActionResult {
+			serviceID := uuid.UUID(args[0])
+
+			s := p.settings.FindServiceByUUID(serviceID)
+			if s == nil {
+				return NewErrorAlertActionResult(errors.New("service not found"))
+			}
+
+			s.mx.Lock()
+			defer s.mx.Unlock()
+
+			id, err := strconv.Atoi(args[1])
+			if err != nil {
+				return NewErrorAlertActionResult(errors.New("run not found"))
+			}
+
+			// the run may be removed from the history by newer runs
+			run := s.findJobRun(id)
+			if run == nil {
+				return NewErrorAlertActionResult(errors.New("run not found"))
+			}
+
+			return NewModalActionResult(
+				fmt.Sprintf("Output of the run at %s", run.startedAt.Format(time.RFC1123)),
+				renderJobOutput(run),
+				NewButton("Close", "none"),
+			)
+		},
+
+		"sort-services": func(args []string, data io.Reader) ActionResult {
+			reqData := struct {
+				SortBy string `json:"sort-by"`
+			}{}
+
+			err := json.NewDecoder(data).Decode(&reqData)
+			if err != nil {
+				return NewErrorAlertActionResult(err)
 			}
 
-			err := p.api.SaveModuleConfig(&p.settings)
+			p.settings.SortBy = reqData.SortBy
+
+			err = p.api.SaveModuleConfig(&p.settings)
 			if err != nil {
 				return NewErrorAlertActionResult(err)
 			}
@@ -478,7 +1055,7 @@ func (p *Plugin) validateName(name string, id uuid.UUID) error {
 		return errors.New("service name can't de empty")
 	}
 
-	if s := p.settings.FindServiceByName(name); s == nil || (id != "" && s.uuid == id) {
+	if s := p.settings.FindServiceByName(name); s == nil || (id != "" && s.ID == id) {
 		return nil
 	}
 
@@ -523,6 +1100,32 @@ func (p *Plugin) validateWorkDir(dir string) error {
 	return nil
 }
 
+func (p *Plugin) validateLogFile(path string) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil
+	}
+
+	if !filepath.IsAbs(path) {
+		return errors.New("log file path must be absolute")
+	}
+
+	s, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("log directory not exist")
+		}
+
+		return err
+	}
+
+	if !s.IsDir() {
+		return errors.New("file is not a directory")
+	}
+
+	return nil
+}
+
 func (p *Plugin) RenderService(serviceID uuid.UUID) Page {
 	s := p.settings.FindServiceByUUID(serviceID)
 
@@ -530,6 +1133,9 @@ func (p *Plugin) RenderService(serviceID uuid.UUID) Page {
 		return NewPage("Error", NewText("Service not found."))
 	}
 
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
 	var (
 		pid       string
 		startedAt string
@@ -541,7 +1147,7 @@ func (p *Plugin) RenderService(serviceID uuid.UUID) Page {
 		pid = fmt.Sprintf("%d", pr.Pid)
 		startedAt = s.startedAt.Format(time.RFC1123)
 		controls = NewLine(
-			NewButton("Stop", "signal", serviceID.String(), "2", "confirm").SetStyle(StyleDanger),
+			NewButton("Stop", "stop", serviceID.String(), "confirm").SetStyle(StyleDanger),
 			NewButton("Kill", "signal", serviceID.String(), "9", "confirm").SetStyle(StyleDanger),
 			NewButton("Reload", "signal", serviceID.String(), "1").SetStyle(StyleDanger),
 			//NewButton("Restart", "service-action", "restart", serviceID.String()).SetStyle(StyleDanger),
@@ -549,12 +1155,77 @@ func (p *Plugin) RenderService(serviceID uuid.UUID) Page {
 		)
 	} else {
 
+		startTitle := "Start"
+		if s.isJob() {
+			startTitle = "Run now"
+		}
+
 		controls = NewLine(
-			NewButton("Start", "start", serviceID.String()).SetImage("play").SetStyle(StyleSuccess),
+			NewButton(startTitle, "start", serviceID.String()).SetImage("play").SetStyle(StyleSuccess),
 		)
+
+		if s.restart.timer != nil {
+			controls.Add(NewButton("Stop", "stop", serviceID.String(), "confirm").SetStyle(StyleDanger))
+		}
+	}
+
+	restartDelay, restartBurst, restartInterval := s.restartSettings()
+	cpu, rss, fds, threads, uptime := usageLabels(s)
+	_, stopTimeout := s.stopSettings()
+
+	stopSignalSelect := NewSelectEdit("stop-signal", "update", serviceID.String()).SetValue(s.stopSignalName())
+	for _, sig := range signals {
+		stopSignalSelect.AddOption(sig.string)
+	}
+
+	cpuQuota := ""
+	if s.CPUQuota > 0 {
+		cpuQuota = fmt.Sprintf("%d%%", s.CPUQuota)
 	}
 
-	return NewPage(
+	limits := NewElementsList().SetModeLine().
+		AddElementWithTitle(
+			NewLabel("CPU quota").SetStrong(true),
+			NewInputEdit("cpu-quota", cpuQuota, "update", serviceID.String()),
+		).
+		AddElementWithTitle(
+			NewLabel("Memory max").SetStrong(true),
+			NewInputEdit("memory-max", s.MemoryMax, "update", serviceID.String()),
+		)
+
+	for _, r := range rlimits {
+		value := ""
+		if v, ok := s.Rlimits[r.name]; ok {
+			value = formatRlimit(v)
+		}
+
+		limits.AddElementWithTitle(
+			NewLabel(r.title).SetStrong(true),
+			NewInputEdit("value", value, "set-rlimit", serviceID.String(), r.name),
+		)
+	}
+
+	restartSelect := NewSelectEdit("restart", "update", serviceID.String()).SetValue(s.restartPolicy())
+	for _, r := range restartPolicies {
+		restartSelect.AddOption(r)
+	}
+
+	var nextRestart string
+	if !s.restart.next.IsZero() {
+		nextRestart = s.restart.next.Format(time.RFC1123)
+	}
+
+	logMaxSize := s.LogMaxSize
+	if logMaxSize == 0 {
+		logMaxSize = defaultLogMaxSize
+	}
+
+	logMaxFiles := s.LogMaxFiles
+	if logMaxFiles == 0 {
+		logMaxFiles = defaultLogMaxFiles
+	}
+
+	page := NewPage(
 		fmt.Sprintf("Services %s", s.Name),
 		NewButton("Back", "select-service").SetImage("arrow-left-short"),
 		NewHeader("Service info"),
@@ -582,40 +1253,161 @@ func (p *Plugin) RenderService(serviceID uuid.UUID) Page {
 			AddElementWithTitle(
 				NewLabel("Auto start").SetStrong(true),
 				NewSwitch("autostart").SetAction("update", serviceID.String()).SetValue(s.Autostart),
+			).
+			AddElementWithTitle(
+				NewLabel("Keep running when qubert stops").SetStrong(true),
+				NewSwitch("keep-running").SetAction("update", serviceID.String()).SetValue(s.KeepRunning),
+			),
+		NewHeader("User"),
+		NewElementsList().SetModeLine().
+			AddElementWithTitle(
+				NewLabel("User").SetStrong(true),
+				NewInputEdit("user", s.User, "update", serviceID.String()),
+			).
+			AddElementWithTitle(
+				NewLabel("Group").SetStrong(true),
+				NewInputEdit("group", s.Group, "update", serviceID.String()),
+			).
+			AddElementWithTitle(
+				NewLabel("Supplementary groups").SetStrong(true),
+				NewTagsEdit("groups", s.Groups, "update", serviceID.String()),
+			).
+			AddElementWithTitle(
+				NewLabel("Umask").SetStrong(true),
+				NewInputEdit("umask", s.Umask, "update", serviceID.String()),
+			),
+		NewHeader("Environment"),
+		NewTextareaEdit("env", strings.Join(s.Env, "\n"), "update", serviceID.String()),
+		NewHeader("Resource limits"),
+		limits,
+	)
+
+	if s.isJob() {
+		page.AddElements(renderJob(s)...)
+	} else {
+		page.AddElements(
+			NewHeader("Restart policy"),
+			NewElementsList().SetModeLine().
+				AddElementWithTitle(NewLabel("Restart").SetStrong(true), restartSelect).
+				AddElementWithTitle(
+					NewLabel("Delay (sec)").SetStrong(true),
+					NewInputEdit("restart-delay", fmt.Sprintf("%d", restartDelay/time.Second), "update", serviceID.String()),
+				).
+				AddElementWithTitle(
+					NewLabel("Max restarts").SetStrong(true),
+					NewInputEdit("restart-burst", fmt.Sprintf("%d", restartBurst), "update", serviceID.String()),
+				).
+				AddElementWithTitle(
+					NewLabel("Interval (sec)").SetStrong(true),
+					NewInputEdit("restart-interval", fmt.Sprintf("%d", restartInterval/time.Second), "update", serviceID.String()),
+				),
+		)
+	}
+
+	page.AddElements(
+		NewHeader("Stop"),
+		NewElementsList().SetModeLine().
+			AddElementWithTitle(NewLabel("Stop signal").SetStrong(true), stopSignalSelect).
+			AddElementWithTitle(
+				NewLabel("Timeout (sec)").SetStrong(true),
+				NewInputEdit("stop-timeout", fmt.Sprintf("%d", stopTimeout/time.Second), "update", serviceID.String()),
+			),
+	)
+
+	if !s.isJob() {
+		page.AddElements(renderDependencies(&p.settings, s)...)
+		page.AddElements(renderHealth(s)...)
+	}
+
+	page.AddElements(
+		NewHeader("Logging"),
+		NewElementsList().SetModeLine().
+			AddElementWithTitle(
+				NewLabel("Log file").SetStrong(true),
+				NewInputEdit("log-file", s.LogFile, "update", serviceID.String()),
+			).
+			AddElementWithTitle(
+				NewLabel("Max file size (MiB)").SetStrong(true),
+				NewInputEdit("log-max-size", fmt.Sprintf("%d", logMaxSize), "update", serviceID.String()),
+			).
+			AddElementWithTitle(
+				NewLabel("Rotated files").SetStrong(true),
+				NewInputEdit("log-max-files", fmt.Sprintf("%d", logMaxFiles), "update", serviceID.String()),
 			),
 		NewHeader("Service status"),
 		NewElementsList().SetModeLine().
-			AddElementWithTitle(NewLabel("Status").SetStrong(true), statusBadge(s)).
+			AddElementWithTitle(NewLabel("Status").SetStrong(true), statusBadges(s)).
 			AddElementWithTitle(NewLabel("PID").SetStrong(true), NewLabel(pid)).
-			AddElementWithTitle(NewLabel("Started at").SetStrong(true), NewLabel(startedAt)),
+			AddElementWithTitle(NewLabel("Started at").SetStrong(true), NewLabel(startedAt)).
+			AddElementWithTitle(NewLabel("Restarts").SetStrong(true), NewLabel("%d", s.restart.count)).
+			AddElementWithTitle(NewLabel("Last exit").SetStrong(true), NewLabel(exitStatus(s))).
+			AddElementWithTitle(NewLabel("Next restart").SetStrong(true), NewLabel(nextRestart)).
+			AddElementWithTitle(NewLabel("CPU").SetStrong(true), cpu).
+			AddElementWithTitle(NewLabel("Memory").SetStrong(true), rss).
+			AddElementWithTitle(NewLabel("Open files").SetStrong(true), fds).
+			AddElementWithTitle(NewLabel("Threads").SetStrong(true), threads).
+			AddElementWithTitle(NewLabel("Uptime").SetStrong(true), uptime),
 		NewHeader("Service controls"),
 		controls,
-		//NewHeader("Service output"),
-		//NewTerminal(),
 	)
+
+	page.AddElements(renderOutput(s)...)
+
+	return page
 }
 
 func (p *Plugin) RenderServiceList() Page {
-	table := NewTable("Services", "status", "Pid")
+	table := NewTable("Services", "status", "Pid", "CPU", "Memory", "Open files", "Threads", "Uptime", "Restarts")
+
+	sortSelect := NewSelectEdit("sort-by", "sort-services").
+		AddNamedOption("Default", "").
+		SetValue(p.settings.SortBy)
+	for _, k := range sortKeys {
+		sortSelect.AddNamedOption(k.title, k.key)
+	}
+
+	for _, s := range sortServices(p.settings.Services, p.settings.SortBy) {
+		s.mx.Lock()
+
+		cpu, rss, fds, threads, uptime := usageLabels(s)
 
-	for _, s := range p.settings.Services {
 		table.AddLine(
 			NewElementsList().AddElements(
 				NewLine(
-					NewButton(s.Name, "select-service", s.uuid.String()).SetLinkStyle(),
+					NewButton(s.Name, "select-service", s.ID.String()).SetLinkStyle(),
 					serviceDropdown(s),
 				),
 			).SetModeLine(),
-			statusBadge(s),
+			statusBadges(s),
 			pidLabel(s),
+			cpu,
+			rss,
+			fds,
+			threads,
+			uptime,
+			NewLabel("%d", s.restart.count),
 		)
+
+		s.mx.Unlock()
 	}
 
-	return NewPage(
+	page := NewPage(
 		"Services",
-		NewButton("Add", "add-service", "").SetImage("plus-lg"),
-		table,
+		NewLine(
+			NewButton("Add", "add-service", "").SetImage("plus-lg"),
+			NewButton("Import unit", "import-unit").SetImage("box-arrow-in-down").SetStyle(StyleSecondary),
+			NewLabel("Sort by").SetStrong(true),
+			sortSelect,
+		),
 	)
+
+	if err := p.settings.validateDependencies(); err != nil {
+		page.AddElements(NewLine(NewBadge("error").SetStyle(StyleDanger), NewLabel("%s", err.Error())))
+	}
+
+	page.AddElements(table)
+
+	return page
 }
 
 func (p *Plugin) Render(args []string) Page {
@@ -628,11 +1420,39 @@ func (p *Plugin) Render(args []string) Page {
 	return p.RenderServiceList()
 }
 
+// running returns true when the process of the service is started.
+func (s *service) running() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.process != nil
+}
+
 func statusBadge(s *service) *Badge {
+	if s.isJob() && s.process == nil {
+		if len(s.job.history) == 0 {
+			return NewBadge("idle").SetStyle(StyleSecondary)
+		}
+
+		return runStatusBadge(s.job.history[0].status)
+	}
+
+	if s.process != nil && s.stopping {
+		return NewBadge("stopping").SetStyle(StyleWarning)
+	}
+
 	if s.process != nil {
 		return NewBadge("running").SetStyle(StyleSuccess)
 	}
 
+	if s.restart.timer != nil {
+		return NewBadge("restarting").SetStyle(StyleWarning)
+	}
+
+	if s.restart.failed {
+		return NewBadge("failed").SetStyle(StyleDanger)
+	}
+
 	if ps := s.processState; ps == nil || ps.Success() {
 		return NewBadge("stopped").SetStyle(StyleSecondary)
 	}
@@ -642,25 +1462,30 @@ func statusBadge(s *service) *Badge {
 
 func serviceDropdown(s *service) *Dropdown {
 	dd := NewDropdown()
-	dd.AddItem("pencil", "Rename", "rename", s.uuid.String(), "")
+	dd.AddItem("pencil", "Rename", "rename", s.ID.String(), "")
 	dd.AddSeparator()
 
 	var signalAction string
 	var startAction string
+	var stopAction string
 
 	if s.process != nil {
 		signalAction = "signal"
+		stopAction = "stop"
 	} else {
 		startAction = "start"
 	}
 
-	dd.AddItem("play", "Start", startAction, s.uuid.String())
-	dd.AddItem("stop", "Stop", signalAction, s.uuid.String(), "2", "confirm")
-	dd.AddItem("x", "Kill", signalAction, s.uuid.String(), "9", "confirm")
-	dd.AddItem("arrow-repeat", "Reload", signalAction, s.uuid.String(), "1")
+	dd.AddItem("play", "Start", startAction, s.ID.String())
+	dd.AddItem("stop", "Stop", stopAction, s.ID.String(), "confirm")
+	dd.AddItem("x", "Kill", signalAction, s.ID.String(), "9", "confirm")
+	dd.AddItem("arrow-repeat", "Reload", signalAction, s.ID.String(), "1")
+
+	dd.AddSeparator()
+	dd.AddItem("box-arrow-up-right", "Convert to systemd unit", "export-unit", s.ID.String())
 
 	dd.AddSeparator()
-	dd.AddDangerItem("trash", "Delete", "delete", s.uuid.String(), "confirm")
+	dd.AddDangerItem("trash", "Delete", "delete", s.ID.String(), "confirm")
 
 	return dd
 }
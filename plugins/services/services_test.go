@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "qubert/pluginTools"
+
+	"qubert/uuid"
+)
+
+// testAPI is the plugin API without sessions and storage.
+type testAPI struct {
+	wg sync.WaitGroup
+}
+
+func (a *testAPI) SaveModuleConfig(cfg interface{}) error      { return nil }
+func (a *testAPI) LoadModuleConfig(cfg interface{}) error      { return nil }
+func (a *testAPI) Send(data interface{}, args ...string)       {}
+func (a *testAPI) SendUpdate(u Update, args ...string) bool    { return true }
+func (a *testAPI) SendAlert(title, text, callerID string) bool { return true }
+func (a *testAPI) CallerUserName(callerID string) string       { return "root" }
+func (a *testAPI) Reload(args ...string)                       {}
+func (a *testAPI) Exit()                                       {}
+func (a *testAPI) Shutdown() error                             { return nil }
+func (a *testAPI) Restart() error                              { return nil }
+func (a *testAPI) Version() (string, string)                   { return "", "" }
+func (a *testAPI) SafeRun(f func())                            { a.wg.Add(1); defer a.wg.Done(); f() }
+
+func newTestPlugin(t *testing.T) (*Plugin, context.CancelFunc) {
+	serviceStateDir = t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Plugin{api: &testAPI{}, ctx: ctx}, cancel
+}
+
+// TestConcurrentRuntimeState runs restarts, health checks, job timeouts and monitors
+// together with rendering, it is meant to be run with -race.
+func TestConcurrentRuntimeState(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the test runs processes for several seconds")
+	}
+
+	p, cancel := newTestPlugin(t)
+	defer cancel()
+
+	restarted := &service{
+		ID:           uuid.New(),
+		Name:         "restarted",
+		CMD:          "/bin/true",
+		Restart:      restartAlways,
+		RestartDelay: 1,
+	}
+
+	unhealthy := &service{
+		ID:              uuid.New(),
+		Name:            "unhealthy",
+		CMD:             "/bin/sleep",
+		Args:            []string{"10"},
+		HealthType:      healthExec,
+		HealthTarget:    "false",
+		HealthInterval:  1,
+		HealthThreshold: 1,
+		HealthRestart:   true,
+	}
+
+	job := &service{
+		ID:       uuid.New(),
+		Name:     "job",
+		Type:     typeJob,
+		CMD:      "/bin/sleep",
+		Args:     []string{"10"},
+		Schedule: "@every 1s",
+		Timeout:  1,
+		Overlap:  overlapQueue,
+	}
+
+	for _, s := range []*service{restarted, unhealthy, job} {
+		p.addService(s)
+	}
+
+	p.runOutputMonitor()
+	p.runHealthMonitor()
+	p.runUsageMonitor()
+	p.runJobScheduler()
+
+	job.mx.Lock()
+	job.scheduleNext(time.Now())
+	job.mx.Unlock()
+
+	for _, s := range []*service{restarted, unhealthy} {
+		if err := p.startService(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(4 * time.Second)
+	for time.Now().Before(deadline) {
+		p.Render(nil)
+
+		for _, s := range p.services() {
+			p.Render([]string{s.ID.String()})
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	p.stopAll()
+
+	restarted.mx.Lock()
+	defer restarted.mx.Unlock()
+
+	if restarted.restart.count == 0 {
+		t.Error("service is not restarted")
+	}
+}
+
+func TestDeleteService(t *testing.T) {
+	p, cancel := newTestPlugin(t)
+	defer cancel()
+
+	s := &service{
+		ID:      uuid.New(),
+		Name:    "deleted",
+		CMD:     "/bin/sleep",
+		Args:    []string{"10"},
+		Restart: restartAlways,
+	}
+
+	p.addService(s)
+
+	err := p.startService(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mx.Lock()
+	pid := s.process.Pid
+	s.mx.Unlock()
+
+	err = p.deleteService(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.services()) != 0 {
+		t.Error("service is not removed from the list")
+	}
+
+	if _, err := readProcStat(pid); err == nil {
+		t.Errorf("process %d is running", pid)
+	}
+
+	for _, ext := range []string{"json", streamStdout, streamStderr} {
+		if _, err := os.Stat(s.statePath(ext)); !os.IsNotExist(err) {
+			t.Errorf("state file %s is not removed", ext)
+		}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.restart.timer != nil {
+		t.Error("service is restarted after the delete")
+	}
+}
+
+func TestFindJobRun(t *testing.T) {
+	s := &service{}
+
+	for id := 1; id <= jobHistorySize+2; id++ {
+		s.addJobRun(&jobRun{id: id})
+	}
+
+	// the newest run is the first in the history
+	if run := s.findJobRun(jobHistorySize + 2); run == nil || run != s.job.history[0] {
+		t.Error("the last run is not found")
+	}
+
+	if run := s.findJobRun(3); run == nil || run.id != 3 {
+		t.Error("the oldest kept run is not found")
+	}
+
+	if run := s.findJobRun(2); run != nil {
+		t.Error("the removed run is found")
+	}
+}
+
+func TestExitWithOpenOutput(t *testing.T) {
+	p, cancel := newTestPlugin(t)
+	defer cancel()
+
+	// the background child keeps the output FIFOs open after the exit of the shell
+	s := &service{
+		ID:   uuid.New(),
+		Name: "daemonized",
+		CMD:  "/bin/sh",
+		Args: []string{"-c", "sleep 30 & echo started"},
+	}
+
+	p.addService(s)
+
+	exited := make(chan struct{})
+
+	s.mx.Lock()
+	err := s.start(func() { close(exited) })
+	pgid := s.process.Pid
+	s.mx.Unlock()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = syscall.Kill(-pgid, syscall.SIGKILL) }()
+
+	select {
+	case <-exited:
+	case <-time.After(outputDrainTimeout + 3*time.Second):
+		t.Fatal("exit is not handled while the output is open")
+	}
+
+	var lines []string
+	for _, l := range s.output.all() {
+		lines = append(lines, l.text)
+	}
+
+	if len(lines) == 0 || lines[len(lines)-1] != "exit status 0" {
+		t.Errorf("exit status is not the last line: %q", lines)
+	}
+}
+
+func TestUpdateServiceIsAtomic(t *testing.T) {
+	p, cancel := newTestPlugin(t)
+	defer cancel()
+
+	s := &service{
+		ID:           uuid.New(),
+		Name:         "updated",
+		CMD:          "/bin/sleep",
+		Args:         []string{"10"},
+		RestartDelay: 1,
+	}
+
+	p.addService(s)
+
+	delay, timeout := "5", "-1"
+
+	err := p.updateService(s, &serviceUpdateDef{RestartDelay: &delay, StopTimeout: &timeout})
+	if err == nil {
+		t.Fatal("incorrect stop timeout is accepted")
+	}
+
+	if s.RestartDelay != 1 {
+		t.Errorf("failed update changed the restart delay to %d", s.RestartDelay)
+	}
+
+	err = p.updateService(s, &serviceUpdateDef{RestartDelay: &delay})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.RestartDelay != 5 {
+		t.Errorf("restart delay is %d, expected 5", s.RestartDelay)
+	}
+}
+
+// TestConcurrentUpdate updates the service while the monitors read its settings,
+// it is meant to be run with -race.
+func TestConcurrentUpdate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the test runs processes for several seconds")
+	}
+
+	p, cancel := newTestPlugin(t)
+	defer cancel()
+
+	s := &service{
+		ID:             uuid.New(),
+		Name:           "updated",
+		CMD:            "/bin/sleep",
+		Args:           []string{"10"},
+		Schedule:       "@every 1s",
+		HealthType:     healthExec,
+		HealthTarget:   "true",
+		HealthInterval: 1,
+	}
+
+	p.addService(s)
+
+	p.runHealthMonitor()
+	p.runJobScheduler()
+
+	if err := p.startService(s); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for i := 0; time.Now().Before(deadline); i++ {
+		interval, schedule, target := fmt.Sprintf("%d", i%3+1), fmt.Sprintf("@every %ds", i%3+1), "true"
+		restart := []string{restartAlways, restartOnFailure}[i%2]
+
+		err := p.updateService(s, &serviceUpdateDef{
+			HealthInterval: &interval,
+			HealthTarget:   &target,
+			Schedule:       &schedule,
+			Restart:        &restart,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p.Render([]string{s.ID.String()})
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	p.stopAll()
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serviceStateDir keeps state files and output FIFOs of started services,
+// it is cleared on reboot together with the processes.
+var serviceStateDir = "/run/qubert/services"
+
+// serviceState is stored for every started process, so qubert can reattach to it after a restart.
+type serviceState struct {
+	PID int `json:"pid"`
+	// start time of the process in clock ticks since boot, it protects from the reused pid
+	ProcStart uint64    `json:"proc-start"`
+	StartedAt time.Time `json:"started-at"`
+}
+
+func (s *service) statePath(ext string) string {
+	return filepath.Join(serviceStateDir, fmt.Sprintf("%s.%s", s.ID, ext))
+}
+
+// procStartTime returns the start time of the process in clock ticks since boot.
+func procStartTime(pid int) (uint64, error) {
+	st, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	return st.start, nil
+}
+
+func (st *serviceState) alive() bool {
+	start, err := procStartTime(st.PID)
+
+	return err == nil && start == st.ProcStart
+}
+
+func (s *service) saveState(pid int) error {
+	start, err := procStartTime(pid)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&serviceState{
+		PID:       pid,
+		ProcStart: start,
+		StartedAt: s.startedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.statePath("json"), data, 0600)
+}
+
+func (s *service) loadState() (*serviceState, error) {
+	data, err := ioutil.ReadFile(s.statePath("json"))
+	if err != nil {
+		return nil, err
+	}
+
+	st := &serviceState{}
+
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (s *service) removeState() {
+	for _, ext := range []string{"json", streamStdout, streamStderr} {
+		_ = os.Remove(s.statePath(ext))
+	}
+}
+
+// openOutputReader opens the read end of the output FIFO, it doesn't wait for writers.
+func (s *service) openOutputReader(stream string) (*os.File, error) {
+	return os.OpenFile(s.statePath(stream), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+}
+
+// createOutput creates FIFOs for stdout and stderr of the process.
+// The process opens them for reading and writing, so it doesn't get SIGPIPE while qubert restarts,
+// the output is kept in the FIFO buffer until qubert reattaches.
+func (s *service) createOutput() (readers []*os.File, writers []*os.File, err error) {
+	closeAll := func() {
+		for _, f := range append(readers, writers...) {
+			_ = f.Close()
+		}
+	}
+
+	err = os.MkdirAll(serviceStateDir, 0700)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, stream := range []string{streamStdout, streamStderr} {
+		path := s.statePath(stream)
+
+		_ = os.Remove(path)
+
+		err = syscall.Mkfifo(path, 0600)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "failed to create %s FIFO", stream)
+		}
+
+		r, err := s.openOutputReader(stream)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		readers = append(readers, r)
+
+		w, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+
+		writers = append(writers, w)
+	}
+
+	return readers, writers, nil
+}
+
+// outputDrainTimeout limits reading of the output after the exit of the process,
+// children which are left running may keep the output FIFOs open.
+const outputDrainTimeout = 2 * time.Second
+
+// outputCapture reads the output FIFOs of the process in the background.
+type outputCapture struct {
+	wg    sync.WaitGroup
+	files []*os.File
+}
+
+// captureOutput reads the output FIFOs until all writers are closed.
+func (s *service) captureOutput(stdout *os.File, stderr *os.File) *outputCapture {
+	c := &outputCapture{files: []*os.File{stdout, stderr}}
+
+	c.wg.Add(2)
+	go func() { defer c.wg.Done(); s.output.capture(streamStdout, stdout) }()
+	go func() { defer c.wg.Done(); s.output.capture(streamStderr, stderr) }()
+
+	return c
+}
+
+// wait waits until the output is read, the FIFOs are closed when writers are still open after the timeout.
+// It returns false when the reading is stopped by the timeout.
+func (c *outputCapture) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	// the readers are non-blocking, so closing them interrupts the reading
+	for _, f := range c.files {
+		_ = f.Close()
+	}
+
+	<-done
+
+	return false
+}
+
+// finish handles the exit of the process.
+func (s *service) finish(state *os.ProcessState, err error, output *outputCapture, cb func()) {
+	s.mx.Lock()
+
+	exited, cgroup := s.exited, s.cgroup
+
+	s.processState = state
+	s.process = nil
+	s.cgroup = ""
+
+	// files are removed before the service can be started again, so files of the next run are kept
+	s.removeState()
+
+	s.mx.Unlock()
+
+	close(exited)
+
+	// the output of the process is read before the exit line,
+	// the wait is limited because children of the process may keep the output open
+	if !output.wait(outputDrainTimeout) {
+		s.output.add(streamSystem, "output is kept open by children of the process, stopped reading it")
+	}
+
+	// the cgroup is busy while processes are left in it or the next run uses it
+	if cgroup != "" {
+		_ = os.Remove(cgroup)
+	}
+
+	switch {
+	case err != nil:
+		s.output.add(streamSystem, fmt.Sprintf("wait error: %v", err))
+	case state == nil:
+		s.output.add(streamSystem, "exited, exit status is unknown")
+	default:
+		s.output.add(streamSystem, state.String())
+	}
+
+	cb()
+}
+
+// reattach finds the process which was started by the previous run of qubert.
+// The process isn't a child of qubert, so its exit is detected by polling and the exit status is unknown.
+func (s *service) reattach(cb func()) (bool, error) {
+	st, err := s.loadState()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if !st.alive() {
+		s.removeState()
+		return false, nil
+	}
+
+	stdout, err := s.openOutputReader(streamStdout)
+	if err != nil {
+		return false, err
+	}
+
+	stderr, err := s.openOutputReader(streamStderr)
+	if err != nil {
+		_ = stdout.Close()
+		return false, err
+	}
+
+	pr, err := os.FindProcess(st.PID)
+	if err != nil {
+		_ = stdout.Close()
+		_ = stderr.Close()
+
+		return false, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	err = s.output.setLogFile(s.LogFile, s.LogMaxSize, s.LogMaxFiles)
+	if err != nil {
+		s.output.add(streamSystem, fmt.Sprintf("failed to open log file: %v", err))
+	}
+
+	s.cgroup = processCgroup(st.PID)
+
+	s.process = pr
+	s.startedAt = st.StartedAt
+	s.exited = make(chan struct{})
+	s.health = healthState{status: healthStarting}
+
+	s.output.add(streamSystem, fmt.Sprintf("reattached to pid %d", st.PID))
+
+	output := s.captureOutput(stdout, stderr)
+
+	go func() {
+		for st.alive() {
+			time.Sleep(time.Second)
+		}
+
+		s.finish(nil, nil, output, cb)
+	}()
+
+	return true, nil
+}
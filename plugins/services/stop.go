@@ -0,0 +1,220 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultStopSignal  = "SIGTERM"
+	defaultStopTimeout = 10
+)
+
+var (
+	errNotStarted = errors.New("service not started")
+	errStopping   = errors.New("service is stopping")
+)
+
+func signalByName(name string) (syscall.Signal, error) {
+	for _, sig := range signals {
+		if sig.string == name {
+			return sig.Signal, nil
+		}
+	}
+
+	return 0, errors.New("unknown signal")
+}
+
+func (s *service) stopSettings() (sig syscall.Signal, timeout time.Duration) {
+	sig, timeout = syscall.SIGTERM, defaultStopTimeout*time.Second
+
+	if v, err := signalByName(s.StopSignal); err == nil {
+		sig = v
+	}
+
+	if s.StopTimeout > 0 {
+		timeout = time.Duration(s.StopTimeout) * time.Second
+	}
+
+	return
+}
+
+func (s *service) stopSignalName() string {
+	if s.StopSignal == "" {
+		return defaultStopSignal
+	}
+
+	return s.StopSignal
+}
+
+// signalGroup sends the signal to the process group of the service,
+// so children of the process receive it too.
+func signalGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if err == syscall.ESRCH {
+		return nil
+	}
+
+	return err
+}
+
+// stop sends the stop signal to the process group and waits for the exit of the process,
+// the group is killed when the process doesn't exit in the timeout.
+// Processes left in the group or in the cgroup after the exit are killed too.
+func (s *service) stop() error {
+	pr, exited, err := s.beginStop()
+	if err != nil || pr == nil {
+		return err
+	}
+
+	defer func() {
+		s.mx.Lock()
+		s.stopping = false
+		s.mx.Unlock()
+	}()
+
+	// the cgroup is cleared on the exit, but processes left in it are killed after the exit
+	s.mx.Lock()
+	cgroup := s.cgroup
+	sig, timeout := s.stopSettings()
+	sigName := s.stopSignalName()
+	s.mx.Unlock()
+
+	s.output.add(streamSystem, fmt.Sprintf("stopping with %s", sigName))
+
+	err = signalGroup(pr.Pid, sig)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(timeout):
+		s.output.add(streamSystem, fmt.Sprintf("not stopped in %s, killing", timeout))
+	}
+
+	err = signalGroup(pr.Pid, syscall.SIGKILL)
+	if err != nil {
+		return err
+	}
+
+	return killCgroup(cgroup)
+}
+
+// beginStop cancels the restart and marks the service as stopping,
+// the process is nil when only the pending restart is canceled.
+func (s *service) beginStop() (*os.Process, chan struct{}, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	pending := s.restart.timer != nil
+
+	s.restart.stopped = true
+	s.cancelRestart()
+
+	if s.process == nil {
+		if pending {
+			return nil, nil, nil
+		}
+
+		return nil, nil, errNotStarted
+	}
+
+	if s.stopping {
+		return nil, nil, errStopping
+	}
+
+	s.stopping = true
+
+	return s.process, s.exited, nil
+}
+
+// stopService stops the service in the background, the stop may take the whole timeout.
+func (p *Plugin) stopService(s *service) error {
+	s.mx.Lock()
+	started := s.process != nil || s.restart.timer != nil
+	s.mx.Unlock()
+
+	if !started {
+		return errNotStarted
+	}
+
+	go func() {
+		err := s.stop()
+		if err != nil {
+			s.output.add(streamSystem, fmt.Sprintf("stop error: %v", err))
+		}
+
+		p.api.Reload()
+	}()
+
+	return nil
+}
+
+// stopAll stops all running services in the reverse dependency order and waits for them,
+// services which keep running are reattached by the next run of qubert.
+func (p *Plugin) stopAll() {
+	var services []*service
+
+	for _, s := range p.services() {
+		s.mx.Lock()
+
+		if s.process == nil || s.KeepRunning {
+			s.cancelRestart()
+		} else {
+			services = append(services, s)
+		}
+
+		s.mx.Unlock()
+	}
+
+	p.stopServices(services)
+}
+
+// deleteService stops the service and waits for its exit, then the service is removed with its state and cgroup.
+func (p *Plugin) deleteService(s *service) error {
+	s.mx.Lock()
+
+	running, exited := s.process != nil, s.exited
+
+	// the job isn't run again by the schedule or the queue
+	s.job.next = time.Time{}
+	s.job.queued = false
+
+	s.mx.Unlock()
+
+	// the pending restart is canceled by the stop too
+	err := s.stop()
+	if err != nil && err != errNotStarted && err != errStopping {
+		return err
+	}
+
+	// the service may be stopped by another request, its exit is waited too
+	if running {
+		<-exited
+	}
+
+	p.mx.Lock()
+
+	for i, ps := range p.settings.Services {
+		if ps == s {
+			p.settings.Services = append(p.settings.Services[:i], p.settings.Services[i+1:]...)
+			break
+		}
+	}
+
+	p.settings.removeDependency(s.ID)
+
+	p.mx.Unlock()
+
+	s.removeState()
+
+	// left processes of the service are killed by the stop, so the cgroup is empty
+	_ = os.Remove(s.cgroupPath())
+
+	return nil
+}
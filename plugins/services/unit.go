@@ -0,0 +1,368 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+// UnitManager installs and reads systemd units, it is implemented by the systemd plugin.
+type UnitManager interface {
+	InstallUnit(name string, content []byte, enable bool) error
+	StartUnit(name string) error
+	ReadUnitFile(name string) ([]byte, error)
+	ServiceUnitNames() ([]string, error)
+}
+
+var unitNameRe = regexp.MustCompile(`[^A-Za-z0-9:_.-]`)
+
+var unitRestart = map[string]string{
+	restartNever:     "no",
+	restartOnFailure: "on-failure",
+	restartAlways:    "always",
+}
+
+var unitRlimits = map[string]string{
+	"nofile":  "LimitNOFILE",
+	"nproc":   "LimitNPROC",
+	"core":    "LimitCORE",
+	"stack":   "LimitSTACK",
+	"as":      "LimitAS",
+	"memlock": "LimitMEMLOCK",
+}
+
+func unitName(s *service) string {
+	return unitNameRe.ReplaceAllString(s.Name, "-") + ".service"
+}
+
+// quoteUnitValue quotes the value which may contain spaces or quotes.
+func quoteUnitValue(value string) string {
+	value = EscapeUnitValue(value)
+
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\;") {
+		return value
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// quoteUnitArg quotes the argument of the command line, variables are escaped too.
+func quoteUnitArg(arg string) string {
+	return quoteUnitValue(strings.ReplaceAll(arg, "$", "$$"))
+}
+
+// splitUnitArgs splits the command line or the environment of the unit with quotes and escapes.
+func splitUnitArgs(line string, commandLine bool) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		escape  bool
+		inArg   bool
+	)
+
+	for _, r := range line {
+		switch {
+		case escape:
+			current.WriteRune(r)
+			escape = false
+		case r == '\\':
+			escape = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escape {
+		return nil, errors.New("unterminated quote in the command line")
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	for i, a := range args {
+		args[i] = UnescapeUnitValue(a)
+
+		if commandLine {
+			args[i] = strings.ReplaceAll(args[i], "$$", "$")
+		}
+	}
+
+	return args, nil
+}
+
+// renderUnit renders the service into a systemd unit file.
+// Settings which systemd doesn't support are returned as warnings.
+func renderUnit(ps *PluginSettings, s *service) (string, []string) {
+	var (
+		b        strings.Builder
+		warnings []string
+	)
+
+	line := func(key string, value string) {
+		if value != "" {
+			b.WriteString(fmt.Sprintf("%s=%s\n", key, value))
+		}
+	}
+
+	description := s.Description
+	if description == "" {
+		description = s.Name
+	}
+
+	b.WriteString("[Unit]\n")
+	line("Description", EscapeUnitValue(strings.ReplaceAll(strings.TrimSpace(description), "\n", " ")))
+
+	for _, d := range ps.dependencies(s) {
+		line("After", unitName(d))
+	}
+
+	for _, id := range s.Requires {
+		if d := ps.FindServiceByUUID(id); d != nil {
+			line("Requires", unitName(d))
+		}
+	}
+
+	b.WriteString("\n[Service]\n")
+
+	if s.isJob() {
+		line("Type", "oneshot")
+
+		if s.Schedule != "" {
+			warnings = append(warnings, "the schedule of the job isn't converted, use a systemd timer")
+		}
+	} else {
+		line("Type", "simple")
+	}
+
+	args := []string{quoteUnitArg(s.CMD)}
+	for _, a := range s.Args {
+		args = append(args, quoteUnitArg(a))
+	}
+
+	line("ExecStart", strings.Join(args, " "))
+	line("WorkingDirectory", EscapeUnitValue(s.Dir))
+
+	for _, e := range s.Env {
+		line("Environment", quoteUnitValue(e))
+	}
+
+	line("User", s.User)
+	line("Group", s.Group)
+	line("SupplementaryGroups", strings.Join(s.Groups, " "))
+	line("UMask", s.Umask)
+
+	if !s.isJob() {
+		delay, _, _ := s.restartSettings()
+
+		line("Restart", unitRestart[s.restartPolicy()])
+
+		if s.restartPolicy() != restartNever {
+			line("RestartSec", fmt.Sprintf("%d", int(delay.Seconds())))
+		}
+	}
+
+	if s.StopSignal != "" {
+		line("KillSignal", s.StopSignal)
+	}
+
+	if s.StopTimeout > 0 {
+		line("TimeoutStopSec", fmt.Sprintf("%d", s.StopTimeout))
+	}
+
+	if s.isJob() && s.Timeout > 0 {
+		line("TimeoutStartSec", fmt.Sprintf("%d", s.Timeout))
+	}
+
+	for _, r := range rlimits {
+		if v, ok := s.Rlimits[r.name]; ok {
+			line(unitRlimits[r.name], strings.Replace(formatRlimit(v), rlimitUnlimited, "infinity", 1))
+		}
+	}
+
+	if s.CPUQuota > 0 {
+		line("CPUQuota", fmt.Sprintf("%d%%", s.CPUQuota))
+	}
+
+	line("MemoryMax", s.MemoryMax)
+
+	if s.LogFile != "" {
+		line("StandardOutput", "append:"+s.LogFile)
+		line("StandardError", "append:"+s.LogFile)
+		warnings = append(warnings, "the log file isn't rotated by systemd")
+	}
+
+	if s.useHealthCheck() {
+		warnings = append(warnings, "systemd has no health checks, the health check isn't converted")
+	}
+
+	b.WriteString("\n[Install]\n")
+	line("WantedBy", "multi-user.target")
+
+	return b.String(), warnings
+}
+
+// serviceFromUnit creates the service from the unit file.
+// Settings which can't be converted are returned as warnings.
+func serviceFromUnit(name string, content string) (*service, []string, error) {
+	var warnings []string
+
+	sections := ParseUnitFile(content)
+
+	unit := sections["Unit"]
+	svc := sections["Service"]
+	install := sections["Install"]
+
+	if svc == nil {
+		return nil, nil, errors.New("unit has no [Service] section")
+	}
+
+	execStart := svc.Last("ExecStart")
+	if execStart == "" {
+		return nil, nil, errors.New("unit has no ExecStart")
+	}
+
+	if len(svc["ExecStart"]) > 1 {
+		warnings = append(warnings, "only the last ExecStart is imported")
+	}
+
+	// prefixes like "-" or "@" change how systemd runs the command
+	execStart = strings.TrimLeft(execStart, "-@:+!")
+
+	args, err := splitUnitArgs(execStart, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, nil, errors.New("unit has empty ExecStart")
+	}
+
+	s := &service{
+		Name:        strings.TrimSuffix(name, ".service"),
+		Type:        typeService,
+		CMD:         args[0],
+		Args:        args[1:],
+		Dir:         UnescapeUnitValue(strings.TrimPrefix(svc.Last("WorkingDirectory"), "-")),
+		Env:         []string{},
+		Description: UnescapeUnitValue(unit.Last("Description")),
+		User:        svc.Last("User"),
+		Group:       svc.Last("Group"),
+		Umask:       svc.Last("UMask"),
+		Autostart:   len(install["WantedBy"]) > 0 || len(install["RequiredBy"]) > 0,
+	}
+
+	if svc.Last("Type") == "oneshot" {
+		s.Type = typeJob
+	}
+
+	for _, env := range svc["Environment"] {
+		vars, err := splitUnitArgs(env, false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s.Env = append(s.Env, vars...)
+	}
+
+	if len(svc["EnvironmentFile"]) > 0 {
+		warnings = append(warnings, "EnvironmentFile isn't imported")
+	}
+
+	if groups := svc.Last("SupplementaryGroups"); groups != "" {
+		s.Groups = strings.Fields(groups)
+	}
+
+	for policy, v := range unitRestart {
+		if v == svc.Last("Restart") {
+			s.Restart = policy
+		}
+	}
+
+	if restart := svc.Last("Restart"); restart != "" && s.Restart == "" {
+		s.Restart = restartOnFailure
+		warnings = append(warnings, fmt.Sprintf("restart policy [%s] is imported as on-failure", restart))
+	}
+
+	if v, err := strconv.Atoi(strings.TrimSuffix(svc.Last("RestartSec"), "s")); err == nil && v > 0 {
+		s.RestartDelay = v
+	}
+
+	if sig := svc.Last("KillSignal"); sig != "" {
+		if !strings.HasPrefix(sig, "SIG") {
+			sig = "SIG" + sig
+		}
+
+		if _, err := signalByName(sig); err == nil {
+			s.StopSignal = sig
+		}
+	}
+
+	if v, err := strconv.Atoi(strings.TrimSuffix(svc.Last("TimeoutStopSec"), "s")); err == nil && v > 0 {
+		s.StopTimeout = v
+	}
+
+	for key, limit := range unitRlimits {
+		value := svc.Last(limit)
+		if value == "" {
+			continue
+		}
+
+		v, err := parseRlimit(strings.Replace(value, "infinity", rlimitUnlimited, 1))
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s=%s isn't imported", limit, value))
+			continue
+		}
+
+		if s.Rlimits == nil {
+			s.Rlimits = make(map[string]uint64)
+		}
+
+		s.Rlimits[key] = v
+	}
+
+	if quota := svc.Last("CPUQuota"); quota != "" {
+		if v, err := strconv.Atoi(strings.TrimSuffix(quota, "%")); err == nil && v > 0 {
+			s.CPUQuota = v
+		}
+	}
+
+	if memoryMax := svc.Last("MemoryMax"); memoryMax != "" {
+		if _, err := parseMemory(memoryMax); err == nil {
+			s.MemoryMax = memoryMax
+		} else {
+			warnings = append(warnings, fmt.Sprintf("MemoryMax=%s isn't imported", memoryMax))
+		}
+	}
+
+	for _, key := range []string{"ExecStartPre", "ExecStartPost", "ExecStop", "ExecReload"} {
+		if len(svc[key]) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s isn't imported", key))
+		}
+	}
+
+	return s, warnings, nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnitRoundTrip(t *testing.T) {
+	s := &service{
+		Name:        "web",
+		Type:        typeService,
+		Description: `100% "quoted" app`,
+		CMD:         "/opt/my app/bin/run",
+		Args: []string{
+			"--name",
+			"it's",
+			`say "hi"`,
+			`back\slash`,
+			"semi;colon",
+			"",
+			"100%",
+			"%n",
+			"$HOME",
+			"${HOME}$$",
+			"tab\there",
+		},
+		Dir: "/srv/100%",
+		Env: []string{
+			"A=1",
+			"B=two words",
+			`C="quoted"`,
+			"D=100%",
+			`E=back\slash`,
+			"F=$HOME",
+		},
+		Autostart:    true,
+		Restart:      restartAlways,
+		RestartDelay: 5,
+		StopSignal:   "SIGINT",
+		StopTimeout:  10,
+		User:         "www-data",
+		Group:        "www-data",
+		Groups:       []string{"adm", "ssl-cert"},
+		Umask:        "0027",
+		Rlimits:      map[string]uint64{"nofile": 4096},
+		CPUQuota:     50,
+		MemoryMax:    "512M",
+	}
+
+	content, warnings := renderUnit(&PluginSettings{}, s)
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	loaded, warnings, err := serviceFromUnit(unitName(s), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) > 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("loaded service differs from the rendered one\nunit:\n%s\ngot:      %+v\nexpected: %+v", content, loaded, s)
+	}
+}
+
+func TestSplitUnitArgs(t *testing.T) {
+	tests := []struct {
+		line        string
+		commandLine bool
+		args        []string
+	}{
+		{"/bin/true", true, []string{"/bin/true"}},
+		{"  a \t b  ", true, []string{"a", "b"}},
+		{`a "b c" 'd e'`, true, []string{"a", "b c", "d e"}},
+		{`a"b c"d`, true, []string{"ab cd"}},
+		{`a\ b \"c\"`, true, []string{"a b", `"c"`}},
+		{`"" ''`, true, []string{"", ""}},
+		{"100%% $$HOME", true, []string{"100%", "$HOME"}},
+		{"A=$$B", false, []string{"A=$$B"}},
+	}
+
+	for _, tt := range tests {
+		args, err := splitUnitArgs(tt.line, tt.commandLine)
+		if err != nil {
+			t.Errorf("[%s]: %v", tt.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("[%s]: got %q, expected %q", tt.line, args, tt.args)
+		}
+	}
+
+	for _, line := range []string{`"a`, `'a`, `a\`} {
+		if _, err := splitUnitArgs(line, true); err == nil {
+			t.Errorf("[%s] is split without an error", line)
+		}
+	}
+}
+
+func TestUnitDescriptionIsOneLine(t *testing.T) {
+	content, _ := renderUnit(&PluginSettings{}, &service{Name: "multi", CMD: "/bin/true", Description: "first\nsecond"})
+
+	if !strings.Contains(content, "Description=first second\n") {
+		t.Errorf("description isn't joined:\n%s", content)
+	}
+}
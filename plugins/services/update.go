@@ -0,0 +1,331 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// copyConfig copies the settings of the service from src, the runtime state isn't copied.
+func (s *service) copyConfig(src *service) {
+	s.ID = src.ID
+
+	s.Name, s.Type, s.CMD, s.Args, s.Dir, s.Env = src.Name, src.Type, src.CMD, src.Args, src.Dir, src.Env
+	s.Description, s.Autostart, s.KeepRunning = src.Description, src.Autostart, src.KeepRunning
+
+	s.After, s.Requires = src.After, src.Requires
+
+	s.Restart, s.RestartDelay, s.RestartBurst, s.RestartInterval = src.Restart, src.RestartDelay, src.RestartBurst, src.RestartInterval
+
+	s.Schedule, s.Timeout, s.Overlap = src.Schedule, src.Timeout, src.Overlap
+
+	s.StopSignal, s.StopTimeout = src.StopSignal, src.StopTimeout
+
+	s.HealthType, s.HealthTarget, s.HealthStatus = src.HealthType, src.HealthTarget, src.HealthStatus
+	s.HealthInterval, s.HealthTimeout, s.HealthThreshold, s.HealthRestart = src.HealthInterval, src.HealthTimeout, src.HealthThreshold, src.HealthRestart
+
+	s.User, s.Group, s.Groups, s.Umask, s.Rlimits = src.User, src.Group, src.Groups, src.Umask, src.Rlimits
+
+	s.CPUQuota, s.MemoryMax = src.CPUQuota, src.MemoryMax
+
+	s.LogFile, s.LogMaxSize, s.LogMaxFiles = src.LogFile, src.LogMaxSize, src.LogMaxFiles
+}
+
+// updateService applies the changed settings to the service.
+// All settings are validated on a copy before they are applied, so a failed update doesn't change
+// the service, and the settings are applied under s.mx because monitors read them.
+func (p *Plugin) updateService(s *service, sd *serviceUpdateDef) error {
+	var err error
+
+	c := &service{}
+
+	s.mx.Lock()
+	c.copyConfig(s)
+	s.mx.Unlock()
+
+	if sd.Name != nil {
+		if err = p.validateName(*sd.Name, c.ID); err != nil {
+			return err
+		}
+
+		c.Name = *sd.Name
+	}
+
+	if sd.Command != nil {
+		if err = p.validateCommand(*sd.Command); err != nil {
+			return err
+		}
+
+		c.CMD = *sd.Command
+	}
+
+	if unsafe.Pointer(sd.Args) != nil {
+		c.Args = *sd.Args
+	}
+
+	if sd.Dir != nil {
+		if err = p.validateWorkDir(*sd.Dir); err != nil {
+			return err
+		}
+
+		c.Dir = *sd.Dir
+	}
+
+	if sd.Description != nil {
+		c.Description = *sd.Description
+	}
+
+	if sd.Autostart != nil {
+		c.Autostart = *sd.Autostart
+	}
+
+	if sd.KeepRunning != nil {
+		c.KeepRunning = *sd.KeepRunning
+	}
+
+	if sd.Restart != nil {
+		if err = validateRestartPolicy(*sd.Restart); err != nil {
+			return err
+		}
+
+		c.Restart = *sd.Restart
+	}
+
+	for _, v := range []struct {
+		value *string
+		dst   *int
+		err   string
+	}{
+		{sd.RestartDelay, &c.RestartDelay, "incorrect restart delay"},
+		{sd.RestartBurst, &c.RestartBurst, "incorrect restart burst"},
+		{sd.RestartInterval, &c.RestartInterval, "incorrect restart interval"},
+		{sd.StopTimeout, &c.StopTimeout, "incorrect stop timeout"},
+		{sd.HealthStatus, &c.HealthStatus, "incorrect expected status"},
+		{sd.HealthInterval, &c.HealthInterval, "incorrect health check interval"},
+		{sd.HealthTimeout, &c.HealthTimeout, "incorrect health check timeout"},
+		{sd.HealthThreshold, &c.HealthThreshold, "incorrect failure threshold"},
+	} {
+		if v.value == nil {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(*v.value))
+		if err != nil || n <= 0 {
+			return errors.New(v.err)
+		}
+
+		*v.dst = n
+	}
+
+	if sd.StopSignal != nil {
+		if _, err = signalByName(*sd.StopSignal); err != nil {
+			return err
+		}
+
+		c.StopSignal = *sd.StopSignal
+	}
+
+	if sd.Schedule != nil {
+		schedule := strings.TrimSpace(*sd.Schedule)
+
+		if schedule != "" {
+			sc, err := parseSchedule(schedule)
+			if err != nil {
+				return err
+			}
+
+			if sc.next(time.Now()).IsZero() {
+				return errors.New("schedule never matches")
+			}
+		}
+
+		c.Schedule = schedule
+	}
+
+	if sd.Timeout != nil {
+		timeout := 0
+
+		if v := strings.TrimSpace(*sd.Timeout); v != "" {
+			timeout, err = strconv.Atoi(v)
+			if err != nil || timeout <= 0 {
+				return errors.New("incorrect timeout")
+			}
+		}
+
+		c.Timeout = timeout
+	}
+
+	if sd.Overlap != nil {
+		if err = validateOverlapPolicy(*sd.Overlap); err != nil {
+			return err
+		}
+
+		c.Overlap = *sd.Overlap
+	}
+
+	if sd.After != nil {
+		if c.After, err = p.settings.serviceIDs(*sd.After, s); err != nil {
+			return err
+		}
+	}
+
+	if sd.Requires != nil {
+		if c.Requires, err = p.settings.serviceIDs(*sd.Requires, s); err != nil {
+			return err
+		}
+	}
+
+	if sd.After != nil || sd.Requires != nil {
+		if err = p.settings.withService(c).validateDependencies(); err != nil {
+			return err
+		}
+	}
+
+	if sd.HealthType != nil {
+		if err = validateHealthType(*sd.HealthType); err != nil {
+			return err
+		}
+
+		if *sd.HealthType != c.HealthType {
+			c.HealthTarget = ""
+		}
+
+		c.HealthType = *sd.HealthType
+	}
+
+	if sd.HealthTarget != nil {
+		target := strings.TrimSpace(*sd.HealthTarget)
+
+		if err = validateHealthTarget(c.HealthType, target); err != nil {
+			return err
+		}
+
+		c.HealthTarget = target
+	}
+
+	if sd.HealthRestart != nil {
+		c.HealthRestart = *sd.HealthRestart
+	}
+
+	if sd.User != nil {
+		name := strings.TrimSpace(*sd.User)
+
+		if name != "" {
+			if _, err = lookupUser(name); err != nil {
+				return err
+			}
+		}
+
+		c.User = name
+	}
+
+	if sd.Group != nil {
+		name := strings.TrimSpace(*sd.Group)
+
+		if name != "" {
+			if _, err = lookupGroup(name); err != nil {
+				return err
+			}
+		}
+
+		c.Group = name
+	}
+
+	if sd.Groups != nil {
+		for _, g := range *sd.Groups {
+			if _, err = lookupGroup(g); err != nil {
+				return err
+			}
+		}
+
+		c.Groups = *sd.Groups
+	}
+
+	if sd.Umask != nil {
+		umask := strings.TrimSpace(*sd.Umask)
+
+		if _, err = parseUmask(umask); err != nil {
+			return err
+		}
+
+		c.Umask = umask
+	}
+
+	if sd.Env != nil {
+		env, err := parseEnv(*sd.Env)
+		if err != nil {
+			return err
+		}
+
+		c.Env = env
+	}
+
+	if sd.CPUQuota != nil {
+		quota := 0
+
+		if v := strings.TrimSpace(*sd.CPUQuota); v != "" {
+			quota, err = strconv.Atoi(strings.TrimSuffix(v, "%"))
+			if err != nil || quota <= 0 {
+				return errors.New("incorrect CPU quota")
+			}
+		}
+
+		c.CPUQuota = quota
+	}
+
+	if sd.MemoryMax != nil {
+		memoryMax := strings.TrimSpace(*sd.MemoryMax)
+
+		if _, err = parseMemory(memoryMax); err != nil {
+			return err
+		}
+
+		c.MemoryMax = memoryMax
+	}
+
+	if sd.LogFile != nil {
+		if err = p.validateLogFile(*sd.LogFile); err != nil {
+			return err
+		}
+
+		c.LogFile = strings.TrimSpace(*sd.LogFile)
+	}
+
+	if sd.LogMaxSize != nil {
+		v, err := strconv.Atoi(strings.TrimSpace(*sd.LogMaxSize))
+		if err != nil || v <= 0 {
+			return errors.New("incorrect log file size")
+		}
+
+		c.LogMaxSize = v
+	}
+
+	if sd.LogMaxFiles != nil {
+		v, err := strconv.Atoi(strings.TrimSpace(*sd.LogMaxFiles))
+		if err != nil || v <= 0 {
+			return errors.New("incorrect log files count")
+		}
+
+		c.LogMaxFiles = v
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	healthChanged := c.HealthType != s.HealthType
+
+	s.copyConfig(c)
+
+	if healthChanged {
+		s.health = healthState{status: healthStarting}
+	}
+
+	if sd.Schedule != nil {
+		s.scheduleNext(time.Now())
+	}
+
+	return nil
+}
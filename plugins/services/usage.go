@@ -0,0 +1,310 @@
+package services
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	// clock ticks per second of /proc/<pid>/stat times, it is the same on all linux systems
+	clockTicks = 100
+
+	usageInterval = 2 * time.Second
+
+	sortByName    = "name"
+	sortByCPU     = "cpu"
+	sortByMemory  = "memory"
+	sortByFDs     = "fds"
+	sortByThreads = "threads"
+	sortByUptime  = "uptime"
+)
+
+var sortKeys = []struct {
+	key   string
+	title string
+}{
+	{sortByName, "Name"},
+	{sortByCPU, "CPU"},
+	{sortByMemory, "Memory"},
+	{sortByFDs, "Open files"},
+	{sortByThreads, "Threads"},
+	{sortByUptime, "Uptime"},
+}
+
+// resourceUsage is summed over the process tree of the service.
+type resourceUsage struct {
+	cpu     float64
+	rss     uint64
+	fds     int
+	threads int
+
+	// cpu time of the tree in clock ticks at the sample time
+	ticks   uint64
+	sampled time.Time
+}
+
+type procStat struct {
+	pid     int
+	ppid    int
+	pgid    int
+	ticks   uint64
+	threads int
+	start   uint64
+	rss     uint64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	stat := string(data)
+
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, errors.New("incorrect stat format")
+	}
+
+	// fields start from the 3rd field of the stat (state)
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 22 {
+		return nil, errors.New("incorrect stat format")
+	}
+
+	num := func(i int) uint64 {
+		v, _ := strconv.ParseUint(fields[i], 10, 64)
+		return v
+	}
+
+	return &procStat{
+		pid:     pid,
+		ppid:    int(num(1)),
+		pgid:    int(num(2)),
+		ticks:   num(11) + num(12),
+		threads: int(num(17)),
+		start:   num(19),
+		rss:     num(21) * uint64(os.Getpagesize()),
+	}, nil
+}
+
+func readAllProcStats() []*procStat {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var stats []*procStat
+
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		// the process may exit while reading
+		if st, err := readProcStat(pid); err == nil {
+			stats = append(stats, st)
+		}
+	}
+
+	return stats
+}
+
+func countFDs(pid int) int {
+	entries, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0
+	}
+
+	return len(entries)
+}
+
+// processTree returns the process with all its descendants and members of its process group.
+func processTree(pid int, stats []*procStat) []*procStat {
+	children := map[int][]*procStat{}
+	for _, st := range stats {
+		children[st.ppid] = append(children[st.ppid], st)
+	}
+
+	var tree []*procStat
+
+	added := map[int]bool{}
+
+	var add func(st *procStat)
+	add = func(st *procStat) {
+		if added[st.pid] {
+			return
+		}
+
+		added[st.pid] = true
+		tree = append(tree, st)
+
+		for _, c := range children[st.pid] {
+			add(c)
+		}
+	}
+
+	for _, st := range stats {
+		if st.pid == pid || st.pgid == pid {
+			add(st)
+		}
+	}
+
+	return tree
+}
+
+// sampleUsage updates the usage of the service, cpu is computed from the previous sample.
+// s.mx must be held.
+func (s *service) sampleUsage(stats []*procStat) {
+	pr := s.process
+	if pr == nil {
+		s.usage = resourceUsage{}
+		return
+	}
+
+	u := resourceUsage{sampled: time.Now()}
+
+	for _, st := range processTree(pr.Pid, stats) {
+		u.ticks += st.ticks
+		u.rss += st.rss
+		u.threads += st.threads
+		u.fds += countFDs(st.pid)
+	}
+
+	prev := s.usage
+	if !prev.sampled.IsZero() && u.ticks >= prev.ticks {
+		if d := u.sampled.Sub(prev.sampled).Seconds(); d > 0 {
+			u.cpu = float64(u.ticks-prev.ticks) / clockTicks / d * 100
+		}
+	}
+
+	s.usage = u
+}
+
+func (s *service) uptime() time.Duration {
+	if s.process == nil {
+		return 0
+	}
+
+	return time.Since(s.startedAt).Round(time.Second)
+}
+
+func usageLabelID(name string, s *service) string {
+	return fmt.Sprintf("usage-%s-%s", name, s.ID)
+}
+
+// usageLabels returns labels of the usage, they are updated by the usage monitor.
+func usageLabels(s *service) (cpu, rss, fds, threads, uptime *Label) {
+	text := usageText(s)
+
+	return NewLabel("%s", text[0]).SetID(usageLabelID(sortByCPU, s)),
+		NewLabel("%s", text[1]).SetID(usageLabelID(sortByMemory, s)),
+		NewLabel("%s", text[2]).SetID(usageLabelID(sortByFDs, s)),
+		NewLabel("%s", text[3]).SetID(usageLabelID(sortByThreads, s)),
+		NewLabel("%s", text[4]).SetID(usageLabelID(sortByUptime, s))
+}
+
+func usageText(s *service) [5]string {
+	if s.process == nil || s.usage.sampled.IsZero() {
+		return [5]string{}
+	}
+
+	return [5]string{
+		fmt.Sprintf("%.1f%%", s.usage.cpu),
+		FormatBytes(float64(s.usage.rss)),
+		fmt.Sprintf("%d", s.usage.fds),
+		fmt.Sprintf("%d", s.usage.threads),
+		s.uptime().String(),
+	}
+}
+
+func (p *Plugin) sendUsage(s *service, text [5]string) {
+	for i, key := range []string{sortByCPU, sortByMemory, sortByFDs, sortByThreads, sortByUptime} {
+		p.api.SendUpdate(NewUpdateLabel(usageLabelID(key, s), "%s", text[i]))
+	}
+}
+
+func (p *Plugin) runUsageMonitor() {
+	go func() {
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(usageInterval):
+			}
+
+			stats := readAllProcStats()
+
+			for _, s := range p.services() {
+				s.mx.Lock()
+
+				running := s.process != nil || !s.usage.sampled.IsZero()
+
+				s.sampleUsage(stats)
+				text := usageText(s)
+
+				s.mx.Unlock()
+
+				if running {
+					p.sendUsage(s, text)
+				}
+			}
+		}
+	}()
+}
+
+// sortServices returns services sorted by the key, numbers are sorted from the biggest.
+func sortServices(services []*service, key string) []*service {
+	// usage is copied, so services aren't locked while sorting
+	type entry struct {
+		s      *service
+		usage  resourceUsage
+		uptime time.Duration
+	}
+
+	entries := make([]entry, 0, len(services))
+
+	for _, s := range services {
+		s.mx.Lock()
+		entries = append(entries, entry{s: s, usage: s.usage, uptime: s.uptime()})
+		s.mx.Unlock()
+	}
+
+	less := func(a, b entry) bool {
+		switch key {
+		case sortByCPU:
+			return a.usage.cpu > b.usage.cpu
+		case sortByMemory:
+			return a.usage.rss > b.usage.rss
+		case sortByFDs:
+			return a.usage.fds > b.usage.fds
+		case sortByThreads:
+			return a.usage.threads > b.usage.threads
+		case sortByUptime:
+			return a.uptime > b.uptime
+		}
+
+		return key == sortByName && a.s.Name < b.s.Name
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	res := make([]*service, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.s)
+	}
+
+	return res
+}
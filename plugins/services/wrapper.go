@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// The umask and resource limits can't be passed to the fork and changing them in qubert
+// affects all its threads, so a service with them is started by a copy of qubert,
+// which applies them with the credential and execs the command.
+const (
+	wrapperEnv  = "QUBERT_SERVICE_WRAPPER"
+	wrapperPath = "/proc/self/exe"
+)
+
+type wrapperConfig struct {
+	Umask      int                 `json:"umask"`
+	Rlimits    map[string]uint64   `json:"rlimits,omitempty"`
+	Credential *syscall.Credential `json:"credential,omitempty"`
+}
+
+// useWrapper returns true when the service needs limits which are applied by the wrapper.
+func (s *service) useWrapper(umask int) bool {
+	return umask >= 0 || len(s.Rlimits) > 0
+}
+
+// wrapperEnviron returns the environment which starts qubert as the wrapper of the service.
+func (s *service) wrapperEnviron(env []string, umask int, cred *syscall.Credential) ([]string, error) {
+	data, err := json.Marshal(&wrapperConfig{
+		Umask:      umask,
+		Rlimits:    s.Rlimits,
+		Credential: cred,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(env, fmt.Sprintf("%s=%s", wrapperEnv, data)), nil
+}
+
+// RunWrapper execs the command of the service when qubert is started as its wrapper,
+// otherwise it does nothing. It must be called at the start of main.
+func RunWrapper() {
+	data, ok := os.LookupEnv(wrapperEnv)
+	if !ok {
+		return
+	}
+
+	err := execWrapped(data)
+
+	// stderr is the output of the service
+	_, _ = fmt.Fprintf(os.Stderr, "failed to start: %v\n", err)
+
+	os.Exit(127)
+}
+
+func execWrapped(data string) error {
+	cfg := &wrapperConfig{}
+
+	err := json.Unmarshal([]byte(data), cfg)
+	if err != nil {
+		return err
+	}
+
+	err = os.Unsetenv(wrapperEnv)
+	if err != nil {
+		return err
+	}
+
+	// limits are set before the credential, so the hard limits can be raised
+	for name, v := range cfg.Rlimits {
+		def := rlimitByName(name)
+		if def == nil {
+			continue
+		}
+
+		err = syscall.Setrlimit(def.resource, &syscall.Rlimit{Cur: v, Max: v})
+		if err != nil {
+			return errors.Wrapf(err, "failed to set %s limit", name)
+		}
+	}
+
+	if cfg.Umask >= 0 {
+		syscall.Umask(cfg.Umask)
+	}
+
+	if cred := cfg.Credential; cred != nil {
+		groups := make([]int, 0, len(cred.Groups))
+		for _, g := range cred.Groups {
+			groups = append(groups, int(g))
+		}
+
+		err = syscall.Setgroups(groups)
+		if err != nil {
+			return errors.Wrap(err, "failed to set groups")
+		}
+
+		err = syscall.Setgid(int(cred.Gid))
+		if err != nil {
+			return errors.Wrap(err, "failed to set group")
+		}
+
+		err = syscall.Setuid(int(cred.Uid))
+		if err != nil {
+			return errors.Wrap(err, "failed to set user")
+		}
+	}
+
+	// the command is the first argument like for the direct start
+	return syscall.Exec(os.Args[0], os.Args, os.Environ())
+}
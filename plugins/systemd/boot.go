@@ -0,0 +1,354 @@
+package systemd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	bootPage = "boot"
+
+	bootSortTime  = "time"
+	bootSortStart = "start"
+	bootSortName  = "name"
+
+	bootMaxUnits = 200
+)
+
+var bootSorts = []string{bootSortTime, bootSortStart, bootSortName}
+
+// bootTimes are boot stages like in systemd-analyze time, in microseconds.
+type bootTimes struct {
+	firmware  uint64
+	loader    uint64
+	kernel    uint64
+	initrd    uint64
+	userspace uint64
+
+	// monotonic time of the userspace start and of the boot finish
+	userspaceStart uint64
+	finish         uint64
+}
+
+// offset returns the time since the userspace start, initrd units are activated before it.
+func (t *bootTimes) offset(monotonic uint64) uint64 {
+	if monotonic < t.userspaceStart {
+		return 0
+	}
+
+	return monotonic - t.userspaceStart
+}
+
+func (t *bootTimes) total() uint64 {
+	return t.firmware + t.loader + t.kernel + t.initrd + t.userspace
+}
+
+// unitTimes are monotonic timestamps of the unit activation.
+type unitTimes struct {
+	name       string
+	activating uint64
+	activated  uint64
+	after      []string
+}
+
+func (u *unitTimes) duration() uint64 {
+	if u.activating == 0 || u.activated < u.activating {
+		return 0
+	}
+
+	return u.activated - u.activating
+}
+
+func usecDuration(v uint64) time.Duration {
+	d := time.Duration(v) * time.Microsecond
+
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond / 10)
+	}
+
+	return d
+}
+
+// managerTimestamp reads the uint64 property of the manager, the value is formatted like "@t 123".
+func (p *Plugin) managerTimestamp(name string) (uint64, error) {
+	v, err := p.dbusConn.GetManagerProperty(name)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(v, "@t "), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "incorrect %s", name)
+	}
+
+	return n, nil
+}
+
+func (p *Plugin) bootTimes() (*bootTimes, error) {
+	values := map[string]uint64{}
+
+	for _, name := range []string{
+		"FirmwareTimestampMonotonic",
+		"LoaderTimestampMonotonic",
+		"InitRDTimestampMonotonic",
+		"UserspaceTimestampMonotonic",
+		"FinishTimestampMonotonic",
+	} {
+		v, err := p.managerTimestamp(name)
+		if err != nil {
+			return nil, err
+		}
+
+		values[name] = v
+	}
+
+	t := &bootTimes{
+		userspaceStart: values["UserspaceTimestampMonotonic"],
+		finish:         values["FinishTimestampMonotonic"],
+	}
+
+	if t.finish == 0 {
+		return nil, errors.New("boot isn't finished yet")
+	}
+
+	// firmware and loader timestamps are counted back from the kernel start
+	if fw, ld := values["FirmwareTimestampMonotonic"], values["LoaderTimestampMonotonic"]; fw > ld {
+		t.firmware = fw - ld
+	}
+
+	t.loader = values["LoaderTimestampMonotonic"]
+
+	if initrd := values["InitRDTimestampMonotonic"]; initrd > 0 {
+		t.kernel = initrd
+		t.initrd = t.userspaceStart - initrd
+	} else {
+		t.kernel = t.userspaceStart
+	}
+
+	t.userspace = t.finish - t.userspaceStart
+
+	return t, nil
+}
+
+// bootCache keeps unit times of the boot, they are read once because the boot data
+// doesn't change after the finish, units activated later aren't rendered anyway.
+type bootCache struct {
+	mx     sync.Mutex
+	finish uint64
+	units  map[string]*unitTimes
+}
+
+// bootUnitTimes returns the cached unit times of the boot, they are read again
+// only if the finish timestamp is changed.
+func (p *Plugin) bootUnitTimes(boot *bootTimes) (map[string]*unitTimes, error) {
+	p.boot.mx.Lock()
+	defer p.boot.mx.Unlock()
+
+	if p.boot.units != nil && p.boot.finish == boot.finish {
+		return p.boot.units, nil
+	}
+
+	times, err := p.unitTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	p.boot.finish, p.boot.units = boot.finish, times
+
+	return times, nil
+}
+
+func (p *Plugin) unitTimes() (map[string]*unitTimes, error) {
+	units, err := p.dbusConn.ListUnitsContext(p.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make(map[string]*unitTimes, len(units))
+
+	for _, u := range units {
+		props, err := p.dbusConn.GetUnitPropertiesContext(p.ctx, u.Name)
+		if err != nil {
+			continue
+		}
+
+		t := &unitTimes{name: u.Name, after: propertyStrings(props, "After")}
+		t.activating, _ = props["InactiveExitTimestampMonotonic"].(uint64)
+		t.activated, _ = props["ActiveEnterTimestampMonotonic"].(uint64)
+
+		times[u.Name] = t
+	}
+
+	return times, nil
+}
+
+// criticalChain follows the After dependencies which were activated last, like systemd-analyze critical-chain.
+func criticalChain(unit string, times map[string]*unitTimes, boot *bootTimes) []*unitTimes {
+	var chain []*unitTimes
+
+	visited := map[string]bool{}
+
+	for t := times[unit]; t != nil && !visited[t.name]; {
+		visited[t.name] = true
+		chain = append(chain, t)
+
+		var next *unitTimes
+
+		for _, dep := range t.after {
+			d := times[dep]
+			if d == nil || d.activated == 0 || d.activated > boot.finish {
+				continue
+			}
+
+			if next == nil || d.activated > next.activated {
+				next = d
+			}
+		}
+
+		t = next
+	}
+
+	return chain
+}
+
+func sortUnitTimes(units []*unitTimes, by string) {
+	sort.Slice(units, func(i, j int) bool {
+		switch by {
+		case bootSortStart:
+			return units[i].activating < units[j].activating
+		case bootSortName:
+			return units[i].name < units[j].name
+		}
+
+		return units[i].duration() > units[j].duration()
+	})
+}
+
+func (p *Plugin) renderBoot(sortBy string) Page {
+	back := NewLine(NewButton("Back", "open").SetImage("arrow-left-short"))
+
+	if err := p.connected(); err != nil {
+		return NewPage("Boot", back, NewText(err.Error()))
+	}
+
+	boot, err := p.bootTimes()
+	if err != nil {
+		return NewPage("Boot", back, NewText(err.Error()))
+	}
+
+	times, err := p.bootUnitTimes(boot)
+	if err != nil {
+		return NewPage("Boot", back, NewText(err.Error()))
+	}
+
+	stages := NewElementsList().SetModeLine()
+	for _, stage := range []struct {
+		title string
+		value uint64
+	}{
+		{"Firmware", boot.firmware},
+		{"Loader", boot.loader},
+		{"Kernel", boot.kernel},
+		{"Initrd", boot.initrd},
+		{"Userspace", boot.userspace},
+		{"Total", boot.total()},
+	} {
+		if stage.value > 0 {
+			stages.AddElementWithTitle(NewLabel(stage.title).SetStrong(true), NewLabel("%s", usecDuration(stage.value)))
+		}
+	}
+
+	// blame contains units activated during the boot
+	var blame []*unitTimes
+	for _, t := range times {
+		if t.duration() > 0 && t.activated <= boot.finish {
+			blame = append(blame, t)
+		}
+	}
+
+	sortUnitTimes(blame, sortBy)
+
+	if len(blame) > bootMaxUnits {
+		blame = blame[:bootMaxUnits]
+	}
+
+	sortSelect := NewSelectEdit("sort", "boot-sort").SetValue(sortBy)
+	for _, s := range bootSorts {
+		sortSelect.AddOption(s)
+	}
+
+	table := NewTable("Unit", "Time", "Activated", "Timeline")
+
+	for _, t := range blame {
+		table.AddLine(
+			unitLink(t.name),
+			NewLabel("%s", usecDuration(t.duration())),
+			NewLabel("+%s", usecDuration(boot.offset(t.activated))),
+			NewProgress(uint(boot.offset(t.activated))).SetMax(uint(boot.userspace)),
+		)
+	}
+
+	chain := NewElementsList()
+
+	defaultTarget := "default.target"
+	if props, err := p.dbusConn.GetUnitPropertiesContext(p.ctx, defaultTarget); err == nil {
+		if id := propertyString(props, "Id"); id != "" {
+			defaultTarget = id
+		}
+	}
+
+	for i, t := range criticalChain(defaultTarget, times, boot) {
+		line := NewLine(
+			NewLabel("%s└─", strings.Repeat("\u2003", i)),
+			unitLink(t.name),
+			NewLabel("@%s", usecDuration(boot.offset(t.activated))),
+		)
+
+		if d := t.duration(); d > 0 {
+			line.Add(NewBadge(fmt.Sprintf("+%s", usecDuration(d))).SetStyle(StyleDanger))
+		}
+
+		chain.AddElements(line)
+	}
+
+	return NewPage("Boot",
+		back,
+		NewHeader("Boot time"),
+		stages,
+		NewHeader("Critical chain"),
+		chain,
+		NewHeader("Units"),
+		NewElementsList().SetModeLine().AddElementWithTitle(NewLabel("Sort by").SetStrong(true), sortSelect),
+		table,
+	)
+}
+
+func (p *Plugin) bootSortAction(args []string, data io.Reader) ActionResult {
+	req := struct {
+		Sort string `json:"sort"`
+	}{}
+
+	err := json.NewDecoder(data).Decode(&req)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if !oneOf(req.Sort, bootSorts) {
+		return NewErrorAlertActionResult(errors.New("unknown sort"))
+	}
+
+	return NewSetArgsActionResult(false, bootPage, req.Sort)
+}
@@ -0,0 +1,379 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const overrideName = "override.conf"
+
+// unitFile is a unit file or a drop-in with its content.
+type unitFile struct {
+	path    string
+	content string
+}
+
+func overridePath(name string) string {
+	return filepath.Join(unitDir, name+".d", overrideName)
+}
+
+// loadUnitFiles returns the unit file and its drop-ins in the order systemd applies them.
+func (p *Plugin) loadUnitFiles(name string) (*unitFile, []*unitFile, error) {
+	if err := p.connected(); err != nil {
+		return nil, nil, err
+	}
+
+	fragment, err := p.unitFragmentPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fragment == "" {
+		return nil, nil, errors.New("Unit file not found")
+	}
+
+	content, err := ioutil.ReadFile(fragment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prop, err := p.dbusConn.GetUnitPropertyContext(p.ctx, name, "DropInPaths")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths, _ := prop.Value.Value().([]string)
+
+	dropIns := make([]*unitFile, 0, len(paths))
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dropIns = append(dropIns, &unitFile{path: path, content: string(data)})
+	}
+
+	return &unitFile{path: fragment, content: string(content)}, dropIns, nil
+}
+
+// withOverride replaces the override drop-in by the new content, the empty override is removed.
+func withOverride(name string, dropIns []*unitFile, content string) []*unitFile {
+	path := overridePath(name)
+
+	files := make([]*unitFile, 0, len(dropIns)+1)
+	found := false
+
+	for _, f := range dropIns {
+		if f.path == path {
+			found = true
+
+			if strings.TrimSpace(content) == "" {
+				continue
+			}
+
+			f = &unitFile{path: path, content: content}
+		}
+
+		files = append(files, f)
+	}
+
+	if !found && strings.TrimSpace(content) != "" {
+		files = append(files, &unitFile{path: path, content: content})
+	}
+
+	return files
+}
+
+// checkUnitSyntax checks that the file consists of sections, key value pairs and comments.
+func checkUnitSyntax(f *unitFile) []string {
+	var (
+		errs      []string
+		inSection bool
+		continued bool
+		n         int
+	)
+
+	scanner := bufio.NewScanner(strings.NewReader(f.content))
+	for scanner.Scan() {
+		n++
+		text := strings.TrimSpace(scanner.Text())
+
+		if continued {
+			continued = strings.HasSuffix(text, "\\")
+			continue
+		}
+
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";"):
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") || len(text) < 3 {
+				errs = append(errs, fmt.Sprintf("%s:%d: incorrect section header", f.path, n))
+			}
+
+			inSection = true
+		case !strings.Contains(text, "="):
+			errs = append(errs, fmt.Sprintf("%s:%d: expected key=value", f.path, n))
+		case !inSection:
+			errs = append(errs, fmt.Sprintf("%s:%d: assignment outside of a section", f.path, n))
+		case strings.TrimSpace(text[:strings.Index(text, "=")]) == "":
+			errs = append(errs, fmt.Sprintf("%s:%d: empty key", f.path, n))
+		default:
+			continued = strings.HasSuffix(text, "\\")
+		}
+	}
+
+	return errs
+}
+
+// verifyUnit checks the unit with drop-ins by systemd-analyze verify in a temporary directory.
+// Problems in the checked files are returned as errors, other messages as warnings.
+func verifyUnit(name string, fragment *unitFile, dropIns []*unitFile) (errs []string, warnings []string, err error) {
+	for _, f := range append([]*unitFile{fragment}, dropIns...) {
+		errs = append(errs, checkUnitSyntax(f)...)
+	}
+
+	if len(errs) > 0 {
+		return errs, nil, nil
+	}
+
+	dir, err := ioutil.TempDir("", "qubert-verify")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	// temporary paths are replaced by real ones in messages,
+	// drop-in paths go first as the unit path is their prefix
+	replacer := []string{}
+
+	write := func(path string, f *unitFile) error {
+		replacer = append([]string{path, f.path}, replacer...)
+
+		return ioutil.WriteFile(path, []byte(f.content), 0644)
+	}
+
+	err = write(filepath.Join(dir, name), fragment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(dropIns) > 0 {
+		err = os.Mkdir(filepath.Join(dir, name+".d"), 0755)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	written := map[string]bool{}
+
+	for _, f := range dropIns {
+		base := filepath.Base(f.path)
+
+		// a drop-in with the same name in a directory with higher priority hides others
+		if written[base] {
+			continue
+		}
+
+		written[base] = true
+
+		err = write(filepath.Join(dir, name+".d", base), f)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var output bytes.Buffer
+
+	cmd := exec.Command("systemd-analyze", "verify", filepath.Join(dir, name))
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	runErr := cmd.Run()
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return nil, nil, errors.Wrap(runErr, "unit can't be verified")
+	}
+
+	r := strings.NewReplacer(replacer...)
+
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, dir) {
+			errs = append(errs, r.Replace(line))
+		} else {
+			warnings = append(warnings, r.Replace(line))
+		}
+	}
+
+	if runErr != nil && len(errs) == 0 {
+		errs = append(errs, fmt.Sprintf("verification failed: %v", runErr))
+	}
+
+	return errs, warnings, nil
+}
+
+// mergedUnit renders the unit with drop-ins like systemctl cat.
+func mergedUnit(fragment *unitFile, dropIns []*unitFile) string {
+	var b strings.Builder
+
+	for i, f := range append([]*unitFile{fragment}, dropIns...) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(fmt.Sprintf("# %s\n%s", f.path, f.content))
+
+		if !strings.HasSuffix(f.content, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+func currentOverride(name string, dropIns []*unitFile) string {
+	for _, f := range dropIns {
+		if f.path == overridePath(name) {
+			return f.content
+		}
+	}
+
+	return ""
+}
+
+func renderOverrideForm(name string, content string, fragment *unitFile, dropIns []*unitFile, errs []string, warnings []string, checked bool) *Form {
+	form := NewForm().
+		AddWithTitle(fmt.Sprintf("Drop-in %s", overridePath(name)), NewCodeEditor("data", content))
+
+	if checked {
+		messages := NewLogView("override-messages", len(errs)+len(warnings)+1)
+
+		for _, e := range errs {
+			messages.AddLines(&LogLine{Stream: "error", Text: e, Style: StyleDanger})
+		}
+
+		for _, w := range warnings {
+			messages.AddLines(&LogLine{Stream: "warning", Text: w, Style: StyleWarning})
+		}
+
+		if len(errs) == 0 {
+			messages.AddLines(&LogLine{Stream: "ok", Text: "unit is valid", Style: StyleSuccess})
+		}
+
+		diff := DiffLines(currentOverride(name, dropIns), content)
+
+		form.Add(
+			NewLabel("Verification").SetStrong(true),
+			messages,
+			NewLabel("Changes").SetStrong(true),
+			NewLogView("override-diff", len(diff)+1).AddLines(diff...),
+		)
+	}
+
+	merged := strings.Split(strings.TrimSuffix(mergedUnit(fragment, withOverride(name, dropIns, content)), "\n"), "\n")
+
+	mergedView := NewLogView("override-merged", len(merged))
+	for _, line := range merged {
+		mergedView.AddLines(&LogLine{Text: line})
+	}
+
+	form.Add(NewLabel("Merged configuration").SetStrong(true), mergedView)
+
+	return form.AddActionButtons(
+		NewButton("Cancel", "none").SetStyle(StyleSecondary),
+		NewButton("Check", "override", "check", name).SetStyle(StyleSecondary),
+		NewButton("Save", "override", "save", name),
+	)
+}
+
+func (p *Plugin) saveOverride(name string, content string) error {
+	path := overridePath(name)
+
+	if strings.TrimSpace(content) == "" {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// the empty drop-in directory is left by systemctl revert too
+		_ = os.Remove(filepath.Dir(path))
+	} else {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.dbusConn.ReloadContext(p.ctx)
+}
+
+// overrideAction edits /etc/systemd/system/<unit>.d/override.conf, the edit is verified before saving.
+func (p *Plugin) overrideAction(args []string, data io.Reader) ActionResult {
+	var (
+		action   = args[0]
+		unitName = args[1]
+	)
+
+	fragment, dropIns, err := p.loadUnitFiles(unitName)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	title := fmt.Sprintf("Override %s", unitName)
+
+	if action == "" {
+		content := currentOverride(unitName, dropIns)
+		if content == "" {
+			content = "[Service]\n"
+		}
+
+		return NewFormModalActionResult(title, renderOverrideForm(unitName, content, fragment, dropIns, nil, nil, false))
+	}
+
+	resp := struct {
+		Data string `json:"data"`
+	}{}
+
+	err = json.NewDecoder(data).Decode(&resp)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	errs, warnings, err := verifyUnit(unitName, fragment, withOverride(unitName, dropIns, resp.Data))
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if action == "check" || len(errs) > 0 {
+		return NewFormModalActionResult(title, renderOverrideForm(unitName, resp.Data, fragment, dropIns, errs, warnings, true))
+	}
+
+	err = p.saveOverride(unitName, resp.Data)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
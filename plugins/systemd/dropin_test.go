@@ -0,0 +1,45 @@
+package systemd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckUnitSyntax(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		errs    []string
+	}{
+		{"empty", "", nil},
+		{"valid", "# comment\n; comment\n[Unit]\nDescription=Test\n\n[Service]\nExecStart=/bin/true\nEnvironment=\n", nil},
+		{"continued line", "[Service]\nExecStart=/bin/echo \\\n  a \\\n  b\nUser=nobody\n", nil},
+		{"spaces around", "  [Service]  \n  Type = simple  \n", nil},
+		{"value with equals", "[Service]\nEnvironment=A=1 B=2\n", nil},
+		{"outside of section", "Description=Test\n[Unit]\n", []string{"test.service:1: assignment outside of a section"}},
+		{"no value", "[Unit]\nDescription\n", []string{"test.service:2: expected key=value"}},
+		{"empty key", "[Unit]\n=Test\n", []string{"test.service:2: empty key"}},
+		{"unclosed header", "[Unit\nDescription=Test\n", []string{"test.service:1: incorrect section header"}},
+		{"empty header", "[]\nDescription=Test\n", []string{"test.service:1: incorrect section header"}},
+		{"line numbers after continuation", "[Service]\nExecStart=/bin/echo \\\n  a\nbroken\n", []string{"test.service:4: expected key=value"}},
+		{
+			"several errors",
+			"A=1\n[Unit]\nB\n=C\n",
+			[]string{
+				"test.service:1: assignment outside of a section",
+				"test.service:3: expected key=value",
+				"test.service:4: empty key",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := checkUnitSyntax(&unitFile{path: "test.service", content: tt.content})
+
+			if !reflect.DeepEqual(errs, tt.errs) {
+				t.Errorf("got %q, expected %q", errs, tt.errs)
+			}
+		})
+	}
+}
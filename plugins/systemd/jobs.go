@@ -0,0 +1,112 @@
+package systemd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+var jobResults = map[string]string{
+	"done":       "is done",
+	"canceled":   "is canceled",
+	"timeout":    "reached the timeout",
+	"failed":     "failed, see the journal",
+	"dependency": "failed because a dependency failed",
+	"skipped":    "is skipped",
+}
+
+// runJob starts the systemd job, its result is sent to the caller as an alert.
+// Results of jobs without the caller are logged if they aren't done.
+func (p *Plugin) runJob(operation string, unit string, callerID string, start func(ch chan<- string) (int, error)) error {
+	// the result is sent by the dbus connection under its lock, so the channel is buffered
+	ch := make(chan string, 1)
+
+	_, err := start(ch)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var result string
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case result = <-ch:
+		}
+
+		p.api.Reload()
+
+		description, ok := jobResults[result]
+		if !ok {
+			description = result
+		}
+
+		text := fmt.Sprintf("%s %s: job %s", operation, unit, description)
+
+		if callerID != "" && p.api.SendAlert(fmt.Sprintf("Systemd %s", operation), text, callerID) {
+			return
+		}
+
+		if result != "done" {
+			fmt.Println(text)
+		}
+	}()
+
+	return nil
+}
+
+func (p *Plugin) startUnit(name string, callerID string) error {
+	return p.runJob("start", name, callerID, func(ch chan<- string) (int, error) {
+		return p.dbusConn.StartUnitContext(p.ctx, name, "replace", ch)
+	})
+}
+
+// unitAction runs the job of the unit action like start or restart.
+func (p *Plugin) unitAction(action string, name string, callerID string) error {
+	return p.runJob(action, name, callerID, func(ch chan<- string) (int, error) {
+		switch action {
+		case "start":
+			return p.dbusConn.StartUnitContext(p.ctx, name, "fail", ch)
+		case "stop":
+			return p.dbusConn.StopUnitContext(p.ctx, name, "fail", ch)
+		case "restart":
+			return p.dbusConn.RestartUnitContext(p.ctx, name, "fail", ch)
+		case "try-restart":
+			return p.dbusConn.TryRestartUnitContext(p.ctx, name, "fail", ch)
+		case "reload":
+			return p.dbusConn.ReloadUnitContext(p.ctx, name, "fail", ch)
+		}
+
+		return 0, errors.Errorf("unknown action [%s]", action)
+	})
+}
+
+func (p *Plugin) renderFailedUnits() Element {
+	units, err := p.dbusConn.ListUnitsFilteredContext(p.ctx, []string{"failed"})
+	if err != nil {
+		return NewText(err.Error())
+	}
+
+	if len(units) == 0 {
+		return NewLine(NewBadge("no failed units").SetStyle(StyleSuccess))
+	}
+
+	table := NewTable("Failed unit", "Status", "Description", "")
+
+	for _, u := range units {
+		table.AddLine(
+			unitLink(u.Name),
+			NewLine(unitStatus(u.Name, u.ActiveState), NewBadge(u.SubState)),
+			NewLabel("%s", u.Description),
+			NewLine(
+				NewButton("Restart", "action", "restart", u.Name).SetImage("arrow-repeat").SetStyle(StyleSecondary),
+				NewButton("Reset", "action", "reset-failed", u.Name).SetStyle(StyleSecondary),
+			),
+		)
+	}
+
+	return table
+}
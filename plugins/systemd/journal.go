@@ -0,0 +1,635 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	journalPage = "journal"
+
+	journalMaxLines = 500
+	// more entries are read when the search is used, they are filtered by qubert
+	journalSearchLines = 5000
+
+	journalFollowBatch = 500 * time.Millisecond
+	journalViewerCheck = 5 * time.Second
+
+	// the page is reloaded on every change of units, so the read entries are rendered again
+	// until they are refreshed by the button or get old, new ones are added by the follower
+	journalCacheTTL = time.Minute
+)
+
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var journalRanges = []struct {
+	value string
+	title string
+	arg   []string
+}{
+	{"", "All time", nil},
+	{"boot", "Current boot", []string{"-b"}},
+	{"15m", "Last 15 minutes", []string{"--since", "-15min"}},
+	{"1h", "Last hour", []string{"--since", "-1h"}},
+	{"6h", "Last 6 hours", []string{"--since", "-6h"}},
+	{"24h", "Last 24 hours", []string{"--since", "-24h"}},
+	{"today", "Today", []string{"--since", "today"}},
+}
+
+// journalFilter is stored in page args: journal, unit, priority, range, search, follow.
+type journalFilter struct {
+	unit     string
+	priority string
+	since    string
+	search   string
+	follow   bool
+}
+
+const journalArgsCount = 6
+
+func parseJournalArgs(args []string) journalFilter {
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+
+		return ""
+	}
+
+	return journalFilter{
+		unit:     arg(1),
+		priority: arg(2),
+		since:    arg(3),
+		search:   arg(4),
+		follow:   arg(5) == "follow",
+	}
+}
+
+func (f journalFilter) args() []string {
+	follow := ""
+	if f.follow {
+		follow = "follow"
+	}
+
+	return []string{journalPage, f.unit, f.priority, f.since, f.search, follow}
+}
+
+// key identifies the filter in the followers and the cache.
+func (f journalFilter) key() string {
+	return strings.Join(f.args(), "\x00")
+}
+
+func (f journalFilter) set(field string, value string) (journalFilter, error) {
+	switch field {
+	case "unit":
+		f.unit = value
+	case "priority":
+		if value != "" {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n >= len(priorityNames) {
+				return f, errors.New("incorrect priority")
+			}
+		}
+
+		f.priority = value
+	case "since":
+		for _, r := range journalRanges {
+			if r.value == value {
+				f.since = value
+				return f, nil
+			}
+		}
+
+		return f, errors.New("incorrect time range")
+	case "search":
+		f.search = strings.TrimSpace(value)
+	case "follow":
+		f.follow = value == "true"
+	default:
+		return f, errors.Errorf("unknown filter [%s]", field)
+	}
+
+	return f, nil
+}
+
+// cmdArgs returns journalctl arguments, the search is applied by qubert.
+func (f journalFilter) cmdArgs(lines int, follow bool) []string {
+	args := []string{"-o", "json", "--no-pager", "-n", fmt.Sprintf("%d", lines)}
+
+	if follow {
+		args = append(args, "-f")
+	}
+
+	if f.unit != "" {
+		args = append(args, "-u", f.unit)
+	}
+
+	if f.priority != "" {
+		args = append(args, "-p", f.priority)
+	}
+
+	if !follow {
+		for _, r := range journalRanges {
+			if r.value == f.since {
+				args = append(args, r.arg...)
+			}
+		}
+	}
+
+	return args
+}
+
+type journalEntry struct {
+	at         time.Time
+	priority   int
+	unit       string
+	identifier string
+	message    string
+}
+
+// journalString decodes the field, binary fields are exported as arrays of bytes.
+func journalString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+
+	var b []byte
+	var ints []int
+	if json.Unmarshal(raw, &ints) == nil {
+		for _, v := range ints {
+			b = append(b, byte(v))
+		}
+	}
+
+	return string(b)
+}
+
+func parseJournalEntry(line []byte) (*journalEntry, error) {
+	fields := map[string]json.RawMessage{}
+
+	err := json.Unmarshal(line, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &journalEntry{
+		priority:   len(priorityNames) - 1,
+		unit:       journalString(fields["_SYSTEMD_UNIT"]),
+		identifier: journalString(fields["SYSLOG_IDENTIFIER"]),
+		message:    journalString(fields["MESSAGE"]),
+	}
+
+	if usec, err := strconv.ParseInt(journalString(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		e.at = time.UnixMicro(usec)
+	}
+
+	if prio, err := strconv.Atoi(journalString(fields["PRIORITY"])); err == nil && prio >= 0 && prio < len(priorityNames) {
+		e.priority = prio
+	}
+
+	return e, nil
+}
+
+func (e *journalEntry) source() string {
+	if e.unit != "" {
+		return e.unit
+	}
+
+	return e.identifier
+}
+
+func (f journalFilter) match(e *journalEntry) bool {
+	return f.search == "" || strings.Contains(strings.ToLower(e.message), strings.ToLower(f.search))
+}
+
+func (f journalFilter) logLine(e *journalEntry) *LogLine {
+	line := &LogLine{
+		Time:   e.at.Format("2006-01-02 15:04:05"),
+		Stream: priorityNames[e.priority],
+		Text:   e.message,
+	}
+
+	if f.unit == "" {
+		line.Text = fmt.Sprintf("%s: %s", e.source(), e.message)
+	}
+
+	switch {
+	case e.priority <= 3:
+		line.Style = StyleDanger
+	case e.priority == 4:
+		line.Style = StyleWarning
+	case e.priority == 5:
+		line.Style = StylePrimary
+	}
+
+	return line
+}
+
+func readJournal(ctx context.Context, f journalFilter) ([]*journalEntry, error) {
+	lines := journalMaxLines
+	if f.search != "" {
+		lines = journalSearchLines
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "journalctl", f.cmdArgs(lines, false)...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+
+		return nil, err
+	}
+
+	var entries []*journalEntry
+
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		e, err := parseJournalEntry(line)
+		if err != nil || !f.match(e) {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	if len(entries) > journalMaxLines {
+		entries = entries[len(entries)-journalMaxLines:]
+	}
+
+	return entries, nil
+}
+
+// journalFollower streams new entries to viewers of the filter,
+// it stops when nobody views the page.
+type journalFollower struct {
+	cancel context.CancelFunc
+}
+
+// journalCache keeps the entries of the filter read by the last render.
+type journalCache struct {
+	entries []*journalEntry
+	readAt  time.Time
+}
+
+type journalFollowers struct {
+	mx        sync.Mutex
+	followers map[string]*journalFollower
+	cache     map[string]*journalCache
+}
+
+// journalEntries returns the cached entries of the filter, journalctl is run only when
+// they are missing or old.
+func (p *Plugin) journalEntries(f journalFilter) ([]*journalEntry, error) {
+	key := f.key()
+
+	p.journal.mx.Lock()
+
+	for k, c := range p.journal.cache {
+		if time.Since(c.readAt) > journalCacheTTL {
+			delete(p.journal.cache, k)
+		}
+	}
+
+	if c, ok := p.journal.cache[key]; ok {
+		p.journal.mx.Unlock()
+		return c.entries, nil
+	}
+
+	p.journal.mx.Unlock()
+
+	entries, err := readJournal(p.ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	p.journal.mx.Lock()
+	defer p.journal.mx.Unlock()
+
+	if p.journal.cache == nil {
+		p.journal.cache = make(map[string]*journalCache)
+	}
+
+	p.journal.cache[key] = &journalCache{entries: entries, readAt: time.Now()}
+
+	return entries, nil
+}
+
+// cacheJournalEntries adds the followed entries to the cache of the filter, so reloads render them.
+func (p *Plugin) cacheJournalEntries(f journalFilter, entries []*journalEntry) {
+	p.journal.mx.Lock()
+	defer p.journal.mx.Unlock()
+
+	c, ok := p.journal.cache[f.key()]
+	if !ok {
+		return
+	}
+
+	// the entries are copied, renders may use the old slice
+	merged := make([]*journalEntry, 0, len(c.entries)+len(entries))
+	merged = append(append(merged, c.entries...), entries...)
+
+	if len(merged) > journalMaxLines {
+		merged = merged[len(merged)-journalMaxLines:]
+	}
+
+	c.entries = merged
+	c.readAt = time.Now()
+}
+
+// dropJournalCache makes the next render of the filter read the journal.
+func (p *Plugin) dropJournalCache(f journalFilter) {
+	p.journal.mx.Lock()
+	defer p.journal.mx.Unlock()
+
+	delete(p.journal.cache, f.key())
+}
+
+func (p *Plugin) follow(f journalFilter) {
+	key := f.key()
+
+	p.journal.mx.Lock()
+	defer p.journal.mx.Unlock()
+
+	if p.journal.followers == nil {
+		p.journal.followers = make(map[string]*journalFollower)
+	}
+
+	if _, ok := p.journal.followers[key]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+
+	p.journal.followers[key] = &journalFollower{cancel: cancel}
+
+	go func() {
+		defer func() {
+			cancel()
+
+			p.journal.mx.Lock()
+			delete(p.journal.followers, key)
+			p.journal.mx.Unlock()
+		}()
+
+		err := p.runFollower(ctx, f)
+		if err != nil && ctx.Err() == nil {
+			fmt.Println(err)
+		}
+	}()
+}
+
+func (p *Plugin) runFollower(ctx context.Context, f journalFilter) error {
+	cmd := exec.CommandContext(ctx, "journalctl", f.cmdArgs(0, true)...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = cmd.Wait() }()
+
+	entries := make(chan *journalEntry, journalMaxLines)
+
+	go func() {
+		defer close(entries)
+
+		readJournalStream(stdout, f, entries)
+	}()
+
+	var (
+		pending    []*journalEntry
+		lastViewed = time.Now()
+		batch      = time.NewTicker(journalFollowBatch)
+	)
+
+	defer batch.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-entries:
+			if !ok {
+				return errors.New("journal follow is stopped")
+			}
+
+			if len(pending) < journalMaxLines {
+				pending = append(pending, e)
+			}
+		case <-batch.C:
+			// an empty update checks that somebody still views the page
+			if len(pending) == 0 && time.Since(lastViewed) < journalViewerCheck {
+				continue
+			}
+
+			lines := make([]*LogLine, 0, len(pending))
+			for _, e := range pending {
+				lines = append(lines, f.logLine(e))
+			}
+
+			if !p.api.SendUpdate(NewUpdateLogView("journal", lines...), f.args()...) {
+				return nil
+			}
+
+			p.cacheJournalEntries(f, pending)
+
+			pending = nil
+			lastViewed = time.Now()
+		}
+	}
+}
+
+func readJournalStream(r io.Reader, f journalFilter, entries chan<- *journalEntry) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		e, err := parseJournalEntry(scanner.Bytes())
+		if err != nil || !f.match(e) {
+			continue
+		}
+
+		entries <- e
+	}
+}
+
+type facet struct {
+	name  string
+	count int
+}
+
+func countFacets(entries []*journalEntry, key func(e *journalEntry) string) []facet {
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[key(e)]++
+	}
+
+	facets := make([]facet, 0, len(counts))
+	for name, count := range counts {
+		facets = append(facets, facet{name, count})
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].count != facets[j].count {
+			return facets[i].count > facets[j].count
+		}
+
+		return facets[i].name < facets[j].name
+	})
+
+	return facets
+}
+
+func (p *Plugin) renderJournal(f journalFilter) Page {
+	filterArgs := f.args()
+
+	title := "Journal"
+	if f.unit != "" {
+		title = fmt.Sprintf("Journal %s", f.unit)
+	}
+
+	prioritySelect := NewSelectEdit("priority", "journal-filter", filterArgs...).
+		AddNamedOption("all", "").
+		SetValue(f.priority)
+	for i, name := range priorityNames {
+		prioritySelect.AddNamedOption(fmt.Sprintf("%d %s", i, name), strconv.Itoa(i))
+	}
+
+	rangeSelect := NewSelectEdit("since", "journal-filter", filterArgs...).SetValue(f.since)
+	for _, r := range journalRanges {
+		rangeSelect.AddNamedOption(r.title, r.value)
+	}
+
+	page := NewPage(title, NewLine(
+		NewButton("Back", "open").SetImage("arrow-left-short"),
+		NewButton("Refresh", "journal-refresh", filterArgs...).SetImage("arrow-clockwise").SetStyle(StyleSecondary),
+	))
+
+	if f.unit != "" {
+		page.AddElements(NewLine(
+			NewButton("All units", "journal-filter", append(filterArgs, "unit", "")...).SetStyle(StyleSecondary),
+		))
+	}
+
+	page.AddElements(
+		NewElementsList().SetModeLine().
+			AddElementWithTitle(NewLabel("Priority").SetStrong(true), prioritySelect).
+			AddElementWithTitle(NewLabel("Time range").SetStrong(true), rangeSelect).
+			AddElementWithTitle(
+				NewLabel("Search").SetStrong(true),
+				NewInputEdit("search", f.search, "journal-filter", filterArgs...),
+			).
+			AddElementWithTitle(
+				NewLabel("Follow").SetStrong(true),
+				NewSwitch("follow").SetAction("journal-filter", filterArgs...).SetValue(f.follow),
+			),
+	)
+
+	entries, err := p.journalEntries(f)
+	if err != nil {
+		page.AddElements(NewText(err.Error()))
+		return page
+	}
+
+	if f.unit == "" {
+		units := NewLine()
+		for i, fc := range countFacets(entries, (*journalEntry).source) {
+			if i == 20 || fc.name == "" {
+				break
+			}
+
+			units.Add(NewButton(fmt.Sprintf("%s (%d)", fc.name, fc.count), "journal-filter",
+				append(filterArgs, "unit", fc.name)...).SetStyle(StyleSecondary))
+		}
+
+		page.AddElements(NewHeader("Units"), units)
+	}
+
+	priorities := NewLine()
+	for _, fc := range countFacets(entries, func(e *journalEntry) string { return strconv.Itoa(e.priority) }) {
+		n, _ := strconv.Atoi(fc.name)
+
+		priorities.Add(NewButton(fmt.Sprintf("%s (%d)", priorityNames[n], fc.count), "journal-filter",
+			append(filterArgs, "priority", fc.name)...).SetStyle(StyleSecondary))
+	}
+
+	logView := NewLogView("journal", journalMaxLines)
+	for _, e := range entries {
+		logView.AddLines(f.logLine(e))
+	}
+
+	page.AddElements(NewHeader("Priorities"), priorities, NewHeader("Entries"), logView)
+
+	if f.follow {
+		p.follow(f)
+	}
+
+	return page
+}
+
+// journalFilterAction changes the filter, the field and the value are passed in args by buttons
+// or in the data by inline editors.
+func (p *Plugin) journalFilterAction(args []string, data io.Reader) ActionResult {
+	f := parseJournalArgs(args)
+
+	var err error
+
+	if len(args) >= journalArgsCount+2 {
+		f, err = f.set(args[journalArgsCount], args[journalArgsCount+1])
+		if err != nil {
+			return NewErrorAlertActionResult(err)
+		}
+
+		return NewSetArgsActionResult(true, f.args()...)
+	}
+
+	values := map[string]interface{}{}
+
+	err = json.NewDecoder(data).Decode(&values)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	for field, v := range values {
+		f, err = f.set(field, fmt.Sprint(v))
+		if err != nil {
+			return NewErrorAlertActionResult(err)
+		}
+	}
+
+	return NewSetArgsActionResult(true, f.args()...)
+}
+
+// journalRefreshAction reads the journal again, reloads of the page render the cached entries.
+func (p *Plugin) journalRefreshAction(args []string, data io.Reader) ActionResult {
+	p.dropJournalCache(parseJournalArgs(args))
+
+	return NewReloadActionResult()
+}
@@ -0,0 +1,399 @@
+package systemd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+// cpuSampleInterval is the interval between CPU usage samples of the unit.
+const cpuSampleInterval = 2 * time.Second
+
+// resourceForm holds limits as they are written in unit files, the empty value keeps the limit.
+type resourceForm struct {
+	CPUQuota   string `json:"cpu-quota"`
+	MemoryMax  string `json:"memory-max"`
+	MemoryHigh string `json:"memory-high"`
+	IOWeight   string `json:"io-weight"`
+	TasksMax   string `json:"tasks-max"`
+	Persistent bool   `json:"persistent"`
+}
+
+func isInfinity(v string) bool {
+	return v == "infinity" || v == "max"
+}
+
+// parseCPUQuota converts the quota like 50% into CPUQuotaPerSecUSec.
+func parseCPUQuota(v string) (uint64, error) {
+	if isInfinity(v) {
+		return propertyUnset, nil
+	}
+
+	percent, err := strconv.ParseUint(strings.TrimSuffix(v, "%"), 10, 64)
+	if err != nil || !strings.HasSuffix(v, "%") || percent == 0 {
+		return 0, errors.New("CPU quota must be a percent like 50% or infinity")
+	}
+
+	if percent > math.MaxUint64/10000 {
+		return 0, errors.New("CPU quota is too big")
+	}
+
+	// 1% is 10ms of CPU time per second
+	return percent * 10000, nil
+}
+
+// parseSize parses the size like 512M with base 1024 suffixes or infinity.
+func parseSize(v string) (uint64, error) {
+	if isInfinity(v) {
+		return propertyUnset, nil
+	}
+
+	return ParseSize(v)
+}
+
+func parseIOWeight(v string) (uint64, error) {
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n < 1 || n > 10000 {
+		return 0, errors.New("IO weight must be from 1 to 10000")
+	}
+
+	return n, nil
+}
+
+func parseTasksMax(v string) (uint64, error) {
+	if isInfinity(v) {
+		return propertyUnset, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n == 0 {
+		return 0, errors.New("tasks limit must be a number or infinity")
+	}
+
+	return n, nil
+}
+
+// properties converts the form into unit properties and returns errors of the form fields.
+func (f *resourceForm) properties() ([]dbus.Property, formErrors) {
+	var (
+		props []dbus.Property
+		errs  = formErrors{}
+	)
+
+	for _, field := range []struct {
+		name     string
+		value    string
+		property string
+		parse    func(string) (uint64, error)
+	}{
+		{"cpu-quota", f.CPUQuota, "CPUQuotaPerSecUSec", parseCPUQuota},
+		{"memory-max", f.MemoryMax, "MemoryMax", parseSize},
+		{"memory-high", f.MemoryHigh, "MemoryHigh", parseSize},
+		{"io-weight", f.IOWeight, "IOWeight", parseIOWeight},
+		{"tasks-max", f.TasksMax, "TasksMax", parseTasksMax},
+	} {
+		value := strings.TrimSpace(field.value)
+		if value == "" {
+			continue
+		}
+
+		v, err := field.parse(value)
+		if err != nil {
+			errs[field.name] = err.Error()
+			continue
+		}
+
+		props = append(props, dbus.Property{Name: field.property, Value: godbus.MakeVariant(v)})
+	}
+
+	return props, errs
+}
+
+func formatCPUQuota(props map[string]interface{}) string {
+	v, ok := propertyCounter(props, "CPUQuotaPerSecUSec")
+	if !ok {
+		return "infinity"
+	}
+
+	return fmt.Sprintf("%d%%", v/10000)
+}
+
+func formatLimit(props map[string]interface{}, name string, bytes bool) string {
+	v, ok := propertyCounter(props, name)
+	if !ok {
+		return "infinity"
+	}
+
+	if bytes {
+		return FormatBytes(float64(v))
+	}
+
+	return strconv.FormatUint(v, 10)
+}
+
+// usageProgress renders the usage against the limit, the limit is unset if it is infinity.
+func usageProgress(current uint64, limit uint64, limitSet bool) Element {
+	if !limitSet || limit == 0 {
+		return NewLabel("no limit")
+	}
+
+	value := current * 100 / limit
+	if value > 100 {
+		value = 100
+	}
+
+	return NewProgress(uint(value))
+}
+
+// cpuSamplers sample CPU usage of units in the background while their pages are viewed,
+// so the page is rendered with the last sample.
+type cpuSamplers struct {
+	mx    sync.Mutex
+	units map[string]*cpuSample
+}
+
+type cpuSample struct {
+	usage float64
+	set   bool
+}
+
+func cpuLabelID(name string) string {
+	return fmt.Sprintf("cpu-usage-%s", name)
+}
+
+func cpuProgressID(name string) string {
+	return fmt.Sprintf("cpu-progress-%s", name)
+}
+
+// cpuProgress returns the usage in percent of the quota, both are in percent of one CPU.
+func cpuProgress(usage float64, quota uint64) uint {
+	value := usage * 100 / float64(quota/10000)
+	if value > 100 {
+		value = 100
+	}
+
+	return uint(value)
+}
+
+// cpuUsage returns the last sample of the unit and starts the sampler if it isn't running.
+func (p *Plugin) cpuUsage(name string) (float64, bool) {
+	p.cpu.mx.Lock()
+	defer p.cpu.mx.Unlock()
+
+	if p.cpu.units == nil {
+		p.cpu.units = make(map[string]*cpuSample)
+	}
+
+	if sample, ok := p.cpu.units[name]; ok {
+		return sample.usage, sample.set
+	}
+
+	p.cpu.units[name] = &cpuSample{}
+
+	go func() {
+		defer func() {
+			p.cpu.mx.Lock()
+			delete(p.cpu.units, name)
+			p.cpu.mx.Unlock()
+		}()
+
+		p.runCPUSampler(name)
+	}()
+
+	return 0, false
+}
+
+// runCPUSampler sends CPU usage of the unit to viewers of its page, it stops when nobody views the page.
+func (p *Plugin) runCPUSampler(name string) {
+	var (
+		prev   uint64
+		prevAt time.Time
+		ticker = time.NewTicker(cpuSampleInterval)
+	)
+
+	defer ticker.Stop()
+
+	for {
+		props, err := p.dbusConn.GetAllPropertiesContext(p.ctx, name)
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+
+		current, ok := propertyCounter(props, "CPUUsageNSec")
+		if ok && !prevAt.IsZero() && current >= prev {
+			usage := float64(current-prev) / float64(now.Sub(prevAt)) * 100
+
+			p.cpu.mx.Lock()
+			*p.cpu.units[name] = cpuSample{usage: usage, set: true}
+			p.cpu.mx.Unlock()
+
+			if !p.api.SendUpdate(NewUpdateLabel(cpuLabelID(name), "%.1f%%", usage), unitPage, name) {
+				return
+			}
+
+			if quota, ok := propertyCounter(props, "CPUQuotaPerSecUSec"); ok && quota >= 10000 {
+				p.api.SendUpdate(NewUpdateProgress(cpuProgressID(name), cpuProgress(usage, quota)), unitPage, name)
+			}
+		} else if !prevAt.IsZero() && !p.api.SendUpdate(NewUpdateLabel(cpuLabelID(name), "n/a"), unitPage, name) {
+			return
+		}
+
+		prev, prevAt = current, now
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderResources renders current usage against limits of the unit with cgroup properties.
+func (p *Plugin) renderResources(name string, props map[string]interface{}) []Element {
+	if _, ok := props["MemoryMax"]; !ok {
+		return nil
+	}
+
+	table := NewTable("Resource", "Usage", "Limit", "")
+
+	cpu := NewLabel("n/a").SetID(cpuLabelID(name))
+
+	var usage float64
+
+	if _, ok := propertyCounter(props, "CPUUsageNSec"); ok {
+		var usageSet bool
+
+		// the usage is measured between samples, it is sent to the page later
+		if usage, usageSet = p.cpuUsage(name); usageSet {
+			cpu = NewLabel("%.1f%%", usage).SetID(cpuLabelID(name))
+		}
+	}
+
+	var cpuLimit Element = NewLabel("no limit")
+	if quota, ok := propertyCounter(props, "CPUQuotaPerSecUSec"); ok && quota >= 10000 {
+		cpuLimit = NewProgress(cpuProgress(usage, quota)).SetID(cpuProgressID(name))
+	}
+
+	table.AddLine(NewLabel("CPU"), cpu, NewLabel(formatCPUQuota(props)), cpuLimit)
+
+	memory, memorySet := propertyCounter(props, "MemoryCurrent")
+
+	memoryText := NewLabel("n/a")
+	if memorySet {
+		memoryText = NewLabel(FormatBytes(float64(memory)))
+	}
+
+	for _, limit := range []string{"MemoryMax", "MemoryHigh"} {
+		v, ok := propertyCounter(props, limit)
+
+		table.AddLine(NewLabel(limit), memoryText, NewLabel(formatLimit(props, limit, true)), usageProgress(memory, v, ok && memorySet))
+	}
+
+	tasks, tasksSet := propertyCounter(props, "TasksCurrent")
+
+	tasksText := NewLabel("n/a")
+	if tasksSet {
+		tasksText = NewLabel("%d", tasks)
+	}
+
+	tasksMax, ok := propertyCounter(props, "TasksMax")
+	table.AddLine(NewLabel("Tasks"), tasksText, NewLabel(formatLimit(props, "TasksMax", false)), usageProgress(tasks, tasksMax, ok && tasksSet))
+
+	ioUsage := NewLabel("n/a")
+	if read, ok := propertyCounter(props, "IOReadBytes"); ok {
+		written, _ := propertyCounter(props, "IOWriteBytes")
+		ioUsage = NewLabel("read %s, written %s", FormatBytes(float64(read)), FormatBytes(float64(written)))
+	}
+
+	ioWeight := "default"
+	if v, ok := propertyCounter(props, "IOWeight"); ok {
+		ioWeight = strconv.FormatUint(v, 10)
+	}
+
+	table.AddLine(NewLabel("IO weight"), ioUsage, NewLabel(ioWeight), NewLabel(""))
+
+	return []Element{
+		NewHeader("Resources"),
+		NewLine(NewButton("Change limits", "resources", "", name).SetImage("speedometer").SetStyle(StyleSecondary)),
+		table,
+	}
+}
+
+func renderResourceForm(name string, f *resourceForm, props map[string]interface{}, errs formErrors) *Form {
+	input := func(field string, value string) *Input {
+		return NewInput(field).SetValue(value).SetErrorText(errs[field])
+	}
+
+	return NewForm().
+		AddWithTitle(fmt.Sprintf("CPUQuota (now %s)", formatCPUQuota(props)), input("cpu-quota", f.CPUQuota)).
+		AddWithTitle(fmt.Sprintf("MemoryMax (now %s)", formatLimit(props, "MemoryMax", true)), input("memory-max", f.MemoryMax)).
+		AddWithTitle(fmt.Sprintf("MemoryHigh (now %s)", formatLimit(props, "MemoryHigh", true)), input("memory-high", f.MemoryHigh)).
+		AddWithTitle(fmt.Sprintf("IOWeight (now %s)", formatLimit(props, "IOWeight", false)), input("io-weight", f.IOWeight)).
+		AddWithTitle(fmt.Sprintf("TasksMax (now %s)", formatLimit(props, "TasksMax", false)), input("tasks-max", f.TasksMax)).
+		AddWithTitle("Keep after reboot", NewSwitch("persistent").SetValue(f.Persistent)).
+		Add(NewText("Empty fields keep current limits, \"infinity\" removes the limit. "+
+			"Runtime limits are applied immediately and reset on reboot.")).
+		AddActionButtons(
+			NewButton("Cancel", "none").SetStyle(StyleSecondary),
+			NewButton("Apply", "resources", "save", name),
+		)
+}
+
+// resourcesAction sets resource limits of the unit by SetUnitProperties without editing unit files.
+func (p *Plugin) resourcesAction(args []string, data io.Reader) ActionResult {
+	var (
+		action   = args[0]
+		unitName = args[1]
+	)
+
+	if err := p.connected(); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	props, err := p.dbusConn.GetAllPropertiesContext(p.ctx, unitName)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	title := fmt.Sprintf("Resource limits of %s", unitName)
+
+	if action != "save" {
+		return NewFormModalActionResult(title, renderResourceForm(unitName, &resourceForm{}, props, nil))
+	}
+
+	f := &resourceForm{}
+
+	err = json.NewDecoder(data).Decode(f)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	properties, errs := f.properties()
+	if len(errs) > 0 {
+		return NewFormModalActionResult(title, renderResourceForm(unitName, f, props, errs))
+	}
+
+	if len(properties) == 0 {
+		return NewReloadActionResult()
+	}
+
+	err = p.dbusConn.SetUnitPropertiesContext(p.ctx, unitName, !f.Persistent, properties...)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
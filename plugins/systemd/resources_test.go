@@ -0,0 +1,29 @@
+package systemd
+
+import "testing"
+
+func TestParseCPUQuota(t *testing.T) {
+	tests := []struct {
+		value string
+		quota uint64
+		ok    bool
+	}{
+		{"50%", 500000, true},
+		{"200%", 2000000, true},
+		{"infinity", propertyUnset, true},
+		{"1844674407370955%", 18446744073709550000, true},
+		{"1844674407370956%", 0, false},
+		{"0%", 0, false},
+		{"50", 0, false},
+		{"%", 0, false},
+		{"-5%", 0, false},
+	}
+
+	for _, tt := range tests {
+		quota, err := parseCPUQuota(tt.value)
+
+		if ok := err == nil; ok != tt.ok || quota != tt.quota {
+			t.Errorf("[%s]: got %d, %v, expected %d", tt.value, quota, err, tt.quota)
+		}
+	}
+}
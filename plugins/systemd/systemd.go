@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -25,11 +26,14 @@ type Plugin struct {
 	ctx      context.Context
 	settings PluginSettings
 
-	dbusConn    *dbus.Conn
-	systendChan chan string
+	dbusConn *dbus.Conn
 
 	statusChan <-chan map[string]*dbus.UnitStatus
 	errChan    <-chan error
+
+	journal journalFollowers
+	cpu     cpuSamplers
+	boot    bootCache
 }
 
 func (p *Plugin) ID() string {
@@ -48,8 +52,6 @@ func (p *Plugin) Run(ctx context.Context, api PluginAPI) error {
 	p.api = api
 	p.ctx = ctx
 
-	p.systendChan = make(chan string, 10)
-
 	go func() {
 		for {
 			select {
@@ -57,10 +59,6 @@ func (p *Plugin) Run(ctx context.Context, api PluginAPI) error {
 				p.api.Reload()
 			case e := <-p.errChan:
 				fmt.Println(e)
-			case <-p.systendChan: // TODO send alert if not done
-				//fmt.Println("q1", q)
-				//p.api.Reload()
-				//fmt.Println("q2")
 			case <-ctx.Done():
 				return
 			}
@@ -82,7 +80,43 @@ func (p *Plugin) Run(ctx context.Context, api PluginAPI) error {
 	return nil
 }
 
+// userActions are the actions available to users other than root: user-action checks the caller itself
+// and the others only navigate, users other than root are always rendered the page of their user manager.
+var userActions = map[string]bool{
+	"user-action":     true,
+	"none":            true,
+	"open":            true,
+	"journal":         true,
+	"journal-filter":  true,
+	"journal-refresh": true,
+	"boot-sort":       true,
+}
+
+// Actions returns the actions, the ones which are not in userActions change or show the system manager,
+// so they are allowed only to root whatever page is rendered for the caller.
 func (p *Plugin) Actions() ActionsMap {
+	actions := p.actions()
+
+	for name, action := range actions {
+		if !userActions[name] {
+			actions[name] = p.rootOnly(action)
+		}
+	}
+
+	return actions
+}
+
+func (p *Plugin) rootOnly(action func(args []string, data io.Reader) ActionResult) func(args []string, data io.Reader) ActionResult {
+	return func(args []string, data io.Reader) ActionResult {
+		if p.api.CallerUserName(CallerID(data)) != "root" {
+			return NewErrorAlertActionResult(errors.New("access denied"))
+		}
+
+		return action(args, data)
+	}
+}
+
+func (p *Plugin) actions() ActionsMap {
 	return ActionsMap{
 		"pin": func(args []string, data io.Reader) ActionResult {
 			serviceName := args[0]
@@ -127,17 +161,10 @@ func (p *Plugin) Actions() ActionsMap {
 				err      error
 			)
 
-			switch action {
-			case "start":
-				_, err = p.dbusConn.StartUnitContext(p.ctx, unitName, "fail", p.systendChan)
-			case "stop":
-				_, err = p.dbusConn.StopUnitContext(p.ctx, unitName, "fail", p.systendChan)
-			case "restart":
-				_, err = p.dbusConn.RestartUnitContext(p.ctx, unitName, "fail", p.systendChan)
-			case "try-restart":
-				_, err = p.dbusConn.TryRestartUnitContext(p.ctx, unitName, "fail", p.systendChan)
-			case "reload":
-				_, err = p.dbusConn.ReloadUnitContext(p.ctx, unitName, "fail", p.systendChan)
+			if action == "reset-failed" {
+				err = p.dbusConn.ResetFailedUnitContext(p.ctx, unitName)
+			} else {
+				err = p.unitAction(action, unitName, CallerID(data))
 			}
 
 			if err != nil {
@@ -151,77 +178,38 @@ func (p *Plugin) Actions() ActionsMap {
 			var (
 				action   = args[0]
 				unitName = args[1]
-				err      error
 			)
 
-			properties, err := p.dbusConn.GetAllPropertiesContext(p.ctx, unitName)
+			fragment, dropIns, err := p.loadUnitFiles(unitName)
 			if err != nil {
 				return NewErrorAlertActionResult(err)
 			}
 
-			if unitFile, ok := properties["FragmentPath"]; ok {
-				if action == "save" {
-					resp := struct {
-						Data string `json:"data"`
-					}{}
-
-					err = json.NewDecoder(data).Decode(&resp)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
-
-					err = ioutil.WriteFile(unitFile.(string), []byte(resp.Data), 0644)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
-
-					err = p.dbusConn.ReloadContext(p.ctx)
-					if err != nil {
-						return NewErrorAlertActionResult(err)
-					}
-
-					return NewReloadActionResult()
-				}
-
-				unitContent, err := ioutil.ReadFile(unitFile.(string))
-				if err != nil {
-					return NewErrorAlertActionResult(err)
-				}
-
-				return NewFormModalActionResult(
-					"Edit unit file",
-					NewForm().
-						AddWithTitle("Unit file content", NewCodeEditor("data", string(unitContent))).
-						AddActionButtons(
-							NewButton("Cancel", "none").SetStyle(StyleSecondary),
-							NewButton("Save", "edit", "save", unitName),
-						),
-				)
+			// vendor units are changed by package upgrades, they are edited by drop-ins
+			if filepath.Dir(fragment.path) != unitDir {
+				return p.overrideAction([]string{"", unitName}, data)
 			}
 
-			return NewErrorAlertActionResult(errors.New("Unit file not found"))
-		},
-
-		"create-service": func(args []string, data io.Reader) ActionResult {
-			action := args[0]
-
 			if action == "save" {
 				resp := struct {
-					Name        string `json:"name"`
-					Exec        string `json:"exec"`
-					Description string `json:"description"`
+					Data string `json:"data"`
 				}{}
 
-				err := json.NewDecoder(data).Decode(&resp)
+				err = json.NewDecoder(data).Decode(&resp)
 				if err != nil {
 					return NewErrorAlertActionResult(err)
 				}
 
-				err = ioutil.WriteFile(
-					fmt.Sprintf("/etc/systemd/system/%s.service", resp.Name),
-					[]byte(NewServiceTemplate(resp.Description, resp.Exec)),
-					0644,
-				)
+				errs, _, err := verifyUnit(unitName, &unitFile{path: fragment.path, content: resp.Data}, dropIns)
+				if err != nil {
+					return NewErrorAlertActionResult(err)
+				}
+
+				if len(errs) > 0 {
+					return NewErrorAlertActionResult(errors.New(strings.Join(errs, "\n")))
+				}
+
+				err = ioutil.WriteFile(fragment.path, []byte(resp.Data), 0644)
 				if err != nil {
 					return NewErrorAlertActionResult(err)
 				}
@@ -235,55 +223,68 @@ func (p *Plugin) Actions() ActionsMap {
 			}
 
 			return NewFormModalActionResult(
-				"New service",
+				"Edit unit file",
 				NewForm().
-					AddWithTitle("Service name", NewInput("name")).
-					AddWithTitle("Exec", NewInput("exec")).
-					AddWithTitle("Description", NewInput("description")).
+					AddWithTitle("Unit file content", NewCodeEditor("data", fragment.content)).
 					AddActionButtons(
 						NewButton("Cancel", "none").SetStyle(StyleSecondary),
-						NewButton("Create", "create-service", "save"),
+						NewButton("Override", "override", "", unitName).SetStyle(StyleSecondary),
+						NewButton("Save", "edit", "save", unitName),
 					),
 			)
 		},
 
-		"none": func(args []string, data io.Reader) ActionResult {
-			return NewReloadActionResult()
-		},
-	}
-}
+		"override": p.overrideAction,
 
-func NewServiceTemplate(description string, exec string) string {
-	tpl := `[Unit]
-Description=%s
+		"unit-properties": p.unitPropertiesAction,
 
-[Service]
-Type=oneshot
-ExecStart=%s
-# ExecStop=
+		"dependency-tree": p.dependencyTreeAction,
 
+		"resources": p.resourcesAction,
 
-[Install]
-WantedBy=multi-user.target`
+		"boot-sort": p.bootSortAction,
 
-	return fmt.Sprintf(tpl, description, exec)
-}
+		"user-action": p.userActionAction,
 
-func AddServiceToTable(table *Table, unit dbus.UnitStatus, action string) {
-	badgesLine := NewLine()
+		"linger": p.lingerAction,
+
+		"select-user": p.selectUserAction,
+
+		"create-service": p.createServiceAction,
 
-	statusBadge := NewBadge(unit.ActiveState)
+		"journal": func(args []string, data io.Reader) ActionResult {
+			f := journalFilter{}
+			if len(args) > 0 {
+				f.unit = args[0]
+			}
+
+			return NewSetArgsActionResult(true, f.args()...)
+		},
 
-	switch unit.ActiveState {
-	case "active":
-		statusBadge.SetStyle(StyleSuccess)
-	case "failed":
-		statusBadge.SetStyle(StyleDanger)
-	case "inactive":
-		statusBadge.SetStyle(StyleSecondary)
+		"journal-filter": p.journalFilterAction,
+
+		"journal-refresh": p.journalRefreshAction,
+
+		"unit-file": p.unitFileAction,
+
+		"create-timer": p.createTimerAction,
+
+		"delete-timer": p.deleteTimerAction,
+
+		"open": func(args []string, data io.Reader) ActionResult {
+			return NewSetArgsActionResult(true, args...)
+		},
+
+		"none": func(args []string, data io.Reader) ActionResult {
+			return NewReloadActionResult()
+		},
 	}
+}
 
-	badgesLine.Add(statusBadge)
+func AddServiceToTable(table *Table, unit dbus.UnitStatus, fileState string, action string) {
+	badgesLine := NewLine()
+
+	badgesLine.Add(unitStatus(unit.Name, unit.ActiveState))
 
 	if unit.LoadState == "loaded" {
 		loadedBadge := NewBadge(unit.LoadState)
@@ -294,24 +295,46 @@ func AddServiceToTable(table *Table, unit dbus.UnitStatus, action string) {
 		badgesLine.Add(NewBadge(unit.SubState).SetStyle(StyleSuccess))
 	}
 
+	if fileState != "" {
+		badgesLine.Add(unitFileStateBadge(fileState))
+	}
+
+	dropdown := NewDropdown().
+		AddItem("play-fill", "Start", "action", "start", unit.Name).
+		AddItem("stop-fill", "Stop", "action", "stop", unit.Name).
+		AddItem("arrow-repeat", "Restart", "action", "restart", unit.Name).
+		AddItem("chat-right-dots", "reload", "action", "reload", unit.Name).
+		AddSeparator().
+		AddItem("lightning-charge-fill", "Try restart", "action", "try-restart", unit.Name).
+		AddSeparator()
+
+	switch fileState {
+	case "masked", "masked-runtime":
+		dropdown.AddItem("eye-fill", "Unmask", "unit-file", unitFileUnmask, unit.Name, "confirm")
+	case "enabled", "enabled-runtime":
+		dropdown.
+			AddItem("moon-fill", "Disable", "unit-file", unitFileDisable, unit.Name, "confirm").
+			AddItem("eye-slash-fill", "Mask", "unit-file", unitFileMask, unit.Name, "confirm")
+	default:
+		dropdown.
+			AddItem("brightness-high-fill", "Enable", "unit-file", unitFileEnable, unit.Name, "confirm").
+			AddItem("eye-slash-fill", "Mask", "unit-file", unitFileMask, unit.Name, "confirm")
+	}
+
+	dropdown.
+		AddSeparator().
+		AddItem("pencil-square", "Edit unit", "edit", "", unit.Name).
+		AddItem("file-earmark-plus", "Override", "override", "", unit.Name).
+		AddItem("speedometer", "Resource limits", "resources", "", unit.Name).
+		AddItem("journal-text", "Journal", "journal", unit.Name).
+		AddDangerItem("trash", "Delete", "unit-file", unitFileDelete, unit.Name, "confirm")
+
 	table.AddLine(
 		NewElementsList().AddElements(
 			NewLine(
-				NewLabel(unit.Name),
+				unitLink(unit.Name),
 				NewButton("", action, unit.Name).SetImage("pin-angle").SetLinkStyle(),
-				NewDropdown().
-					AddItem("play-fill", "Start", "action", "start", unit.Name).
-					AddItem("stop-fill", "Stop", "action", "stop", unit.Name).
-					AddItem("arrow-repeat", "Restart", "action", "restart", unit.Name).
-					AddItem("chat-right-dots", "reload", "action", "reload", unit.Name).
-					AddSeparator().
-					AddItem("lightning-charge-fill", "Try restart", "action", "try-restart", unit.Name).
-					AddSeparator().
-					AddItem("brightness-high-fill", "Enable", "").
-					AddItem("moon-fill", "Disable", "").
-					AddSeparator().
-					AddItem("pencil-square", "Edit unit", "edit", "", unit.Name).
-					AddItem("trash", "Delete", ""),
+				dropdown,
 			),
 			badgesLine,
 		).SetModeLine(),
@@ -319,6 +342,26 @@ func AddServiceToTable(table *Table, unit dbus.UnitStatus, action string) {
 }
 
 func (p *Plugin) Render(args []string) Page {
+	if len(args) > 0 && args[0] == journalPage {
+		return p.renderJournal(parseJournalArgs(args))
+	}
+
+	if len(args) > 0 && args[0] == timersPage {
+		return p.renderTimers()
+	}
+
+	if len(args) > 0 && args[0] == bootPage {
+		sortBy := bootSortTime
+		if len(args) > 1 {
+			sortBy = args[1]
+		}
+
+		return p.renderBoot(sortBy)
+	}
+
+	if len(args) > 1 && args[0] == unitPage {
+		return p.renderUnit(args[1])
+	}
 
 	units, err := p.dbusConn.ListUnitsContext(p.ctx)
 	if err != nil {
@@ -327,12 +370,19 @@ func (p *Plugin) Render(args []string) Page {
 		)
 	}
 
+	fileStates, err := p.unitFileStates()
+	if err != nil {
+		return NewPage("Systemd",
+			NewText(err.Error()),
+		)
+	}
+
 	pinnedServices := NewTable("Pinned services")
 
 	for _, ps := range p.settings.PinedServices {
 		for _, u := range units {
 			if ps == u.Name {
-				AddServiceToTable(pinnedServices, u, "unpin")
+				AddServiceToTable(pinnedServices, u, fileStates[u.Name], "unpin")
 			}
 		}
 	}
@@ -351,11 +401,18 @@ main:
 			}
 		}
 
-		AddServiceToTable(otherServices, u, "pin")
+		AddServiceToTable(otherServices, u, fileStates[u.Name], "pin")
 	}
 
 	return NewPage("Systemd",
-		NewButton("Create service", "create-service", ""),
+		NewLine(
+			NewButton("Create service", "create-service", ""),
+			NewButton("Timers", "open", timersPage).SetImage("clock").SetStyle(StyleSecondary),
+			NewButton("Journal", "journal").SetImage("journal-text").SetStyle(StyleSecondary),
+			NewButton("Boot", "open", bootPage).SetImage("speedometer2").SetStyle(StyleSecondary),
+		),
+		p.renderUserManagers(),
+		p.renderFailedUnits(),
 		pinnedServices,
 		otherServices,
 	)
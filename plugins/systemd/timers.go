@@ -0,0 +1,501 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	timersPage = "timers"
+
+	timerPreviewIterations = 5
+)
+
+type timerInfo struct {
+	name        string
+	service     string
+	next        time.Time
+	last        time.Time
+	result      string
+	activeState string
+}
+
+func usecTime(v interface{}) time.Time {
+	usec, ok := v.(uint64)
+	if !ok || usec == 0 || usec == ^uint64(0) {
+		return time.Time{}
+	}
+
+	return time.UnixMicro(int64(usec))
+}
+
+func (p *Plugin) timers() ([]*timerInfo, error) {
+	if err := p.connected(); err != nil {
+		return nil, err
+	}
+
+	units, err := p.dbusConn.ListUnitsByPatternsContext(p.ctx, nil, []string{"*.timer"})
+	if err != nil {
+		return nil, err
+	}
+
+	timers := make([]*timerInfo, 0, len(units))
+
+	for _, u := range units {
+		t := &timerInfo{
+			name:        u.Name,
+			activeState: u.ActiveState,
+		}
+
+		props, err := p.dbusConn.GetUnitTypePropertiesContext(p.ctx, u.Name, "Timer")
+		if err != nil {
+			return nil, err
+		}
+
+		t.service, _ = props["Unit"].(string)
+		t.result, _ = props["Result"].(string)
+		t.next = usecTime(props["NextElapseUSecRealtime"])
+		t.last = usecTime(props["LastTriggerUSec"])
+
+		timers = append(timers, t)
+	}
+
+	sort.Slice(timers, func(i, j int) bool {
+		return timers[i].name < timers[j].name
+	})
+
+	return timers, nil
+}
+
+// calendarPreview validates the OnCalendar expression by systemd-analyze and returns next elapses.
+func calendarPreview(expr string, iterations int) ([]string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("systemd-analyze", "calendar", fmt.Sprintf("--iterations=%d", iterations), expr)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+
+		return nil, err
+	}
+
+	var next []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(kv[0])
+		if key == "Next elapse" || strings.HasPrefix(key, "Iter. #") {
+			next = append(next, strings.TrimSpace(kv[1]))
+		}
+	}
+
+	if len(next) == 0 {
+		return nil, errors.New("calendar expression never elapses")
+	}
+
+	return next, nil
+}
+
+func validateTimespan(span string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("systemd-analyze", "timespan", span)
+	cmd.Stderr = &stderr
+	cmd.Stdout = ioutil.Discard
+
+	err := cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+type timerForm struct {
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Exec            string `json:"exec"`
+	OnCalendar      string `json:"on-calendar"`
+	OnBootSec       string `json:"on-boot-sec"`
+	Persistent      bool   `json:"persistent"`
+	RandomizedDelay string `json:"randomized-delay"`
+}
+
+// timerFormErrors holds validation errors of the form fields.
+type timerFormErrors map[string]string
+
+func (f *timerForm) trim() {
+	f.Name = strings.TrimSuffix(strings.TrimSpace(f.Name), ".timer")
+	f.Description = strings.TrimSpace(f.Description)
+	f.Exec = strings.TrimSpace(f.Exec)
+	f.OnCalendar = strings.TrimSpace(f.OnCalendar)
+	f.OnBootSec = strings.TrimSpace(f.OnBootSec)
+	f.RandomizedDelay = strings.TrimSpace(f.RandomizedDelay)
+}
+
+// validate checks the form and returns the preview of next elapses,
+// checkNewUnit returns an error when the unit already exists.
+func (f *timerForm) validate(checkNewUnit func(name string) error) ([]string, timerFormErrors) {
+	errs := timerFormErrors{}
+
+	var preview []string
+
+	if err := validateBaseName(f.Name); err != nil {
+		errs["name"] = err.Error()
+	} else {
+		for _, unit := range []string{f.Name + ".timer", f.Name + ".service"} {
+			if err := checkNewUnit(unit); err != nil {
+				errs["name"] = err.Error()
+			}
+		}
+	}
+
+	if !singleLine(f.Description) {
+		errs["description"] = "description must be a single line"
+	}
+
+	switch {
+	case f.Exec == "":
+		errs["exec"] = "command is required"
+	case !strings.HasPrefix(f.Exec, "/"):
+		errs["exec"] = "command must be an absolute path"
+	case !singleLine(f.Exec):
+		errs["exec"] = "command must be a single line"
+	}
+
+	// the values are checked by systemd-analyze, but they are written to the unit as is
+	for field, value := range map[string]string{
+		"on-calendar":      f.OnCalendar,
+		"on-boot-sec":      f.OnBootSec,
+		"randomized-delay": f.RandomizedDelay,
+	} {
+		if !singleLine(value) {
+			errs[field] = "value must be a single line"
+		}
+	}
+
+	if f.OnCalendar == "" && f.OnBootSec == "" {
+		errs["on-calendar"] = "OnCalendar or OnBootSec is required"
+	}
+
+	if f.OnCalendar != "" && errs["on-calendar"] == "" {
+		var err error
+
+		preview, err = calendarPreview(f.OnCalendar, timerPreviewIterations)
+		if err != nil {
+			errs["on-calendar"] = err.Error()
+		}
+	}
+
+	if f.OnBootSec != "" && errs["on-boot-sec"] == "" {
+		if err := validateTimespan(f.OnBootSec); err != nil {
+			errs["on-boot-sec"] = err.Error()
+		}
+	}
+
+	if f.RandomizedDelay != "" && errs["randomized-delay"] == "" {
+		if err := validateTimespan(f.RandomizedDelay); err != nil {
+			errs["randomized-delay"] = err.Error()
+		}
+	}
+
+	return preview, errs
+}
+
+// verify checks the rendered units by systemd-analyze before they are written.
+func (f *timerForm) verify() ([]string, error) {
+	timer, service := f.units()
+
+	var result []string
+
+	for _, u := range []*unitFile{
+		{path: filepath.Join(unitDir, f.Name+".service"), content: service},
+		{path: filepath.Join(unitDir, f.Name+".timer"), content: timer},
+	} {
+		errs, _, err := verifyUnit(filepath.Base(u.path), u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, errs...)
+	}
+
+	return result, nil
+}
+
+func (f *timerForm) units() (timer string, service string) {
+	description := f.Description
+	if description == "" {
+		description = f.Name
+	}
+
+	description = EscapeUnitValue(description)
+
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("[Unit]\nDescription=%s\n\n[Timer]\n", description))
+
+	if f.OnCalendar != "" {
+		b.WriteString(fmt.Sprintf("OnCalendar=%s\n", f.OnCalendar))
+	}
+
+	if f.OnBootSec != "" {
+		b.WriteString(fmt.Sprintf("OnBootSec=%s\n", f.OnBootSec))
+	}
+
+	if f.Persistent {
+		b.WriteString("Persistent=true\n")
+	}
+
+	if f.RandomizedDelay != "" {
+		b.WriteString(fmt.Sprintf("RandomizedDelaySec=%s\n", f.RandomizedDelay))
+	}
+
+	b.WriteString(fmt.Sprintf("Unit=%s.service\n\n[Install]\nWantedBy=timers.target\n", f.Name))
+
+	// the command is run as typed, so specifiers and variables are escaped
+	execStart := strings.ReplaceAll(EscapeUnitValue(f.Exec), "$", "$$")
+
+	// the service has no [Install] section, it is started only by the timer
+	service = fmt.Sprintf("[Unit]\nDescription=%s\n\n[Service]\nType=oneshot\nExecStart=%s\n", description, execStart)
+
+	return b.String(), service
+}
+
+func (p *Plugin) createTimer(f *timerForm, callerID string) error {
+	if err := p.connected(); err != nil {
+		return err
+	}
+
+	timer, service := f.units()
+
+	servicePath, timerPath := filepath.Join(unitDir, f.Name+".service"), filepath.Join(unitDir, f.Name+".timer")
+
+	err := ioutil.WriteFile(servicePath, []byte(service), 0644)
+	if err != nil {
+		_ = os.Remove(servicePath)
+		return err
+	}
+
+	// the service isn't left without the timer which starts it
+	err = ioutil.WriteFile(timerPath, []byte(timer), 0644)
+	if err != nil {
+		_ = os.Remove(timerPath)
+		_ = os.Remove(servicePath)
+		return err
+	}
+
+	err = p.dbusConn.ReloadContext(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = p.dbusConn.EnableUnitFilesContext(p.ctx, []string{f.Name + ".timer"}, false, false)
+	if err != nil {
+		return errors.Wrap(err, "failed to enable timer")
+	}
+
+	err = p.startUnit(f.Name+".timer", callerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to start timer")
+	}
+
+	return nil
+}
+
+func renderTimerForm(f *timerForm, preview []string, errs timerFormErrors) *Form {
+	input := func(name string, value string) *Input {
+		return NewInput(name).SetValue(value).SetErrorText(errs[name])
+	}
+
+	form := NewForm().
+		AddWithTitle("Name", input("name", f.Name)).
+		AddWithTitle("Description", input("description", f.Description)).
+		AddWithTitle("Exec", input("exec", f.Exec)).
+		AddWithTitle("OnCalendar", input("on-calendar", f.OnCalendar)).
+		AddWithTitle("OnBootSec", input("on-boot-sec", f.OnBootSec)).
+		AddWithTitle("Persistent", NewSwitch("persistent").SetValue(f.Persistent)).
+		AddWithTitle("RandomizedDelaySec", input("randomized-delay", f.RandomizedDelay)).
+		Add(NewText("OnCalendar is a calendar expression like \"daily\" or \"Mon..Fri *-*-* 09:00\", " +
+			"time spans are like \"15min\" or \"1h 30s\"."))
+
+	if len(preview) > 0 {
+		list := NewElementsList()
+		for _, next := range preview {
+			list.AddElements(NewLabel("%s", next))
+		}
+
+		form.Add(NewLabel("Next runs").SetStrong(true), list)
+	}
+
+	if general := errs[""]; general != "" {
+		form.Add(NewLabel("%s", general).SetStrong(true))
+	}
+
+	return form.AddActionButtons(
+		NewButton("Cancel", "none").SetStyle(StyleSecondary),
+		NewButton("Preview", "create-timer", "preview").SetStyle(StyleSecondary),
+		NewButton("Create", "create-timer", "save"),
+	)
+}
+
+func (p *Plugin) createTimerAction(args []string, data io.Reader) ActionResult {
+	f := &timerForm{}
+
+	if len(args) == 0 || args[0] == "" {
+		return NewFormModalActionResult("New timer", renderTimerForm(f, nil, nil))
+	}
+
+	err := json.NewDecoder(data).Decode(f)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	f.trim()
+
+	preview, errs := f.validate(p.checkNewUnit)
+
+	if len(errs) == 0 {
+		verifyErrs, err := f.verify()
+		if err != nil {
+			return NewErrorAlertActionResult(err)
+		}
+
+		if len(verifyErrs) > 0 {
+			errs[""] = strings.Join(verifyErrs, "\n")
+		}
+	}
+
+	if args[0] == "preview" || len(errs) > 0 {
+		return NewFormModalActionResult("New timer", renderTimerForm(f, preview, errs))
+	}
+
+	err = p.createTimer(f, CallerID(data))
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
+
+func formatTimerTime(t time.Time) string {
+	if t.IsZero() {
+		return "n/a"
+	}
+
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func timerResultBadge(result string) *Badge {
+	switch result {
+	case "":
+		return NewBadge("n/a").SetStyle(StyleSecondary)
+	case "success":
+		return NewBadge(result).SetStyle(StyleSuccess)
+	}
+
+	return NewBadge(result).SetStyle(StyleDanger)
+}
+
+func (p *Plugin) renderTimers() Page {
+	timers, err := p.timers()
+	if err != nil {
+		return NewPage("Timers", NewText(err.Error()))
+	}
+
+	table := NewTable("Timer", "Service", "Next", "Last", "Result", "")
+
+	for _, t := range timers {
+		stateBadge := NewBadge(t.activeState)
+		if t.activeState == "active" {
+			stateBadge.SetStyle(StyleSuccess)
+		}
+
+		table.AddLine(
+			NewLine(NewLabel(t.name), stateBadge),
+			NewLabel(t.service),
+			NewLabel(formatTimerTime(t.next)),
+			NewLabel(formatTimerTime(t.last)),
+			timerResultBadge(t.result),
+			NewDropdown().
+				AddItem("play-fill", "Start timer", "action", "start", t.name).
+				AddItem("stop-fill", "Stop timer", "action", "stop", t.name).
+				AddItem("lightning-charge-fill", "Run service now", "action", "start", t.service).
+				AddSeparator().
+				AddItem("pencil-square", "Edit timer", "edit", "", t.name).
+				AddItem("journal-text", "Journal", "journal", t.service).
+				AddDangerItem("trash", "Delete timer", "delete-timer", t.name, t.service, "confirm"),
+		)
+	}
+
+	return NewPage("Timers",
+		NewLine(
+			NewButton("Back", "open").SetImage("arrow-left-short"),
+			NewButton("Create timer", "create-timer", ""),
+		),
+		table,
+	)
+}
+
+// deleteTimerAction deletes the timer with the service which is started by it.
+func (p *Plugin) deleteTimerAction(args []string, data io.Reader) ActionResult {
+	var (
+		timerName   = args[0]
+		serviceName = args[1]
+		confirm     = len(args) > 2 && args[2] == "confirm"
+	)
+
+	if confirm {
+		return NewModalActionResult(
+			"Delete timer",
+			NewLabel("Delete %s and %s. Do you sure about this?", timerName, serviceName),
+			NewButton("Delete", "delete-timer", timerName, serviceName).SetStyle(StyleDanger),
+			NewButton("Cancel", "none"),
+		)
+	}
+
+	if err := p.connected(); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	// the timer goes first, so it doesn't start the service being deleted
+	for _, name := range []string{timerName, serviceName} {
+		if name == "" {
+			continue
+		}
+
+		err := p.deleteUnit(name)
+		if err != nil {
+			return NewErrorAlertActionResult(errors.Wrapf(err, "failed to delete %s", name))
+		}
+	}
+
+	return NewReloadActionResult()
+}
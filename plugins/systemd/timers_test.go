@@ -0,0 +1,30 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimerUnitsEscaping(t *testing.T) {
+	f := &timerForm{
+		Name:        "backup",
+		Description: "Backup 100%",
+		Exec:        `/usr/bin/backup --to /srv/%H "$HOME"`,
+		OnCalendar:  "daily",
+	}
+
+	timer, service := f.units()
+
+	for _, line := range []string{
+		"Description=Backup 100%%\n",
+		`ExecStart=/usr/bin/backup --to /srv/%%H "$$HOME"` + "\n",
+	} {
+		if !strings.Contains(service, line) {
+			t.Errorf("service has no %q:\n%s", line, service)
+		}
+	}
+
+	if !strings.Contains(timer, "Description=Backup 100%%\n") {
+		t.Errorf("timer description isn't escaped:\n%s", timer)
+	}
+}
@@ -0,0 +1,281 @@
+package systemd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	unitPage = "unit"
+
+	// the dependency tree is limited, targets may pull hundreds of units
+	dependencyTreeDepth = 3
+	dependencyTreeSize  = 200
+)
+
+// propertyUnset is the value systemd returns for counters which aren't tracked.
+const propertyUnset = ^uint64(0)
+
+var dependencyKinds = []string{"Requires", "Wants", "After", "Before", "RequiredBy", "WantedBy"}
+
+var exitCodes = map[int32]string{
+	1: "exited",
+	2: "killed",
+	3: "dumped",
+}
+
+func propertyString(props map[string]interface{}, name string) string {
+	s, _ := props[name].(string)
+	return s
+}
+
+func propertyStrings(props map[string]interface{}, name string) []string {
+	s, _ := props[name].([]string)
+	return s
+}
+
+// propertyCounter returns the counter and false if it isn't tracked.
+func propertyCounter(props map[string]interface{}, name string) (uint64, bool) {
+	v, ok := props[name].(uint64)
+	return v, ok && v != propertyUnset
+}
+
+func formatTimestamp(props map[string]interface{}, name string) string {
+	return formatTimerTime(usecTime(props[name]))
+}
+
+func unitStateBadge(state string) *Badge {
+	badge := NewBadge(state)
+
+	switch state {
+	case "active":
+		badge.SetStyle(StyleSuccess)
+	case "failed":
+		badge.SetStyle(StyleDanger)
+	case "inactive":
+		badge.SetStyle(StyleSecondary)
+	}
+
+	return badge
+}
+
+// unitStatus returns the state badge, the failed unit leads to its logs.
+func unitStatus(name string, state string) Element {
+	if state == "failed" {
+		return NewButton(state, "journal", name).SetStyle(StyleDanger)
+	}
+
+	return unitStateBadge(state)
+}
+
+func unitLink(name string) *Button {
+	return NewButton(name, "open", unitPage, name).SetLinkStyle()
+}
+
+// renderDependencyTree renders Requires and Wants of the unit recursively like systemctl list-dependencies.
+// Dependencies are read once per walked unit and the states of all the units by one call.
+func (p *Plugin) renderDependencyTree(name string) (*ElementsList, error) {
+	type node struct {
+		name     string
+		depth    int
+		required bool
+	}
+
+	var nodes []node
+
+	visited := map[string]bool{name: true}
+
+	var walk func(name string, depth int)
+	walk = func(name string, depth int) {
+		if depth > dependencyTreeDepth || len(visited) > dependencyTreeSize {
+			return
+		}
+
+		props, err := p.dbusConn.GetUnitPropertiesContext(p.ctx, name)
+		if err != nil {
+			return
+		}
+
+		for _, kind := range []string{"Requires", "Wants"} {
+			for _, dep := range propertyStrings(props, kind) {
+				if visited[dep] {
+					continue
+				}
+
+				visited[dep] = true
+				nodes = append(nodes, node{name: dep, depth: depth, required: kind == "Requires"})
+
+				walk(dep, depth+1)
+			}
+		}
+	}
+
+	walk(name, 1)
+
+	list := NewElementsList()
+
+	if len(nodes) == 0 {
+		return list.AddElements(NewText("The unit has no dependencies")), nil
+	}
+
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, n.name)
+	}
+
+	units, err := p.dbusConn.ListUnitsByNamesContext(p.ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]string, len(units))
+	for _, u := range units {
+		states[u.Name] = u.ActiveState
+	}
+
+	for _, n := range nodes {
+		// required units are marked by the filled circle like in systemctl list-dependencies
+		marker := "○"
+		if n.required {
+			marker = "●"
+		}
+
+		list.AddElements(NewLine(
+			NewLabel("%s%s", strings.Repeat("\u2003", n.depth-1), marker),
+			unitLink(n.name),
+			unitStateBadge(states[n.name]),
+		))
+	}
+
+	return list, nil
+}
+
+// dependencyTreeAction shows the dependency tree on demand, it isn't a part of the unit page
+// because the page is rendered again on every change of the units.
+func (p *Plugin) dependencyTreeAction(args []string, data io.Reader) ActionResult {
+	if err := p.connected(); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	tree, err := p.renderDependencyTree(args[0])
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewModalActionResult(args[0], tree)
+}
+
+func (p *Plugin) renderUnit(name string) Page {
+	if err := p.connected(); err != nil {
+		return NewPage(name, NewText(err.Error()))
+	}
+
+	props, err := p.dbusConn.GetAllPropertiesContext(p.ctx, name)
+	if err != nil {
+		return NewPage(name, NewText(err.Error()))
+	}
+
+	status := NewLine(unitStatus(name, propertyString(props, "ActiveState")), NewBadge(propertyString(props, "SubState")))
+	if fileState := propertyString(props, "UnitFileState"); fileState != "" {
+		status.Add(unitFileStateBadge(fileState))
+	}
+
+	info := NewElementsList().SetModeLine().
+		AddElementWithTitle(NewLabel("Description").SetStrong(true), NewLabel("%s", propertyString(props, "Description"))).
+		AddElementWithTitle(NewLabel("Status").SetStrong(true), status)
+
+	if pid, ok := props["MainPID"].(uint32); ok && pid > 0 {
+		info.AddElementWithTitle(NewLabel("Main PID").SetStrong(true), NewLabel("%d", pid))
+	}
+
+	if v, ok := propertyCounter(props, "MemoryCurrent"); ok {
+		info.AddElementWithTitle(NewLabel("Memory").SetStrong(true), NewLabel(FormatBytes(float64(v))))
+	}
+
+	if v, ok := propertyCounter(props, "CPUUsageNSec"); ok {
+		info.AddElementWithTitle(NewLabel("CPU time").SetStrong(true),
+			NewLabel("%s", time.Duration(v).Round(time.Millisecond)))
+	}
+
+	if v, ok := propertyCounter(props, "TasksCurrent"); ok {
+		info.AddElementWithTitle(NewLabel("Tasks").SetStrong(true), NewLabel("%d", v))
+	}
+
+	info.
+		AddElementWithTitle(NewLabel("Started").SetStrong(true), NewLabel(formatTimestamp(props, "ActiveEnterTimestamp"))).
+		AddElementWithTitle(NewLabel("Stopped").SetStrong(true), NewLabel(formatTimestamp(props, "InactiveEnterTimestamp")))
+
+	if _, ok := props["ExecMainStatus"]; ok {
+		code, _ := props["ExecMainCode"].(int32)
+		exitStatus, _ := props["ExecMainStatus"].(int32)
+
+		exit := "n/a"
+		if c, ok := exitCodes[code]; ok {
+			exit = fmt.Sprintf("%s, status %d (%s)", c, exitStatus, formatTimestamp(props, "ExecMainExitTimestamp"))
+		}
+
+		info.
+			AddElementWithTitle(NewLabel("Last exit").SetStrong(true), NewLabel("%s", exit)).
+			AddElementWithTitle(NewLabel("Result").SetStrong(true), NewLabel("%s", propertyString(props, "Result")))
+	}
+
+	files := NewElementsList().AddElements(NewLabel("%s", propertyString(props, "FragmentPath")))
+	for _, path := range propertyStrings(props, "DropInPaths") {
+		files.AddElements(NewLabel("%s", path))
+	}
+
+	dependencies := NewTable("Dependency", "Units")
+	for _, kind := range dependencyKinds {
+		units := NewLine()
+		for _, dep := range propertyStrings(props, kind) {
+			units.Add(unitLink(dep))
+		}
+
+		dependencies.AddLine(NewLabel(kind).SetStrong(true), units)
+	}
+
+	elements := []Element{
+		NewLine(
+			NewButton("Back", "open").SetImage("arrow-left-short"),
+			NewButton("Start", "action", "start", name).SetImage("play-fill").SetStyle(StyleSecondary),
+			NewButton("Stop", "action", "stop", name).SetImage("stop-fill").SetStyle(StyleSecondary),
+			NewButton("Restart", "action", "restart", name).SetImage("arrow-repeat").SetStyle(StyleSecondary),
+			NewDropdown().
+				AddItem("pencil-square", "Edit unit", "edit", "", name).
+				AddItem("file-earmark-plus", "Override", "override", "", name).
+				AddItem("speedometer", "Resource limits", "resources", "", name).
+				AddItem("journal-text", "Journal", "journal", name).
+				AddItem("diagram-3", "Dependency tree", "dependency-tree", name).
+				AddItem("code-slash", "All properties", "unit-properties", name),
+		),
+		info,
+	}
+
+	elements = append(elements, p.renderResources(name, props)...)
+
+	elements = append(elements,
+		NewHeader("Unit files"),
+		files,
+		NewHeader("Dependencies"),
+		dependencies,
+	)
+
+	return NewPage(name, elements...)
+}
+
+func (p *Plugin) unitPropertiesAction(args []string, data io.Reader) ActionResult {
+	if err := p.connected(); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	props, err := p.dbusConn.GetAllPropertiesContext(p.ctx, args[0])
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewModalActionResult(args[0], NewCodeEditor("properties", jsonDump(props)))
+}
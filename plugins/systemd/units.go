@@ -0,0 +1,343 @@
+package systemd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const unitDir = "/etc/systemd/system"
+
+const (
+	unitFileEnable  = "enable"
+	unitFileDisable = "disable"
+	unitFileMask    = "mask"
+	unitFileUnmask  = "unmask"
+	unitFileDelete  = "delete"
+)
+
+var unitFileOperationTitles = map[string]string{
+	unitFileEnable:  "Enable",
+	unitFileDisable: "Disable",
+	unitFileMask:    "Mask",
+	unitFileUnmask:  "Unmask",
+	unitFileDelete:  "Delete",
+}
+
+// unitNameRe matches the unit name without the type suffix.
+var unitNameRe = regexp.MustCompile(`^[A-Za-z0-9:_.-]+$`)
+
+// unitRefRe matches the full name of the unit which may be referenced in dependencies.
+var unitRefRe = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+\.(service|socket|target|mount|automount|swap|path|timer|slice|scope|device)$`)
+
+// validateBaseName checks the unit name without the suffix, the name is used in the file path.
+func validateBaseName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("name is required")
+	case len(name) > 240:
+		return errors.New("name is too long")
+	case !unitNameRe.MatchString(name) || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-"):
+		return errors.New("name may contain only letters, digits and :_.- and can't start with . or -")
+	}
+
+	return nil
+}
+
+func validateUnitName(name string) error {
+	if !strings.HasSuffix(name, ".service") {
+		return errors.New("unit name must end with .service")
+	}
+
+	return validateBaseName(strings.TrimSuffix(name, ".service"))
+}
+
+func (p *Plugin) connected() error {
+	if p.dbusConn == nil {
+		return errors.New("systemd is not connected")
+	}
+
+	return nil
+}
+
+// InstallUnit writes the unit file to /etc/systemd/system and reloads systemd, the unit is enabled
+// if it is asked, but not started. Existing units are never replaced.
+func (p *Plugin) InstallUnit(name string, content []byte, enable bool) error {
+	if err := p.connected(); err != nil {
+		return err
+	}
+
+	if err := validateUnitName(name); err != nil {
+		return err
+	}
+
+	if err := p.checkNewUnit(name); err != nil {
+		return err
+	}
+
+	err := ioutil.WriteFile(filepath.Join(unitDir, name), content, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = p.dbusConn.ReloadContext(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	if !enable {
+		return nil
+	}
+
+	_, _, err = p.dbusConn.EnableUnitFilesContext(p.ctx, []string{name}, false, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to enable unit")
+	}
+
+	return nil
+}
+
+// StartUnit starts the unit and waits for the job.
+func (p *Plugin) StartUnit(name string) error {
+	if err := p.connected(); err != nil {
+		return err
+	}
+
+	return p.startUnit(name, "")
+}
+
+// ReadUnitFile returns the content of the unit file.
+func (p *Plugin) ReadUnitFile(name string) ([]byte, error) {
+	if err := p.connected(); err != nil {
+		return nil, err
+	}
+
+	prop, err := p.dbusConn.GetUnitPropertyContext(p.ctx, name, "FragmentPath")
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := prop.Value.Value().(string)
+	if !ok || path == "" {
+		return nil, errors.New("Unit file not found")
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// ServiceUnitNames returns names of service unit files.
+func (p *Plugin) ServiceUnitNames() ([]string, error) {
+	if err := p.connected(); err != nil {
+		return nil, err
+	}
+
+	files, err := p.dbusConn.ListUnitFilesByPatternsContext(p.ctx, nil, []string{"*.service"})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		// templates can't be imported without an instance name
+		if name := filepath.Base(f.Path); !strings.HasSuffix(name, "@.service") {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// unitFileStates returns UnitFileState of service units by the unit name.
+func (p *Plugin) unitFileStates() (map[string]string, error) {
+	files, err := p.dbusConn.ListUnitFilesByPatternsContext(p.ctx, nil, []string{"*.service"})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]string, len(files))
+	for _, f := range files {
+		states[filepath.Base(f.Path)] = f.Type
+	}
+
+	return states, nil
+}
+
+func (p *Plugin) unitFragmentPath(name string) (string, error) {
+	prop, err := p.dbusConn.GetUnitPropertyContext(p.ctx, name, "FragmentPath")
+	if err != nil {
+		return "", err
+	}
+
+	path, _ := prop.Value.Value().(string)
+
+	return path, nil
+}
+
+// checkNewUnit returns an error when the unit exists, the file in /etc/systemd/system
+// would also shadow the vendor unit with the same name.
+func (p *Plugin) checkNewUnit(name string) error {
+	if _, err := os.Stat(filepath.Join(unitDir, name)); err == nil {
+		return errors.Errorf("unit %s already exists", name)
+	}
+
+	if p.dbusConn == nil {
+		return nil
+	}
+
+	if fragment, err := p.unitFragmentPath(name); err == nil && fragment != "" {
+		return errors.Errorf("unit %s already exists in %s", name, fragment)
+	}
+
+	return nil
+}
+
+// deleteUnit stops and disables the unit and removes its file with the drop-in directory,
+// only files in /etc/systemd/system are removed, packaged units are masked instead.
+func (p *Plugin) deleteUnit(name string) error {
+	path, err := p.unitFragmentPath(name)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return errors.New("Unit file not found")
+	}
+
+	if filepath.Dir(path) != unitDir {
+		return errors.Errorf("unit file %s isn't in %s, mask the unit instead", path, unitDir)
+	}
+
+	_, err = p.dbusConn.StopUnitContext(p.ctx, name, "replace", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to stop unit")
+	}
+
+	_, err = p.dbusConn.DisableUnitFilesContext(p.ctx, []string{name}, false)
+	if err != nil {
+		return errors.Wrap(err, "failed to disable unit")
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// drop-ins of the removed unit would be applied to a new unit with the same name
+	err = os.RemoveAll(filepath.Join(unitDir, name+".d"))
+	if err != nil {
+		return err
+	}
+
+	err = p.dbusConn.ReloadContext(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	// the failed state of the removed unit is kept by systemd otherwise
+	_ = p.dbusConn.ResetFailedUnitContext(p.ctx, name)
+
+	return nil
+}
+
+func (p *Plugin) changeUnitFile(operation string, name string) error {
+	if err := p.connected(); err != nil {
+		return err
+	}
+
+	var err error
+
+	switch operation {
+	case unitFileEnable:
+		var hasInstall bool
+
+		hasInstall, _, err = p.dbusConn.EnableUnitFilesContext(p.ctx, []string{name}, false, false)
+		if err == nil && !hasInstall {
+			return errors.New("unit has no [Install] section, it can't be enabled")
+		}
+	case unitFileDisable:
+		_, err = p.dbusConn.DisableUnitFilesContext(p.ctx, []string{name}, false)
+	case unitFileMask:
+		_, err = p.dbusConn.MaskUnitFilesContext(p.ctx, []string{name}, false, false)
+	case unitFileUnmask:
+		_, err = p.dbusConn.UnmaskUnitFilesContext(p.ctx, []string{name}, false)
+	case unitFileDelete:
+		return p.deleteUnit(name)
+	default:
+		return errors.Errorf("unknown operation [%s]", operation)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to %s unit", operation)
+	}
+
+	return p.dbusConn.ReloadContext(p.ctx)
+}
+
+func (p *Plugin) unitFileAction(args []string, data io.Reader) ActionResult {
+	if len(args) < 2 {
+		return NewErrorAlertActionResult(errors.New("incorrect arguments"))
+	}
+
+	var (
+		operation = args[0]
+		unitName  = args[1]
+		confirm   = len(args) > 2 && args[2] == "confirm"
+	)
+
+	title, ok := unitFileOperationTitles[operation]
+	if !ok {
+		return NewErrorAlertActionResult(errors.Errorf("unknown operation [%s]", operation))
+	}
+
+	// the name is used in file paths by the delete
+	if err := validateUnitRef(unitName); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if confirm {
+		button := NewButton(title, "unit-file", operation, unitName)
+		if operation != unitFileEnable && operation != unitFileUnmask {
+			button.SetStyle(StyleDanger)
+		}
+
+		return NewModalActionResult(
+			fmt.Sprintf("%s unit", title),
+			NewLabel("%s %s. Do you sure about this?", title, unitName),
+			button,
+			NewButton("Cancel", "none"),
+		)
+	}
+
+	err := p.changeUnitFile(operation, unitName)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
+
+func unitFileStateBadge(state string) *Badge {
+	badge := NewBadge(state)
+
+	switch state {
+	case "enabled", "enabled-runtime":
+		badge.SetStyle(StyleSuccess)
+	case "masked", "masked-runtime", "bad":
+		badge.SetStyle(StyleDanger)
+	case "disabled":
+		badge.SetStyle(StyleSecondary)
+	}
+
+	return badge
+}
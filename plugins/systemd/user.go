@@ -0,0 +1,415 @@
+package systemd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+const (
+	userPage = "user"
+
+	lingerDir      = "/var/lib/systemd/linger"
+	userRuntimeDir = "/run/user"
+)
+
+var userUnitActions = []string{"start", "stop", "restart", "reload", "enable", "disable", "reset-failed"}
+
+var userUnitActionIcons = map[string]string{
+	"start":        "play-fill",
+	"stop":         "stop-fill",
+	"restart":      "arrow-repeat",
+	"reload":       "chat-right-dots",
+	"enable":       "brightness-high-fill",
+	"disable":      "moon-fill",
+	"reset-failed": "x-circle",
+}
+
+// userUnit is the unit of the user manager.
+type userUnit struct {
+	name        string
+	loadState   string
+	activeState string
+	subState    string
+	description string
+	fileState   string
+}
+
+func validateUserName(name string) error {
+	if !accountNameRe.MatchString(name) {
+		return errors.New("incorrect user name")
+	}
+
+	if _, err := user.Lookup(name); err != nil {
+		return errors.Errorf("user [%s] not found", name)
+	}
+
+	return nil
+}
+
+// lingeringUsers returns users whose managers run without sessions.
+func lingeringUsers() ([]string, error) {
+	files, err := ioutil.ReadDir(lingerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	users := make([]string, 0, len(files))
+	for _, f := range files {
+		users = append(users, f.Name())
+	}
+
+	sort.Strings(users)
+
+	return users, nil
+}
+
+func isLingering(name string) bool {
+	_, err := os.Stat(lingerDir + "/" + name)
+	return err == nil
+}
+
+// userManager connects to the private socket of the user manager like systemd connects root
+// to /run/systemd/private, the manager accepts connections of its user and root.
+func (p *Plugin) userManager(name string) (*dbus.Conn, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, errors.Errorf("user [%s] not found", name)
+	}
+
+	socket := filepath.Join(userRuntimeDir, u.Uid, "systemd/private")
+
+	if _, err := os.Stat(socket); err != nil {
+		return nil, errors.New("user manager is not running")
+	}
+
+	return dbus.NewConnection(func() (*godbus.Conn, error) {
+		conn, err := godbus.Dial("unix:path="+socket, godbus.WithContext(p.ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		// the private socket has no bus daemon, so Hello isn't called
+		err = conn.Auth([]godbus.Auth{godbus.AuthExternal(strconv.Itoa(os.Getuid()))})
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	})
+}
+
+func (p *Plugin) userUnits(name string) ([]*userUnit, error) {
+	conn, err := p.userManager(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	loaded, err := conn.ListUnitsByPatternsContext(p.ctx, nil, []string{"*.service"})
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := conn.ListUnitFilesByPatternsContext(p.ctx, nil, []string{"*.service"})
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]string{}
+	for _, f := range files {
+		states[filepath.Base(f.Path)] = f.Type
+	}
+
+	units := make([]*userUnit, 0, len(loaded))
+
+	for _, u := range loaded {
+		units = append(units, &userUnit{
+			name:        u.Name,
+			loadState:   u.LoadState,
+			activeState: u.ActiveState,
+			subState:    u.SubState,
+			description: u.Description,
+			fileState:   states[u.Name],
+		})
+
+		delete(states, u.Name)
+	}
+
+	// units which aren't loaded are listed only as unit files
+	for unitName, state := range states {
+		if !strings.HasSuffix(unitName, "@.service") {
+			units = append(units, &userUnit{name: unitName, activeState: "inactive", fileState: state})
+		}
+	}
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].name < units[j].name
+	})
+
+	return units, nil
+}
+
+// userUnitAction runs the action on the unit of the user manager, jobs are reported by runJob
+// and the connection is kept until the job is finished.
+func (p *Plugin) userUnitAction(userName string, action string, unitName string, callerID string) error {
+	conn, err := p.userManager(userName)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "enable":
+		_, _, err = conn.EnableUnitFilesContext(p.ctx, []string{unitName}, false, false)
+	case "disable":
+		_, err = conn.DisableUnitFilesContext(p.ctx, []string{unitName}, false)
+	case "reset-failed":
+		err = conn.ResetFailedUnitContext(p.ctx, unitName)
+	default:
+		return p.runJob(action, fmt.Sprintf("%s of %s", unitName, userName), callerID, func(ch chan<- string) (int, error) {
+			jobCh := make(chan string, 1)
+
+			id, err := userUnitJob(p.ctx, conn, action, unitName, jobCh)
+			if err != nil {
+				conn.Close()
+				return 0, err
+			}
+
+			go func() {
+				defer conn.Close()
+
+				select {
+				case result := <-jobCh:
+					ch <- result
+				case <-p.ctx.Done():
+				}
+			}()
+
+			return id, nil
+		})
+	}
+
+	defer conn.Close()
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to %s unit", action)
+	}
+
+	if action == "reset-failed" {
+		return nil
+	}
+
+	return conn.ReloadContext(p.ctx)
+}
+
+func userUnitJob(ctx context.Context, conn *dbus.Conn, action string, name string, ch chan<- string) (int, error) {
+	switch action {
+	case "start":
+		return conn.StartUnitContext(ctx, name, "replace", ch)
+	case "stop":
+		return conn.StopUnitContext(ctx, name, "replace", ch)
+	case "restart":
+		return conn.RestartUnitContext(ctx, name, "replace", ch)
+	case "reload":
+		return conn.ReloadUnitContext(ctx, name, "replace", ch)
+	}
+
+	return 0, errors.Errorf("unknown action [%s]", action)
+}
+
+// canManageUser reports whether the caller may manage units of the user, root manages all users.
+func (p *Plugin) canManageUser(callerID string, name string) bool {
+	callerUser := p.api.CallerUserName(callerID)
+
+	return callerUser == "root" || (callerUser != "" && callerUser == name)
+}
+
+func (p *Plugin) userActionAction(args []string, data io.Reader) ActionResult {
+	var (
+		userName = args[0]
+		action   = args[1]
+		unitName = args[2]
+		callerID = CallerID(data)
+	)
+
+	if !p.canManageUser(callerID, userName) {
+		return NewErrorAlertActionResult(errors.New("access denied"))
+	}
+
+	if err := validateUserName(userName); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if !oneOf(action, userUnitActions) {
+		return NewErrorAlertActionResult(errors.Errorf("unknown action [%s]", action))
+	}
+
+	if !unitRefRe.MatchString(unitName) {
+		return NewErrorAlertActionResult(errors.New("incorrect unit name"))
+	}
+
+	// the result of the job is sent as an alert like for system units
+	err := p.userUnitAction(userName, action, unitName, callerID)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
+
+func (p *Plugin) lingerAction(args []string, data io.Reader) ActionResult {
+	var (
+		userName = args[0]
+		enable   = args[1] == "enable"
+	)
+
+	if err := validateUserName(userName); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	command := "disable-linger"
+	if enable {
+		command = "enable-linger"
+	}
+
+	out, err := exec.Command("loginctl", command, userName).CombinedOutput()
+	if err != nil {
+		return NewErrorAlertActionResult(errors.Errorf("%s: %s", err, strings.TrimSpace(string(out))))
+	}
+
+	return NewReloadActionResult()
+}
+
+func (p *Plugin) selectUserAction(args []string, data io.Reader) ActionResult {
+	form := struct {
+		User string `json:"user"`
+	}{}
+
+	if len(args) > 0 {
+		form.User = args[0]
+	} else if err := json.NewDecoder(data).Decode(&form); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if err := validateUserName(form.User); err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewSetArgsActionResult(true, userPage, form.User)
+}
+
+func (p *Plugin) renderUserManager(name string, admin bool) Page {
+	title := fmt.Sprintf("Systemd user %s", name)
+
+	header := NewLine()
+	if admin {
+		header.Add(NewButton("Back", "open").SetImage("arrow-left-short"))
+	}
+
+	if err := validateUserName(name); err != nil {
+		return NewPage(title, header, NewText(err.Error()))
+	}
+
+	if admin {
+		if isLingering(name) {
+			header.Add(NewButton("Disable lingering", "linger", name, "disable").SetStyle(StyleSecondary))
+		} else {
+			header.Add(NewButton("Enable lingering", "linger", name, "enable").SetStyle(StyleSecondary))
+		}
+	}
+
+	units, err := p.userUnits(name)
+	if err != nil {
+		return NewPage(title, header,
+			NewText(err.Error()),
+			NewText("The user manager runs only while the user is logged in or lingering is enabled."),
+		)
+	}
+
+	table := NewTable(fmt.Sprintf("Units of %s", name))
+
+	for _, u := range units {
+		badges := NewLine(unitStateBadge(u.activeState))
+
+		if u.subState == "running" {
+			badges.Add(NewBadge(u.subState).SetStyle(StyleSuccess))
+		}
+
+		if u.fileState != "" {
+			badges.Add(unitFileStateBadge(u.fileState))
+		}
+
+		dropdown := NewDropdown()
+		for _, action := range userUnitActions {
+			dropdown.AddItem(userUnitActionIcons[action], action, "user-action", name, action, u.name)
+		}
+
+		table.AddLine(
+			NewElementsList().AddElements(
+				NewLine(NewLabel(u.name), dropdown),
+				NewLabel("%s", u.description),
+				badges,
+			).SetModeLine(),
+		)
+	}
+
+	return NewPage(title, header, table)
+}
+
+func (p *Plugin) renderUserManagers() Element {
+	users, err := lingeringUsers()
+	if err != nil {
+		return NewText(err.Error())
+	}
+
+	line := NewLine(NewLabel("User managers").SetStrong(true))
+	for _, u := range users {
+		line.Add(NewButton(u, "select-user", u).SetStyle(StyleSecondary))
+	}
+
+	return line.Add(
+		NewInputEdit("user", "", "select-user"),
+	)
+}
+
+// RenderForCaller renders units of the user manager for users other than root,
+// root sees the system manager and may open managers of other users.
+// The system manager isn't rendered for unknown callers, its actions are allowed only to root.
+func (p *Plugin) RenderForCaller(callerID string, args []string) Page {
+	userName := p.api.CallerUserName(callerID)
+
+	switch userName {
+	case "root":
+	case "":
+		return NewPage("Systemd", NewText("access denied"))
+	default:
+		return p.renderUserManager(userName, false)
+	}
+
+	if len(args) > 1 && args[0] == userPage {
+		return p.renderUserManager(args[1], true)
+	}
+
+	return p.Render(args)
+}
@@ -0,0 +1,419 @@
+package systemd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	. "qubert/pluginTools"
+)
+
+var (
+	serviceTypes    = []string{"simple", "exec", "forking", "oneshot", "notify"}
+	restartPolicies = []string{"no", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog", "always"}
+	protectSystem   = []string{"", "true", "full", "strict"}
+	wantedByTargets = []string{"multi-user.target", "graphical.target", "default.target", ""}
+
+	accountNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+	envNameRe     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// serviceForm is the form of the unit creation wizard.
+type serviceForm struct {
+	Name             string `json:"name"`
+	Description      string `json:"description"`
+	Type             string `json:"type"`
+	Exec             string `json:"exec"`
+	User             string `json:"user"`
+	Group            string `json:"group"`
+	WorkingDirectory string `json:"working-directory"`
+	Environment      string `json:"environment"`
+	EnvironmentFile  string `json:"environment-file"`
+	Restart          string `json:"restart"`
+	RestartSec       string `json:"restart-sec"`
+	After            string `json:"after"`
+	Wants            string `json:"wants"`
+	Requires         string `json:"requires"`
+	ProtectSystem    string `json:"protect-system"`
+	PrivateTmp       bool   `json:"private-tmp"`
+	NoNewPrivileges  bool   `json:"no-new-privileges"`
+	WantedBy         string `json:"wanted-by"`
+	Start            bool   `json:"start"`
+}
+
+type formErrors map[string]string
+
+func newServiceForm() *serviceForm {
+	return &serviceForm{
+		Type:     "simple",
+		Restart:  "on-failure",
+		WantedBy: "multi-user.target",
+		Start:    true,
+	}
+}
+
+func (f *serviceForm) trim() {
+	for _, v := range []*string{
+		&f.Description, &f.Exec, &f.User, &f.Group, &f.WorkingDirectory, &f.EnvironmentFile,
+		&f.RestartSec, &f.After, &f.Wants, &f.Requires,
+	} {
+		*v = strings.TrimSpace(*v)
+	}
+
+	f.Name = strings.TrimSuffix(strings.TrimSpace(f.Name), ".service")
+}
+
+func (f *serviceForm) unitName() string {
+	return f.Name + ".service"
+}
+
+func (f *serviceForm) environment() []string {
+	var env []string
+
+	for _, line := range strings.Split(f.Environment, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			env = append(env, line)
+		}
+	}
+
+	return env
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateUnitRef checks the full name of the unit of any type.
+func validateUnitRef(name string) error {
+	if !unitRefRe.MatchString(name) {
+		return errors.Errorf("incorrect unit name [%s]", name)
+	}
+
+	return nil
+}
+
+func validateUnitList(list string) error {
+	for _, name := range strings.Fields(list) {
+		if err := validateUnitRef(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// singleLine reports whether the value can be written as one directive of the unit file.
+func singleLine(value string) bool {
+	return !strings.ContainsAny(value, "\n\r")
+}
+
+// validate checks the form, checkNewUnit returns an error when the unit already exists.
+func (f *serviceForm) validate(checkNewUnit func(name string) error) formErrors {
+	errs := formErrors{}
+
+	if err := validateBaseName(f.Name); err != nil {
+		errs["name"] = err.Error()
+	} else if err := checkNewUnit(f.unitName()); err != nil {
+		errs["name"] = err.Error()
+	}
+
+	if !singleLine(f.Description) {
+		errs["description"] = "description must be a single line"
+	}
+
+	if !oneOf(f.Type, serviceTypes) {
+		errs["type"] = "unknown service type"
+	}
+
+	switch {
+	case f.Exec == "":
+		errs["exec"] = "command is required"
+	case !strings.HasPrefix(f.Exec, "/"):
+		errs["exec"] = "command must be an absolute path"
+	case !singleLine(f.Exec):
+		errs["exec"] = "command must be a single line"
+	}
+
+	if f.User != "" {
+		if !accountNameRe.MatchString(f.User) {
+			errs["user"] = "incorrect user name"
+		} else if _, err := user.Lookup(f.User); err != nil {
+			errs["user"] = "user not found"
+		}
+	}
+
+	if f.Group != "" {
+		if !accountNameRe.MatchString(f.Group) {
+			errs["group"] = "incorrect group name"
+		} else if _, err := user.LookupGroup(f.Group); err != nil {
+			errs["group"] = "group not found"
+		}
+	}
+
+	if f.WorkingDirectory != "" && !filepath.IsAbs(strings.TrimPrefix(f.WorkingDirectory, "-")) {
+		errs["working-directory"] = "working directory must be an absolute path"
+	}
+
+	for _, env := range f.environment() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !envNameRe.MatchString(kv[0]) {
+			errs["environment"] = fmt.Sprintf("incorrect variable [%s], use NAME=value", env)
+			break
+		}
+	}
+
+	if f.EnvironmentFile != "" && !filepath.IsAbs(strings.TrimPrefix(f.EnvironmentFile, "-")) {
+		errs["environment-file"] = "environment file must be an absolute path"
+	}
+
+	if !oneOf(f.Restart, restartPolicies) {
+		errs["restart"] = "unknown restart policy"
+	}
+
+	if f.RestartSec != "" {
+		if err := validateTimespan(f.RestartSec); err != nil {
+			errs["restart-sec"] = err.Error()
+		}
+	}
+
+	for field, list := range map[string]string{"after": f.After, "wants": f.Wants, "requires": f.Requires} {
+		if err := validateUnitList(list); err != nil {
+			errs[field] = err.Error()
+		}
+	}
+
+	if !oneOf(f.ProtectSystem, protectSystem) {
+		errs["protect-system"] = "unknown ProtectSystem value"
+	}
+
+	if !oneOf(f.WantedBy, wantedByTargets) {
+		errs["wanted-by"] = "unknown target"
+	}
+
+	return errs
+}
+
+// quoteEnv quotes the environment assignment which contains spaces or quotes.
+func quoteEnv(env string) string {
+	env = EscapeUnitValue(env)
+
+	if !strings.ContainsAny(env, " \t\"'\\") {
+		return env
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(env) + `"`
+}
+
+// render renders the unit file of the form.
+func (f *serviceForm) render() string {
+	var b strings.Builder
+
+	line := func(key string, value string) {
+		if value != "" {
+			b.WriteString(fmt.Sprintf("%s=%s\n", key, value))
+		}
+	}
+
+	description := f.Description
+	if description == "" {
+		description = f.Name
+	}
+
+	b.WriteString("[Unit]\n")
+	line("Description", EscapeUnitValue(description))
+	line("After", strings.Join(strings.Fields(f.After), " "))
+	line("Wants", strings.Join(strings.Fields(f.Wants), " "))
+	line("Requires", strings.Join(strings.Fields(f.Requires), " "))
+
+	b.WriteString("\n[Service]\n")
+	line("Type", f.Type)
+	line("ExecStart", f.Exec)
+	line("User", f.User)
+	line("Group", f.Group)
+	line("WorkingDirectory", EscapeUnitValue(f.WorkingDirectory))
+
+	for _, env := range f.environment() {
+		line("Environment", quoteEnv(env))
+	}
+
+	line("EnvironmentFile", EscapeUnitValue(f.EnvironmentFile))
+	line("Restart", f.Restart)
+
+	if f.Restart != "no" {
+		line("RestartSec", f.RestartSec)
+	}
+
+	line("ProtectSystem", f.ProtectSystem)
+
+	if f.PrivateTmp {
+		line("PrivateTmp", "true")
+	}
+
+	if f.NoNewPrivileges {
+		line("NoNewPrivileges", "true")
+	}
+
+	if f.WantedBy != "" {
+		b.WriteString("\n[Install]\n")
+		line("WantedBy", f.WantedBy)
+	}
+
+	return b.String()
+}
+
+func renderSelect(name string, value string, values []string, empty string) *Select {
+	s := NewSelect(name).SetValue(value)
+
+	for _, v := range values {
+		if v == "" {
+			s.AddNamedOption(empty, v)
+		} else {
+			s.AddOption(v)
+		}
+	}
+
+	return s
+}
+
+func renderServiceForm(f *serviceForm, errs formErrors, preview string) *Form {
+	input := func(name string, value string) *Input {
+		return NewInput(name).SetValue(value).SetErrorText(errs[name])
+	}
+
+	form := NewForm().
+		AddWithTitle("Name", input("name", f.Name)).
+		AddWithTitle("Description", input("description", f.Description)).
+		AddWithTitle("Type", renderSelect("type", f.Type, serviceTypes, "").SetErrorText(errs["type"])).
+		AddWithTitle("ExecStart", input("exec", f.Exec)).
+		AddWithTitle("User", input("user", f.User)).
+		AddWithTitle("Group", input("group", f.Group)).
+		AddWithTitle("WorkingDirectory", input("working-directory", f.WorkingDirectory)).
+		AddWithTitle("Environment (NAME=value per line)",
+			NewTextarea("environment").SetValue(f.Environment).SetErrorText(errs["environment"])).
+		AddWithTitle("EnvironmentFile", input("environment-file", f.EnvironmentFile)).
+		AddWithTitle("Restart", renderSelect("restart", f.Restart, restartPolicies, "").SetErrorText(errs["restart"])).
+		AddWithTitle("RestartSec", input("restart-sec", f.RestartSec)).
+		AddWithTitle("After", input("after", f.After)).
+		AddWithTitle("Wants", input("wants", f.Wants)).
+		AddWithTitle("Requires", input("requires", f.Requires)).
+		AddWithTitle("ProtectSystem",
+			renderSelect("protect-system", f.ProtectSystem, protectSystem, "no").SetErrorText(errs["protect-system"])).
+		AddWithTitle("PrivateTmp", NewSwitch("private-tmp").SetValue(f.PrivateTmp)).
+		AddWithTitle("NoNewPrivileges", NewSwitch("no-new-privileges").SetValue(f.NoNewPrivileges)).
+		AddWithTitle("WantedBy",
+			renderSelect("wanted-by", f.WantedBy, wantedByTargets, "not installed").SetErrorText(errs["wanted-by"])).
+		AddWithTitle("Enable and start", NewSwitch("start").SetValue(f.Start)).
+		Add(NewText("Dependencies are space separated unit names like \"network-online.target postgresql.service\"."))
+
+	if preview != "" {
+		lines := strings.Split(strings.TrimSuffix(preview, "\n"), "\n")
+
+		previewView := NewLogView("unit-preview", len(lines))
+		for _, l := range lines {
+			previewView.AddLines(&LogLine{Text: l})
+		}
+
+		form.Add(NewLabel("Unit file").SetStrong(true), previewView)
+	}
+
+	if general := errs[""]; general != "" {
+		form.Add(NewLabel("%s", general).SetStrong(true))
+	}
+
+	return form.AddActionButtons(
+		NewButton("Cancel", "none").SetStyle(StyleSecondary),
+		NewButton("Preview", "create-service", "preview").SetStyle(StyleSecondary),
+		NewButton("Create", "create-service", "save"),
+	)
+}
+
+func (p *Plugin) createService(f *serviceForm, content string, callerID string) error {
+	if err := p.connected(); err != nil {
+		return err
+	}
+
+	err := ioutil.WriteFile(filepath.Join(unitDir, f.unitName()), []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = p.dbusConn.ReloadContext(p.ctx)
+	if err != nil {
+		return err
+	}
+
+	if !f.Start {
+		return nil
+	}
+
+	if f.WantedBy != "" {
+		_, _, err = p.dbusConn.EnableUnitFilesContext(p.ctx, []string{f.unitName()}, false, false)
+		if err != nil {
+			return errors.Wrap(err, "failed to enable unit")
+		}
+	}
+
+	err = p.startUnit(f.unitName(), callerID)
+	if err != nil {
+		return errors.Wrap(err, "failed to start unit")
+	}
+
+	return nil
+}
+
+// createServiceAction is the unit creation wizard, the unit is written only after validation.
+func (p *Plugin) createServiceAction(args []string, data io.Reader) ActionResult {
+	if len(args) == 0 || args[0] == "" {
+		return NewFormModalActionResult("New service", renderServiceForm(newServiceForm(), nil, ""))
+	}
+
+	// unchecked switches may be missing in the data, so defaults aren't used
+	f := &serviceForm{}
+
+	err := json.NewDecoder(data).Decode(f)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	f.trim()
+
+	errs := f.validate(p.checkNewUnit)
+	if len(errs) > 0 {
+		return NewFormModalActionResult("New service", renderServiceForm(f, errs, ""))
+	}
+
+	content := f.render()
+
+	verifyErrs, _, err := verifyUnit(f.unitName(), &unitFile{path: filepath.Join(unitDir, f.unitName()), content: content}, nil)
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	if len(verifyErrs) > 0 {
+		errs[""] = strings.Join(verifyErrs, "\n")
+	}
+
+	if args[0] == "preview" || len(errs) > 0 {
+		return NewFormModalActionResult("New service", renderServiceForm(f, errs, content))
+	}
+
+	err = p.createService(f, content, CallerID(data))
+	if err != nil {
+		return NewErrorAlertActionResult(err)
+	}
+
+	return NewReloadActionResult()
+}
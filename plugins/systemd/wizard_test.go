@@ -0,0 +1,25 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceFormEscaping(t *testing.T) {
+	f := newServiceForm()
+	f.Name = "app"
+	f.Exec = "/usr/bin/app"
+	f.WorkingDirectory = "/srv/100%"
+	f.EnvironmentFile = "-/etc/app/%i.env"
+
+	unit := f.render()
+
+	for _, line := range []string{
+		"WorkingDirectory=/srv/100%%\n",
+		"EnvironmentFile=-/etc/app/%%i.env\n",
+	} {
+		if !strings.Contains(unit, line) {
+			t.Errorf("unit has no %q:\n%s", line, unit)
+		}
+	}
+}